@@ -0,0 +1,268 @@
+package makaroni
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StoredPaste is a paste as found in storage, with its raw and HTML objects paired up.
+// Raw or HTML is nil when that object is missing.
+type StoredPaste struct {
+	ID      string
+	RawKey  string
+	HtmlKey string
+	Raw     *ObjectInfo
+	HTML    *ObjectInfo
+}
+
+// Metadata returns the paste metadata, preferring the HTML object which holds the view counter
+func (s *StoredPaste) Metadata() map[string]string {
+	if s.HTML != nil {
+		return s.HTML.Metadata
+	}
+	if s.Raw != nil {
+		return s.Raw.Metadata
+	}
+	return nil
+}
+
+// Size returns the size of the raw content
+func (s *StoredPaste) Size() int64 {
+	if s.Raw == nil {
+		return 0
+	}
+	return s.Raw.Size
+}
+
+// ContentType returns the content type of the raw content
+func (s *StoredPaste) ContentType() string {
+	if s.Raw == nil {
+		return ""
+	}
+	return s.Raw.ContentType
+}
+
+// CreateTime returns when the paste was written, the earliest modification time of its objects
+func (s *StoredPaste) CreateTime() time.Time {
+	switch {
+	case s.Raw == nil && s.HTML == nil:
+		return time.Time{}
+	case s.Raw == nil:
+		return s.HTML.LastModified
+	case s.HTML == nil || s.Raw.LastModified.Before(s.HTML.LastModified):
+		return s.Raw.LastModified
+	default:
+		return s.HTML.LastModified
+	}
+}
+
+// Keys returns the storage keys of the objects that exist
+func (s *StoredPaste) Keys() []string {
+	var keys []string
+	if s.Raw != nil {
+		keys = append(keys, s.RawKey)
+	}
+	if s.HTML != nil {
+		keys = append(keys, s.HtmlKey)
+	}
+	return keys
+}
+
+// DeleteKeyType describes how the delete key is stored: "hash", "plaintext" or "none"
+func (s *StoredPaste) DeleteKeyType() string {
+	metadata := s.Metadata()
+	switch {
+	case metadata[deleteHashMetadataKey] != "":
+		return "hash"
+	case metadata[legacyDeleteMetadataKey] != "":
+		return "plaintext"
+	default:
+		return "none"
+	}
+}
+
+// DisplayMetadata returns the paste metadata without delete key material
+func (s *StoredPaste) DisplayMetadata() map[string]string {
+	metadata := copyMetadata(s.Metadata())
+	delete(metadata, deleteHashMetadataKey)
+	delete(metadata, legacyDeleteMetadataKey)
+	return metadata
+}
+
+// PasteFilter selects pastes by age, size and content type, zero fields match everything
+type PasteFilter struct {
+	OlderThan   time.Duration
+	NewerThan   time.Duration
+	MinSize     int64
+	MaxSize     int64
+	ContentType string // Prefix of the content type, e.g. "image/"
+}
+
+// Empty reports whether the filter matches every paste
+func (f PasteFilter) Empty() bool {
+	return f == PasteFilter{}
+}
+
+// Match reports whether the paste passes the filter at the given time
+func (f PasteFilter) Match(paste *StoredPaste, now time.Time) bool {
+	age := now.Sub(paste.CreateTime())
+	if f.OlderThan > 0 && age < f.OlderThan {
+		return false
+	}
+	if f.NewerThan > 0 && age > f.NewerThan {
+		return false
+	}
+	if f.MinSize > 0 && paste.Size() < f.MinSize {
+		return false
+	}
+	if f.MaxSize > 0 && paste.Size() > f.MaxSize {
+		return false
+	}
+	if f.ContentType != "" && !strings.HasPrefix(paste.ContentType(), f.ContentType) {
+		return false
+	}
+	return true
+}
+
+// ListPastes lists all pastes in storage, calling fn for each in key order.
+// Objects without a counterpart are reported as pastes with a nil Raw or HTML.
+func ListPastes(ctx context.Context, storage Storage, fn func(paste *StoredPaste) error) error {
+	var keys []string
+	err := storage.ListObjects(ctx, "", func(info *ObjectInfo) error {
+		if !isInternalKey(info.Key) {
+			keys = append(keys, info.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(keys)
+
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+
+		paste, err := loadPaste(ctx, storage, key)
+		if errors.Is(err, ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		seen[paste.RawKey] = true
+		seen[paste.HtmlKey] = true
+		if err := fn(paste); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindPaste looks up a paste by its ID or the key of either of its objects
+func FindPaste(ctx context.Context, storage Storage, idOrKey string) (*StoredPaste, error) {
+	if isInternalKey(idOrKey) {
+		return nil, ErrObjectNotFound
+	}
+
+	candidates := []string{idOrKey}
+	if !strings.HasSuffix(idOrKey, ".html") {
+		stem := strings.TrimSuffix(idOrKey, path.Ext(idOrKey))
+		candidates = []string{idOrKey + ".html", idOrKey, stem + ".html"}
+	}
+
+	for _, key := range candidates {
+		paste, err := loadPaste(ctx, storage, key)
+		if errors.Is(err, ErrObjectNotFound) {
+			continue
+		}
+		return paste, err
+	}
+	return nil, ErrObjectNotFound
+}
+
+// loadPaste reads the object under key and its counterpart
+func loadPaste(ctx context.Context, storage Storage, key string) (*StoredPaste, error) {
+	info, err := storage.HeadObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	rawKey, htmlKey := pasteKeys(key, info.Metadata)
+	paste := &StoredPaste{ID: strings.TrimSuffix(htmlKey, ".html"), RawKey: rawKey, HtmlKey: htmlKey}
+	if key == htmlKey {
+		paste.HTML = info
+		paste.Raw, err = headIfExists(ctx, storage, rawKey)
+		if err == nil && paste.Raw == nil && info.Metadata[rawKeyMetadataKey] == "" {
+			err = findLegacyRaw(ctx, storage, paste)
+		}
+	} else {
+		paste.Raw = info
+		paste.HTML, err = headIfExists(ctx, storage, htmlKey)
+		if err == nil && paste.HTML == nil && info.Metadata[htmlKeyMetadataKey] == "" {
+			// Raw keys of older file pastes carry the file extension and no link to their page
+			stem := strings.TrimSuffix(key, path.Ext(key))
+			if paste.HTML, err = headIfExists(ctx, storage, stem+".html"); paste.HTML != nil {
+				paste.ID, paste.HtmlKey = stem, stem+".html"
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return paste, nil
+}
+
+// findLegacyRaw looks for the raw object of an older file paste, stored as "<id>.<extension>"
+func findLegacyRaw(ctx context.Context, storage Storage, paste *StoredPaste) error {
+	errFound := errors.New("found")
+	err := storage.ListObjects(ctx, paste.ID+".", func(info *ObjectInfo) error {
+		if info.Key == paste.HtmlKey || strings.Contains(strings.TrimPrefix(info.Key, paste.ID+"."), ".") {
+			return nil
+		}
+		raw, err := storage.HeadObject(ctx, info.Key)
+		if err != nil {
+			return err
+		}
+		paste.RawKey, paste.Raw = info.Key, raw
+		return errFound
+	})
+	if errors.Is(err, errFound) || errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	return err
+}
+
+// headIfExists returns the object info, or nil when the object does not exist
+func headIfExists(ctx context.Context, storage Storage, key string) (*ObjectInfo, error) {
+	info, err := storage.HeadObject(ctx, key)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, nil
+	}
+	return info, err
+}
+
+// DeletePastes removes all objects of the pastes, regardless of their delete keys
+func DeletePastes(ctx context.Context, storage Storage, pastes []*StoredPaste) error {
+	var keys []string
+	for _, paste := range pastes {
+		keys = append(keys, paste.Keys()...)
+	}
+
+	for start := 0; start < len(keys); start += sweepBatchSize {
+		end := start + sweepBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := storage.DeleteObjects(ctx, keys[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package makaroni
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestListPastesPairsObjects(t *testing.T) {
+	handler, storage := newTestHandler(t)
+	ctx := context.Background()
+
+	text := pasteCookie(t, serve(handler, newMultipartRequest(t, map[string]string{"content": "hello"}, nil)))
+	file := &testFile{field: "file", name: "photo.png", contentType: "image/png", content: "png"}
+	image := pasteCookie(t, serve(handler, newMultipartRequest(t, nil, file)))
+
+	// Older file pastes have no links between their objects
+	legacy := map[string]string{legacyDeleteMetadataKey: "key"}
+	if err := storage.UploadString(ctx, "legacy.pdf", "%PDF", "application/pdf", legacy); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if err := storage.UploadString(ctx, "legacy.html", "<html>", contentTypeHTML, legacy); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if err := storage.UploadString(ctx, "orphan", "raw only", contentTypeText, nil); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if err := storage.UploadString(ctx, burnedKeyPrefix+"gone", "", contentTypeText, nil); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	pastes := map[string]*StoredPaste{}
+	err := ListPastes(ctx, storage, func(paste *StoredPaste) error {
+		pastes[paste.ID] = paste
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("list pastes: %v", err)
+	}
+	if len(pastes) != 4 {
+		t.Fatalf("expected 4 pastes, got %d", len(pastes))
+	}
+
+	if paste := pastes[pasteID(text.HtmlKey)]; paste == nil || paste.Raw == nil || paste.HTML == nil || paste.DeleteKeyType() != "hash" {
+		t.Fatalf("text paste not paired: %+v", paste)
+	}
+	if paste := pastes[pasteID(image.HtmlKey)]; paste == nil || paste.RawKey != image.RawKey || paste.ContentType() != "image/png" {
+		t.Fatalf("file paste not paired: %+v", paste)
+	}
+	if paste := pastes["legacy"]; paste == nil || paste.RawKey != "legacy.pdf" || paste.HTML == nil || paste.DeleteKeyType() != "plaintext" {
+		t.Fatalf("legacy paste not paired: %+v", paste)
+	}
+	if paste := pastes["orphan"]; paste == nil || paste.HTML != nil || len(paste.Keys()) != 1 {
+		t.Fatalf("orphan not reported: %+v", paste)
+	}
+	if _, exists := pastes[pasteID(text.HtmlKey)].DisplayMetadata()[deleteHashMetadataKey]; exists {
+		t.Fatal("display metadata exposes the delete hash")
+	}
+
+	for _, id := range []string{"legacy", "legacy.pdf", "legacy.html"} {
+		paste, err := FindPaste(ctx, storage, id)
+		if err != nil || paste.RawKey != "legacy.pdf" || paste.HtmlKey != "legacy.html" {
+			t.Fatalf("find %s: %+v %v", id, paste, err)
+		}
+	}
+
+	if err := DeletePastes(ctx, storage, []*StoredPaste{pastes["legacy"], pastes["orphan"]}); err != nil {
+		t.Fatalf("delete pastes: %v", err)
+	}
+	if _, err := FindPaste(ctx, storage, "legacy"); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("expected deleted paste to be gone, got %v", err)
+	}
+}
+
+func TestPasteFilterMatch(t *testing.T) {
+	now := time.Now()
+	paste := &StoredPaste{Raw: &ObjectInfo{Size: 2048, ContentType: "image/png", LastModified: now.Add(-48 * time.Hour)}}
+
+	tests := []struct {
+		filter PasteFilter
+		match  bool
+	}{
+		{PasteFilter{}, true},
+		{PasteFilter{OlderThan: 24 * time.Hour}, true},
+		{PasteFilter{OlderThan: 72 * time.Hour}, false},
+		{PasteFilter{NewerThan: 24 * time.Hour}, false},
+		{PasteFilter{MinSize: 1024, MaxSize: 4096}, true},
+		{PasteFilter{MaxSize: 1024}, false},
+		{PasteFilter{ContentType: "image/"}, true},
+		{PasteFilter{ContentType: "text/"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Match(paste, now); got != tt.match {
+			t.Errorf("%+v: expected %v, got %v", tt.filter, tt.match, got)
+		}
+	}
+}
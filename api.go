@@ -0,0 +1,329 @@
+package makaroni
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// apiPastesPath is the root of the versioned JSON API
+	apiPastesPath      = "/api/v1/pastes"
+	deleteKeyHeader    = "X-Delete-Key"
+	contentTypeJSON    = "application/json"
+	defaultPasteSyntax = "plaintext"
+)
+
+// apiCreateRequest is the JSON body accepted by POST /api/v1/pastes
+type apiCreateRequest struct {
+	Content string `json:"content"`
+	Syntax  string `json:"syntax"`
+	Expire  string `json:"expire"`
+	Views   int    `json:"views"`
+	Burn    bool   `json:"burn"`
+	Slug    string `json:"slug"`
+
+	Encrypted bool   `json:"encrypted"` // Content is an EncryptedPaste sealed by the client
+	Password  string `json:"password"`
+}
+
+// PasteInfo describes a paste in API responses
+type PasteInfo struct {
+	ID          string     `json:"id"`
+	URL         string     `json:"url"`
+	RawURL      string     `json:"rawUrl"`
+	DeleteURL   string     `json:"deleteUrl,omitempty"` // Only returned on creation
+	DeleteKey   string     `json:"deleteKey,omitempty"` // Only returned on creation
+	Size        int64      `json:"size"`
+	ContentType string     `json:"contentType,omitempty"`
+	Syntax      string     `json:"syntax,omitempty"`
+	FileName    string     `json:"filename,omitempty"`
+	CreateTime  *time.Time `json:"createTime,omitempty"`
+	Expire      *time.Time `json:"expire,omitempty"`
+	MaxViews    int        `json:"maxViews,omitempty"`
+	Views       int        `json:"views,omitempty"`
+	Encrypted   bool       `json:"encrypted,omitempty"` // End-to-end encrypted, the raw content is an EncryptedPaste
+	Protected   bool       `json:"protected,omitempty"` // A password is required to read the paste
+}
+
+// apiError is the JSON body of every API error
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// handleAPIRequest routes requests under /api/v1/pastes
+func (p *PasteHandler) handleAPIRequest(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, apiPastesPath), "/")
+	if path == "" {
+		if req.Method != http.MethodPost {
+			p.respondAPIMethodNotAllowed(w, http.MethodPost)
+			return
+		}
+		p.handleAPICreate(w, req)
+		return
+	}
+
+	id, action, _ := strings.Cut(path, "/")
+	if isInternalKey(id) {
+		respondJSONError(w, http.StatusNotFound, "Paste not found")
+		return
+	}
+
+	switch action {
+	case "":
+		switch req.Method {
+		case http.MethodGet, http.MethodHead:
+			p.handleAPIGet(w, req, id)
+		case http.MethodDelete:
+			p.handleAPIDelete(w, req, id)
+		default:
+			p.respondAPIMethodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodDelete)
+		}
+	case "raw":
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			p.respondAPIMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+			return
+		}
+		p.handleAPIRaw(w, req, id)
+	default:
+		respondJSONError(w, http.StatusNotFound, "Unknown endpoint")
+	}
+}
+
+// handleAPICreate creates a paste from a JSON body or a multipart form
+func (p *PasteHandler) handleAPICreate(w http.ResponseWriter, req *http.Request) {
+	var pr *pasteRequest
+	var result *pasteResult
+	var err error
+	if isMultipartRequest(req) {
+		if pr, result, err = p.streamMultipartPaste(req.Context(), req); err != nil {
+			log.Warn("Error reading form: ", err)
+			status, message := pasteErrorStatus(err)
+			respondJSONError(w, status, message)
+			return
+		}
+	} else {
+		var body apiCreateRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxRawPasteSize))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			log.Warn("Error decoding JSON body: ", err)
+			respondJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+			return
+		}
+		pr = body.pasteRequest()
+	}
+
+	if result == nil {
+		if pr.empty() {
+			respondJSONError(w, http.StatusBadRequest, "Empty paste")
+			return
+		}
+		if result, err = p.createPaste(req.Context(), pr); err != nil {
+			status, message := pasteErrorStatus(err)
+			respondJSONError(w, status, message)
+			return
+		}
+	}
+
+	paste := p.createdPasteInfo(pr, result)
+	w.Header().Set("Location", p.apiURL(paste.ID))
+	respondJSON(w, http.StatusCreated, paste)
+}
+
+// createdPasteInfo describes a paste that was just created, including its delete key
+func (p *PasteHandler) createdPasteInfo(pr *pasteRequest, result *pasteResult) PasteInfo {
+	paste := PasteInfo{
+		ID:          pasteID(result.HtmlKey),
+		URL:         result.URL,
+		RawURL:      result.RawURL,
+		DeleteKey:   result.DeleteKey,
+		Size:        result.Size,
+		ContentType: contentTypeText,
+		Syntax:      pr.Syntax,
+		MaxViews:    result.MaxViews,
+		Protected:   result.Protected,
+	}
+	paste.DeleteURL = p.apiURL(paste.ID) + "?" + url.Values{"key": {result.DeleteKey}}.Encode()
+	if result.Encrypted {
+		paste.ContentType = contentTypeJSON
+		paste.Encrypted = true
+	}
+	if pr.isFile() {
+		paste.ContentType = pr.FileType
+		paste.FileName = pr.FileName
+	} else if paste.Syntax == "" {
+		paste.Syntax = defaultPasteSyntax
+	}
+	if !result.Expire.IsZero() {
+		paste.Expire = &result.Expire
+	}
+	return paste
+}
+
+// handleAPIGet returns the metadata of a paste without using up a view
+func (p *PasteHandler) handleAPIGet(w http.ResponseWriter, req *http.Request, id string) {
+	rawKey, htmlMetadata, ok := p.resolveAPIPaste(req.Context(), w, id)
+	if !ok {
+		return
+	}
+
+	rawInfo, err := p.Storage.HeadObject(req.Context(), rawKey)
+	if errors.Is(err, ErrObjectNotFound) {
+		respondJSONError(w, http.StatusNotFound, "Paste not found")
+		return
+	}
+	if err != nil {
+		log.Error("Error retrieving paste info: ", err)
+		respondJSONError(w, http.StatusInternalServerError, "Failed to retrieve paste")
+		return
+	}
+
+	paste := PasteInfo{
+		ID:          id,
+		Size:        rawInfo.Size,
+		ContentType: rawInfo.ContentType,
+		Syntax:      htmlMetadata[syntaxMetadataKey],
+		Encrypted:   htmlMetadata[encryptedMetadataKey] != "",
+		Protected:   isProtected(htmlMetadata),
+	}
+	paste.URL, paste.RawURL = p.pasteURLs(rawKey, id+".html")
+	if name := htmlMetadata[filenameMetadataKey]; name != "" {
+		paste.FileName, _ = url.PathUnescape(name)
+	} else if paste.Syntax == "" {
+		paste.Syntax = defaultPasteSyntax
+	}
+	if !rawInfo.LastModified.IsZero() {
+		createTime := rawInfo.LastModified.UTC()
+		paste.CreateTime = &createTime
+	}
+	if expire, ok := expireTime(htmlMetadata); ok {
+		paste.Expire = &expire
+	}
+	paste.MaxViews, _ = strconv.Atoi(htmlMetadata[maxViewsMetadataKey])
+	paste.Views, _ = strconv.Atoi(htmlMetadata[viewsMetadataKey])
+
+	respondJSON(w, http.StatusOK, paste)
+}
+
+// handleAPIRaw streams the raw content of a paste, using up a view of view-limited pastes
+func (p *PasteHandler) handleAPIRaw(w http.ResponseWriter, req *http.Request, id string) {
+	rawKey, _, ok := p.resolveAPIPaste(req.Context(), w, id)
+	if !ok {
+		return
+	}
+	p.serveObject(w, req, rawKey, respondJSONError)
+}
+
+// handleAPIDelete removes a paste, the delete key is read from the X-Delete-Key header or the key parameter
+func (p *PasteHandler) handleAPIDelete(w http.ResponseWriter, req *http.Request, id string) {
+	deleteKey := req.Header.Get(deleteKeyHeader)
+	if deleteKey == "" {
+		deleteKey = req.URL.Query().Get("key")
+	}
+	if deleteKey == "" {
+		respondJSONError(w, http.StatusBadRequest, "Missing delete key")
+		return
+	}
+
+	rawKey, _, ok := p.resolveAPIPaste(req.Context(), w, id)
+	if !ok {
+		return
+	}
+
+	err := p.deletePaste(req.Context(), rawKey, id+".html", deleteKey)
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, ErrObjectNotFound):
+		respondJSONError(w, http.StatusNotFound, "Paste not found")
+	case errors.Is(err, ErrInvalidDeleteKey):
+		respondJSONError(w, http.StatusForbidden, "Invalid delete key")
+	default:
+		respondJSONError(w, http.StatusInternalServerError, "Failed to delete paste")
+	}
+}
+
+// resolveAPIPaste looks up the raw key and metadata of a paste by ID and reports missing or expired pastes
+func (p *PasteHandler) resolveAPIPaste(ctx context.Context, w http.ResponseWriter, id string) (string, map[string]string, bool) {
+	htmlKey := id + ".html"
+	metadata, err := p.Storage.GetMetadata(ctx, htmlKey)
+	if errors.Is(err, ErrObjectNotFound) {
+		if p.isBurned(ctx, htmlKey) {
+			respondJSONError(w, http.StatusGone, burnedMessage)
+			return "", nil, false
+		}
+		respondJSONError(w, http.StatusNotFound, "Paste not found")
+		return "", nil, false
+	}
+	if err != nil {
+		log.Error("Error retrieving paste metadata: ", err)
+		respondJSONError(w, http.StatusInternalServerError, "Failed to retrieve paste")
+		return "", nil, false
+	}
+	if isExpired(metadata, time.Now()) {
+		respondJSONError(w, http.StatusGone, "Paste has expired")
+		return "", nil, false
+	}
+
+	rawKey, _ := pasteKeys(htmlKey, metadata)
+	return rawKey, metadata, true
+}
+
+// pasteRequest converts the JSON body into a paste request
+func (r *apiCreateRequest) pasteRequest() *pasteRequest {
+	pr := &pasteRequest{
+		Content:  r.Content,
+		Syntax:   r.Syntax,
+		Expire:   r.Expire,
+		Slug:     r.Slug,
+		Password: r.Password,
+	}
+	if r.Views > 0 {
+		pr.Views = strconv.Itoa(r.Views)
+	}
+	if r.Burn {
+		pr.Burn = "true"
+	}
+	if r.Encrypted {
+		pr.Encrypted = "true"
+	}
+	return pr
+}
+
+// pasteID returns the public ID of a paste, the HTML key without its extension
+func pasteID(htmlKey string) string {
+	return strings.TrimSuffix(htmlKey, ".html")
+}
+
+// apiURL returns the API URL of a paste
+func (p *PasteHandler) apiURL(id string) string {
+	return strings.TrimSuffix(p.Config.IndexURL, "/") + apiPastesPath + "/" + url.PathEscape(id)
+}
+
+// respondAPIMethodNotAllowed answers with 405 and the allowed methods
+func (p *PasteHandler) respondAPIMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	respondJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+}
+
+// respondJSON writes the value as a JSON response
+func respondJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Error("Error writing JSON response: ", err)
+	}
+}
+
+// respondJSONError writes an API error
+func respondJSONError(w http.ResponseWriter, statusCode int, message string) {
+	respondJSON(w, statusCode, apiError{Error: message})
+}
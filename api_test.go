@@ -0,0 +1,161 @@
+package makaroni
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// createAPIPaste posts a JSON body to the API and decodes the created paste
+func createAPIPaste(t *testing.T, handler http.Handler, body string) PasteInfo {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, apiPastesPath, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentTypeJSON)
+	resp := serve(handler, req)
+	if resp.StatusCode != http.StatusCreated {
+		data, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 201, got %d: %s", resp.StatusCode, data)
+	}
+	var paste PasteInfo
+	if err := json.NewDecoder(resp.Body).Decode(&paste); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return paste
+}
+
+// decodeAPIError checks the status and returns the JSON error message
+func decodeAPIError(t *testing.T, resp *http.Response, status int) string {
+	t.Helper()
+
+	if resp.StatusCode != status {
+		t.Fatalf("expected %d, got %d", status, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != contentTypeJSON {
+		t.Fatalf("expected JSON error, got %q", ct)
+	}
+	var body apiError
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	return body.Error
+}
+
+func TestAPICreateAndGet(t *testing.T) {
+	handler, _ := newTestHandler(t)
+
+	created := createAPIPaste(t, handler, `{"content": "package main\n", "syntax": "go", "expire": "1h", "views": 5}`)
+	if created.ID == "" || created.DeleteKey == "" || created.Size != 13 || created.Syntax != "go" {
+		t.Fatalf("unexpected created paste %+v", created)
+	}
+	if created.URL != testURLPrefix+created.ID+".html" || created.Expire == nil || created.MaxViews != 5 {
+		t.Fatalf("unexpected created paste %+v", created)
+	}
+
+	resp := serve(handler, httptest.NewRequest(http.MethodGet, apiPastesPath+"/"+created.ID, nil))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var paste PasteInfo
+	if err := json.NewDecoder(resp.Body).Decode(&paste); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if paste.DeleteKey != "" {
+		t.Fatal("metadata exposes the delete key")
+	}
+	if paste.RawURL != created.RawURL || paste.Size != 13 || paste.Syntax != "go" || paste.MaxViews != 5 || paste.Views != 0 {
+		t.Fatalf("unexpected paste metadata %+v", paste)
+	}
+
+	resp = serve(handler, httptest.NewRequest(http.MethodGet, apiPastesPath+"/"+created.ID+"/raw", nil))
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "package main\n" {
+		t.Fatalf("unexpected raw response %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestAPICreateMultipartFile(t *testing.T) {
+	handler, _ := newTestHandler(t)
+
+	file := &testFile{field: "file", name: "notes.txt", contentType: "text/plain", content: "hello"}
+	req := newMultipartRequest(t, nil, file)
+	req.URL.Path = apiPastesPath
+	resp := serve(handler, req)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	var paste PasteInfo
+	if err := json.NewDecoder(resp.Body).Decode(&paste); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if paste.FileName != "notes.txt" || paste.Syntax != "" || !strings.HasSuffix(paste.RawURL, ".txt") {
+		t.Fatalf("unexpected file paste %+v", paste)
+	}
+}
+
+func TestAPIDelete(t *testing.T) {
+	handler, storage := newTestHandler(t)
+	created := createAPIPaste(t, handler, `{"content": "secret"}`)
+	path := apiPastesPath + "/" + created.ID
+
+	req := httptest.NewRequest(http.MethodDelete, path, nil)
+	req.Header.Set(deleteKeyHeader, "wrong")
+	decodeAPIError(t, serve(handler, req), http.StatusForbidden)
+
+	req = httptest.NewRequest(http.MethodDelete, path, nil)
+	req.Header.Set(deleteKeyHeader, created.DeleteKey)
+	if resp := serve(handler, req); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", resp.StatusCode)
+	}
+	if len(storage.objects) != 0 {
+		t.Fatalf("expected no stored objects, got %d", len(storage.objects))
+	}
+
+	decodeAPIError(t, serve(handler, httptest.NewRequest(http.MethodGet, path, nil)), http.StatusNotFound)
+}
+
+func TestAPIErrors(t *testing.T) {
+	handler, _ := newTestHandler(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"invalid json", http.MethodPost, apiPastesPath, `{"content":`, http.StatusBadRequest},
+		{"unknown field", http.MethodPost, apiPastesPath, `{"text": "x"}`, http.StatusBadRequest},
+		{"empty paste", http.MethodPost, apiPastesPath, `{}`, http.StatusBadRequest},
+		{"invalid expire", http.MethodPost, apiPastesPath, `{"content": "x", "expire": "soon"}`, http.StatusBadRequest},
+		{"reserved slug", http.MethodPost, apiPastesPath, `{"content": "x", "slug": "admin"}`, http.StatusBadRequest},
+		{"list not allowed", http.MethodGet, apiPastesPath, "", http.StatusMethodNotAllowed},
+		{"missing paste", http.MethodGet, apiPastesPath + "/missing", "", http.StatusNotFound},
+		{"internal key", http.MethodGet, apiPastesPath + "/.burned", "", http.StatusNotFound},
+		{"unknown endpoint", http.MethodGet, apiPastesPath + "/missing/html", "", http.StatusNotFound},
+		{"delete without key", http.MethodDelete, apiPastesPath + "/missing", "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if message := decodeAPIError(t, serve(handler, req), tt.status); message == "" {
+				t.Fatal("empty error message")
+			}
+		})
+	}
+}
+
+func TestAPIBurnedPaste(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	created := createAPIPaste(t, handler, `{"content": "once", "burn": true}`)
+	rawPath := apiPastesPath + "/" + created.ID + "/raw"
+
+	if resp := serve(handler, httptest.NewRequest(http.MethodGet, rawPath, nil)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for the first view, got %d", resp.StatusCode)
+	}
+	decodeAPIError(t, serve(handler, httptest.NewRequest(http.MethodGet, rawPath, nil)), http.StatusGone)
+}
@@ -0,0 +1,212 @@
+package makaroni
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const clientTimeout = 5 * time.Minute
+
+// Client talks to a makaroni server through the JSON API
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// PasteOptions describes a paste to create with the Client
+type PasteOptions struct {
+	Syntax string
+	Expire string
+	Views  int
+	Burn   bool
+	Slug   string
+
+	// Password protects the paste, viewers are asked for it
+	Password string
+
+	// Encrypt seals a text paste before it is sent, the key is added to the returned URL as its fragment
+	Encrypt bool
+
+	// FileName uploads the content as a file instead of a highlighted text paste
+	FileName    string
+	ContentType string
+}
+
+// APIError is returned by the Client when the server answers with an error
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server responded with %d: %s", e.StatusCode, e.Message)
+}
+
+// NewClient creates a Client for the server at baseURL
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: clientTimeout},
+	}
+}
+
+// CreatePaste uploads content and returns the created paste including its delete key
+func (c *Client) CreatePaste(ctx context.Context, content io.Reader, opts PasteOptions) (*PasteInfo, error) {
+	var body io.Reader
+	var contentType string
+	var key string
+
+	if opts.Encrypt && opts.FileName != "" {
+		return nil, errors.New("only text pastes can be encrypted")
+	}
+
+	if opts.FileName != "" {
+		buf := &bytes.Buffer{}
+		writer := multipart.NewWriter(buf)
+		fields := map[string]string{"expire": opts.Expire, "slug": opts.Slug, "password": opts.Password}
+		if opts.Views > 0 {
+			fields["views"] = strconv.Itoa(opts.Views)
+		}
+		if opts.Burn {
+			fields["burn"] = "true"
+		}
+		for name, value := range fields {
+			if value == "" {
+				continue
+			}
+			if err := writer.WriteField(name, value); err != nil {
+				return nil, err
+			}
+		}
+
+		part, err := writer.CreatePart(filePartHeader(opts.FileName, opts.ContentType))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(part, content); err != nil {
+			return nil, fmt.Errorf("failed to read content: %w", err)
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		body, contentType = buf, writer.FormDataContentType()
+	} else {
+		data, err := io.ReadAll(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read content: %w", err)
+		}
+		text := string(data)
+		if opts.Encrypt {
+			if text, key, err = SealPaste(text); err != nil {
+				return nil, fmt.Errorf("failed to encrypt content: %w", err)
+			}
+		}
+		encoded, err := json.Marshal(apiCreateRequest{
+			Content:   text,
+			Syntax:    opts.Syntax,
+			Expire:    opts.Expire,
+			Views:     opts.Views,
+			Burn:      opts.Burn,
+			Slug:      opts.Slug,
+			Encrypted: opts.Encrypt,
+			Password:  opts.Password,
+		})
+		if err != nil {
+			return nil, err
+		}
+		body, contentType = bytes.NewReader(encoded), contentTypeJSON
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+apiPastesPath, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	var paste PasteInfo
+	if err := c.do(req, http.StatusCreated, &paste); err != nil {
+		return nil, err
+	}
+	if key != "" {
+		paste.URL += "#" + key
+	}
+	return &paste, nil
+}
+
+// GetPaste returns the metadata of a paste
+func (c *Client) GetPaste(ctx context.Context, id string) (*PasteInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+apiPastesPath+"/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var paste PasteInfo
+	if err := c.do(req, http.StatusOK, &paste); err != nil {
+		return nil, err
+	}
+	return &paste, nil
+}
+
+// DeletePaste removes a paste using its delete key
+func (c *Client) DeletePaste(ctx context.Context, id, deleteKey string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.BaseURL+apiPastesPath+"/"+url.PathEscape(id), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(deleteKeyHeader, deleteKey)
+
+	return c.do(req, http.StatusNoContent, nil)
+}
+
+// do sends the request and decodes the JSON response into result, or the API error on unexpected statuses
+func (c *Client) do(req *http.Request, expectedStatus int, result interface{}) error {
+	req.Header.Set("Accept", contentTypeJSON)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expectedStatus {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		var body apiError
+		if err := json.NewDecoder(resp.Body).Decode(&body); err == nil && body.Error != "" {
+			apiErr.Message = body.Error
+		}
+		return apiErr
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// quoteEscaper escapes a file name for the Content-Disposition header, like mime/multipart does
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// filePartHeader builds the multipart header of the uploaded file
+func filePartHeader(fileName, contentType string) textproto.MIMEHeader {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, quoteEscaper.Replace(fileName)))
+	header.Set("Content-Type", contentType)
+	return header
+}
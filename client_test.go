@@ -0,0 +1,51 @@
+package makaroni
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientRoundTrip(t *testing.T) {
+	handler, storage := newTestHandler(t)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := NewClient(server.URL + "/")
+	ctx := context.Background()
+
+	paste, err := client.CreatePaste(ctx, strings.NewReader("package main\n"), PasteOptions{Syntax: "go", Expire: "1d"})
+	if err != nil {
+		t.Fatalf("create paste: %v", err)
+	}
+	if paste.DeleteKey == "" || paste.Syntax != "go" || paste.Expire == nil {
+		t.Fatalf("unexpected paste %+v", paste)
+	}
+
+	file, err := client.CreatePaste(ctx, strings.NewReader("\x00\x01"), PasteOptions{FileName: `we"ird.bin`, Burn: true})
+	if err != nil {
+		t.Fatalf("create file paste: %v", err)
+	}
+	info, err := client.GetPaste(ctx, file.ID)
+	if err != nil {
+		t.Fatalf("get paste: %v", err)
+	}
+	if info.FileName != `we"ird.bin` || info.ContentType != "application/octet-stream" || info.MaxViews != 1 {
+		t.Fatalf("unexpected file paste %+v", info)
+	}
+
+	err = client.DeletePaste(ctx, paste.ID, "wrong")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden || apiErr.Message != "Invalid delete key" {
+		t.Fatalf("expected API error for a wrong delete key, got %v", err)
+	}
+	if err := client.DeletePaste(ctx, paste.ID, paste.DeleteKey); err != nil {
+		t.Fatalf("delete paste: %v", err)
+	}
+	if _, err := storage.HeadObject(ctx, paste.ID); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("paste still exists after delete: %v", err)
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kaero/makaroni"
+	"github.com/spf13/cobra"
+)
+
+// adminFilterFlags holds the paste filter options shared by list and purge
+type adminFilterFlags struct {
+	olderThan   string
+	newerThan   string
+	minSize     string
+	maxSize     string
+	contentType string
+}
+
+// register adds the filter flags to the command
+func (f *adminFilterFlags) register(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&f.olderThan, "older-than", "", "Only pastes older than this age (e.g. 12h, 7d, 2w)")
+	flags.StringVar(&f.newerThan, "newer-than", "", "Only pastes newer than this age")
+	flags.StringVar(&f.minSize, "min-size", "", "Only pastes of at least this size (e.g. 512K, 10M)")
+	flags.StringVar(&f.maxSize, "max-size", "", "Only pastes of at most this size")
+	flags.StringVar(&f.contentType, "content-type", "", "Only pastes whose content type starts with this value (e.g. image/)")
+}
+
+// filter parses the flags into a paste filter
+func (f *adminFilterFlags) filter() (makaroni.PasteFilter, error) {
+	var filter makaroni.PasteFilter
+	var err error
+
+	if filter.OlderThan, err = makaroni.ParseExpire(f.olderThan); err != nil {
+		return filter, fmt.Errorf("invalid --older-than: %w", err)
+	}
+	if filter.NewerThan, err = makaroni.ParseExpire(f.newerThan); err != nil {
+		return filter, fmt.Errorf("invalid --newer-than: %w", err)
+	}
+	if filter.MinSize, err = parseSize(f.minSize); err != nil {
+		return filter, fmt.Errorf("invalid --min-size: %w", err)
+	}
+	if filter.MaxSize, err = parseSize(f.maxSize); err != nil {
+		return filter, fmt.Errorf("invalid --max-size: %w", err)
+	}
+	filter.ContentType = f.contentType
+	return filter, nil
+}
+
+// newAdminCommand creates the commands operating directly on the storage
+func newAdminCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Inspect and remove stored pastes",
+		Long: "Inspect and remove pastes directly in the configured storage, without delete keys.\n" +
+			"Uses the same storage settings as the server. With deduplication, stop the server before\n" +
+			"deleting pastes, reference counts are only kept consistent within one process.",
+	}
+
+	cmd.AddCommand(newAdminListCommand(), newAdminShowCommand(), newAdminDeleteCommand(), newAdminPurgeCommand(), newAdminRekeyCommand(), newAdminHashDeleteKeysCommand())
+	for _, sub := range cmd.Commands() {
+		sub.SilenceUsage = true
+		sub.SilenceErrors = true
+	}
+	return cmd
+}
+
+// newAdminListCommand lists pastes matching the filters
+func newAdminListCommand() *cobra.Command {
+	filterFlags := &adminFilterFlags{}
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List stored pastes",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "text" && output != "json" {
+				return fmt.Errorf("unknown output format %q", output)
+			}
+			filter, err := filterFlags.filter()
+			if err != nil {
+				return err
+			}
+			storage, err := openStorage()
+			if err != nil {
+				return err
+			}
+
+			pastes, err := findPastes(cmd.Context(), storage, filter)
+			if err != nil {
+				return err
+			}
+			if output == "json" {
+				return writeJSON(cmd.OutOrStdout(), pasteSummaries(pastes))
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tSIZE\tCONTENT TYPE\tCREATED\tEXPIRES\tSTATE")
+			for _, paste := range pastes {
+				summary := summarizePaste(paste)
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+					summary.ID, formatSize(summary.Size), orDash(summary.ContentType),
+					formatTime(summary.CreateTime), orDash(summary.Expire), summary.State)
+			}
+			return w.Flush()
+		},
+	}
+
+	filterFlags.register(cmd)
+	cmd.Flags().StringVarP(&output, "output", "o", "text", "Output format (text, json)")
+	return cmd
+}
+
+// newAdminShowCommand prints everything known about one paste
+func newAdminShowCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show <id|key>",
+		Short: "Show the objects and metadata of a paste",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			storage, err := openStorage()
+			if err != nil {
+				return err
+			}
+			paste, err := findPaste(cmd.Context(), storage, args[0])
+			if err != nil {
+				return err
+			}
+			return writeJSON(cmd.OutOrStdout(), summarizePaste(paste))
+		},
+	}
+}
+
+// newAdminDeleteCommand removes pastes by ID without their delete keys
+func newAdminDeleteCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete <id|key>...",
+		Short: "Delete pastes without their delete keys",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			storage, err := openStorage()
+			if err != nil {
+				return err
+			}
+
+			var pastes []*makaroni.StoredPaste
+			for _, id := range args {
+				paste, err := findPaste(cmd.Context(), storage, id)
+				if err != nil {
+					return err
+				}
+				pastes = append(pastes, paste)
+			}
+			if err := makaroni.DeletePastes(cmd.Context(), storage, pastes); err != nil {
+				return fmt.Errorf("failed to delete pastes: %w", err)
+			}
+			for _, paste := range pastes {
+				fmt.Fprintf(cmd.OutOrStdout(), "Deleted %s\n", paste.ID)
+			}
+			return nil
+		},
+	}
+}
+
+// newAdminPurgeCommand removes all pastes matching the filters
+func newAdminPurgeCommand() *cobra.Command {
+	filterFlags := &adminFilterFlags{}
+	var all, dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "purge",
+		Short: "Delete all pastes matching the filters",
+		Example: "  makaroni admin purge --content-type application/x-msdownload\n" +
+			"  makaroni admin purge --older-than 90d --min-size 50M --dry-run",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter, err := filterFlags.filter()
+			if err != nil {
+				return err
+			}
+			if filter.Empty() && !all {
+				return errors.New("refusing to purge every paste without --all")
+			}
+			storage, err := openStorage()
+			if err != nil {
+				return err
+			}
+
+			pastes, err := findPastes(cmd.Context(), storage, filter)
+			if err != nil {
+				return err
+			}
+			for _, paste := range pastes {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\t%s\n", paste.ID, formatSize(paste.Size()), orDash(paste.ContentType()))
+			}
+			if dryRun {
+				fmt.Fprintf(cmd.OutOrStdout(), "%d pastes would be deleted\n", len(pastes))
+				return nil
+			}
+
+			if err := makaroni.DeletePastes(cmd.Context(), storage, pastes); err != nil {
+				return fmt.Errorf("failed to delete pastes: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Deleted %d pastes\n", len(pastes))
+			return nil
+		},
+	}
+
+	filterFlags.register(cmd)
+	cmd.Flags().BoolVar(&all, "all", false, "Allow purging without filters")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only list the pastes that would be deleted")
+	return cmd
+}
+
+// newAdminRekeyCommand wraps the data keys of encrypted objects with the current master key
+func newAdminRekeyCommand() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "rekey",
+		Short: "Re-wrap encrypted objects with the current master key",
+		Long: "Re-wrap the data keys of objects encrypted with an older master key with the first key of\n" +
+			"MKRN_ENCRYPTION_KEYS. Content is not rewritten; drop the old key once this has finished.\n" +
+			"Objects that fail are listed at the end and keep the old key, the others are rekeyed regardless.",
+		Example: "  MKRN_ENCRYPTION_KEYS=2026-10:<new key>,2025-01:<old key> makaroni admin rekey",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			storage, err := openStorage()
+			if err != nil {
+				return err
+			}
+			// Deduplication and compression wrap the encryption
+			for {
+				wrapper, ok := storage.(interface{ Unwrap() makaroni.Storage })
+				if !ok {
+					break
+				}
+				storage = wrapper.Unwrap()
+			}
+			encrypted, ok := storage.(*makaroni.EncryptedStorage)
+			if !ok {
+				return errors.New("encryption is not configured, set MKRN_ENCRYPTION_KEYS")
+			}
+
+			count, err := encrypted.Rekey(cmd.Context(), dryRun, func(key, masterKeyID string) {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\n", key, masterKeyID)
+			})
+			if err != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "Rekeyed %d objects\n", count)
+				return fmt.Errorf("failed to rekey objects: %w", err)
+			}
+			if dryRun {
+				fmt.Fprintf(cmd.OutOrStdout(), "%d objects would be rekeyed\n", count)
+				return nil
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Rekeyed %d objects\n", count)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only list the objects that would be rekeyed")
+	return cmd
+}
+
+// newAdminHashDeleteKeysCommand replaces plaintext delete keys of old pastes with salted hashes
+func newAdminHashDeleteKeysCommand() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "hash-delete-keys",
+		Short: "Replace plaintext delete keys of old pastes with hashes",
+		Long: "Replace the plaintext delete keys stored by older versions with salted hashes. The pastes keep\n" +
+			"accepting the same delete keys, but they can no longer be read from the storage.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			storage, err := openStorage()
+			if err != nil {
+				return err
+			}
+
+			count, err := makaroni.HashLegacyDeleteKeys(cmd.Context(), storage, dryRun, func(key string) {
+				fmt.Fprintln(cmd.OutOrStdout(), key)
+			})
+			if err != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "Hashed the delete keys of %d objects\n", count)
+				return fmt.Errorf("failed to hash delete keys: %w", err)
+			}
+			if dryRun {
+				fmt.Fprintf(cmd.OutOrStdout(), "%d objects have plaintext delete keys\n", count)
+				return nil
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Hashed the delete keys of %d objects\n", count)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only list the objects with plaintext delete keys")
+	return cmd
+}
+
+// openStorage creates the storage backend from the configuration
+func openStorage() (makaroni.Storage, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return NewStorage(config)
+}
+
+// findPastes returns all stored pastes matching the filter
+func findPastes(ctx context.Context, storage makaroni.Storage, filter makaroni.PasteFilter) ([]*makaroni.StoredPaste, error) {
+	now := time.Now()
+	var pastes []*makaroni.StoredPaste
+	err := makaroni.ListPastes(ctx, storage, func(paste *makaroni.StoredPaste) error {
+		if filter.Match(paste, now) {
+			pastes = append(pastes, paste)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pastes: %w", err)
+	}
+	return pastes, nil
+}
+
+// findPaste looks up a paste and turns a missing one into a readable error
+func findPaste(ctx context.Context, storage makaroni.Storage, id string) (*makaroni.StoredPaste, error) {
+	paste, err := makaroni.FindPaste(ctx, storage, id)
+	if errors.Is(err, makaroni.ErrObjectNotFound) {
+		return nil, fmt.Errorf("paste %q not found", id)
+	}
+	return paste, err
+}
+
+// pasteSummary is the printable description of a stored paste
+type pasteSummary struct {
+	ID          string            `json:"id"`
+	RawKey      string            `json:"rawKey"`
+	HtmlKey     string            `json:"htmlKey"`
+	State       string            `json:"state"`
+	Size        int64             `json:"size"`
+	ContentType string            `json:"contentType,omitempty"`
+	FileName    string            `json:"filename,omitempty"`
+	CreateTime  time.Time         `json:"createTime"`
+	Expire      string            `json:"expire,omitempty"`
+	MaxViews    int               `json:"maxViews,omitempty"`
+	Views       int               `json:"views,omitempty"`
+	DeleteKey   string            `json:"deleteKey"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// summarizePaste describes a paste without exposing delete key material
+func summarizePaste(paste *makaroni.StoredPaste) pasteSummary {
+	metadata := paste.Metadata()
+	summary := pasteSummary{
+		ID:          paste.ID,
+		RawKey:      paste.RawKey,
+		HtmlKey:     paste.HtmlKey,
+		State:       "ok",
+		Size:        paste.Size(),
+		ContentType: paste.ContentType(),
+		CreateTime:  paste.CreateTime().UTC(),
+		Expire:      metadata["expire"],
+		DeleteKey:   paste.DeleteKeyType(),
+		Metadata:    paste.DisplayMetadata(),
+	}
+	switch {
+	case paste.Raw == nil:
+		summary.State = "missing raw"
+	case paste.HTML == nil:
+		summary.State = "missing html"
+	}
+	if name, err := url.PathUnescape(metadata["filename"]); err == nil {
+		summary.FileName = name
+	}
+	summary.MaxViews, _ = strconv.Atoi(metadata["max-views"])
+	summary.Views, _ = strconv.Atoi(metadata["views"])
+	return summary
+}
+
+// pasteSummaries describes all pastes
+func pasteSummaries(pastes []*makaroni.StoredPaste) []pasteSummary {
+	summaries := make([]pasteSummary, 0, len(pastes))
+	for _, paste := range pastes {
+		summaries = append(summaries, summarizePaste(paste))
+	}
+	return summaries
+}
+
+// writeJSON writes the value as indented JSON
+func writeJSON(w io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// sizeUnits maps size suffixes to their multipliers
+var sizeUnits = map[string]int64{
+	"":  1,
+	"B": 1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+}
+
+// parseSize parses sizes like "512", "100K" or "10M", an empty string is zero
+func parseSize(input string) (int64, error) {
+	value := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(input)), "B")
+	if value == "" {
+		return 0, nil
+	}
+
+	number, unit := value, ""
+	if last := value[len(value)-1:]; sizeUnits[last] > 1 {
+		number, unit = value[:len(value)-1], last
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q", input)
+	}
+	return size * sizeUnits[unit], nil
+}
+
+// formatSize prints a size with a binary unit
+func formatSize(size int64) string {
+	units := []string{"K", "M", "G"}
+	if size < 1<<10 {
+		return strconv.FormatInt(size, 10)
+	}
+	value := float64(size)
+	unit := ""
+	for _, u := range units {
+		if value < 1<<10 {
+			break
+		}
+		value /= 1 << 10
+		unit = u
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + unit
+}
+
+// formatTime prints a time in UTC, or a dash for unknown times
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// orDash replaces empty values with a dash in table output
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kaero/makaroni"
+	"github.com/spf13/cobra"
+)
+
+// newGCCommand creates the command removing orphaned objects from storage
+func newGCCommand() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Find and delete orphaned paste objects",
+		Long: "Find raw objects without their .html page and pages without their raw object,\n" +
+			"left behind by failed uploads or partial deletes, and delete those older than the grace period.\n" +
+			"With deduplication, blobs no paste refers to are deleted as well; stop the server first,\n" +
+			"reference counts are only kept consistent within one process.",
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			storage, err := NewStorage(config)
+			if err != nil {
+				return err
+			}
+
+			collector := &makaroni.Collector{Storage: storage, GracePeriod: config.GCGracePeriod}
+			orphans, err := collector.Collect(cmd.Context(), dryRun)
+
+			now := time.Now()
+			deleted := 0
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "KEYS\tMISSING\tCREATED\tACTION")
+			for _, orphan := range orphans {
+				missing := orphan.HtmlKey
+				if orphan.Raw == nil {
+					missing = orphan.RawKey
+				}
+				action := "kept, within grace period"
+				if collector.Expired(orphan, now) {
+					action = "deleted"
+					if dryRun {
+						action = "would be deleted"
+					}
+					deleted++
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", strings.Join(orphan.Keys(), ","), missing, formatTime(orphan.CreateTime()), action)
+			}
+			if flushErr := w.Flush(); flushErr != nil {
+				return flushErr
+			}
+			if err != nil {
+				return fmt.Errorf("garbage collection failed: %w", err)
+			}
+
+			if dryRun {
+				fmt.Fprintf(cmd.OutOrStdout(), "%d orphans found, %d would be deleted\n", len(orphans), deleted)
+			} else {
+				fmt.Fprintf(cmd.OutOrStdout(), "%d orphans found, %d deleted\n", len(orphans), deleted)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only report orphaned objects")
+	return cmd
+}
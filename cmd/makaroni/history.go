@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// HistoryEntry records a paste created with the paste command, so it can be deleted later
+type HistoryEntry struct {
+	ID         string    `json:"id"`
+	Server     string    `json:"server"`
+	URL        string    `json:"url"`
+	RawURL     string    `json:"rawUrl"`
+	DeleteKey  string    `json:"deleteKey"`
+	Source     string    `json:"source,omitempty"`
+	CreateTime time.Time `json:"createTime"`
+}
+
+// History is the local list of created pastes, stored as JSON
+type History struct {
+	path    string
+	Entries []HistoryEntry `json:"entries"`
+}
+
+// defaultHistoryPath returns the history file location in the user config directory
+func defaultHistoryPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "makaroni", "history.json")
+}
+
+// LoadHistory reads the history file, a missing file is an empty history
+func LoadHistory(path string) (*History, error) {
+	history := &History{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	if err := json.Unmarshal(data, history); err != nil {
+		return nil, fmt.Errorf("failed to parse history %s: %w", path, err)
+	}
+	return history, nil
+}
+
+// Add appends an entry to the history. The key of an encrypted paste is the fragment of its URL,
+// it is dropped so the history file cannot decrypt the pastes it lists.
+func (h *History) Add(entry HistoryEntry) {
+	entry.URL = withoutFragment(entry.URL)
+	entry.RawURL = withoutFragment(entry.RawURL)
+	h.Entries = append(h.Entries, entry)
+}
+
+// Find returns the entry with the given paste ID or URL
+func (h *History) Find(idOrURL string) (HistoryEntry, bool) {
+	idOrURL = withoutFragment(idOrURL)
+	for i := len(h.Entries) - 1; i >= 0; i-- {
+		entry := h.Entries[i]
+		if entry.ID == idOrURL || entry.URL == idOrURL || entry.RawURL == idOrURL {
+			return entry, true
+		}
+	}
+	return HistoryEntry{}, false
+}
+
+// Remove drops all entries of the paste from the history
+func (h *History) Remove(entry HistoryEntry) {
+	entries := h.Entries[:0]
+	for _, e := range h.Entries {
+		if e.ID != entry.ID || e.Server != entry.Server {
+			entries = append(entries, e)
+		}
+	}
+	h.Entries = entries
+}
+
+// Save writes the history file, readable by the owner only because it contains delete keys
+func (h *History) Save() error {
+	if err := os.MkdirAll(filepath.Dir(h.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return os.Rename(tmp, h.path)
+}
+
+// withoutFragment strips the #fragment of a URL
+func withoutFragment(url string) string {
+	if i := strings.IndexByte(url, '#'); i >= 0 {
+		return url[:i]
+	}
+	return url
+}
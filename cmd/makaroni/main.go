@@ -14,6 +14,7 @@ import (
 	"github.com/kaero/makaroni"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
@@ -61,7 +62,10 @@ func setupRootCommand() *cobra.Command {
 			config = makaroni.GetConfig()
 			makaroni.LogConfig()
 
-			server, err := SetupServer(config)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			server, err := SetupServer(ctx, config)
 			if err != nil {
 				log.Fatalf("Error setting up server: %v", err)
 			}
@@ -88,6 +92,8 @@ func setupRootCommand() *cobra.Command {
 	// Register flags
 	setupFlags(rootCmd)
 
+	rootCmd.AddCommand(newPasteCommand(), newRenderCommand(), newAdminCommand(), newGCCommand())
+
 	return rootCmd
 }
 
@@ -95,24 +101,64 @@ func setupRootCommand() *cobra.Command {
 func setupFlags(rootCmd *cobra.Command) {
 	flags := rootCmd.Flags()
 	flags.String("address", "", "Address to serve")
-	flags.Int64("multipart-max-memory", 0, "Maximum memory for multipart forms")
+	flags.Int64("multipart-max-memory", 0, "Maximum memory for multipart form fields")
+	flags.Int64("max-upload-size", 0, "Maximum file upload size in bytes, 0 for unlimited")
+	flags.String("trusted-proxy-header", "", "Header a trusted reverse proxy puts the client address in, e.g. X-Forwarded-For")
+	flags.Duration("upload-expire", makaroni.DefaultUploadExpire, "How long unfinished uploads are kept after their last request")
+	flags.Bool("direct-uploads", false, "Let clients upload files straight to S3 with presigned requests")
+	flags.Duration("presign-expire", makaroni.DefaultPresignExpire, "How long presigned storage URLs stay valid")
+	flags.Bool("presigned-links", false, "Link pastes through /p/<id>, redirecting to presigned URLs of a private bucket")
 	flags.String("index-url", "", "URL to the index page")
 	flags.String("result-url-prefix", "", "Upload result URL prefix")
 	flags.String("logo-url", "", "Logo URL for the form page")
 	flags.String("favicon-url", "", "Favicon URL")
 	flags.String("style", "", "Formatting style")
-	flags.String("s3-endpoint", "", "S3 endpoint")
-	flags.String("s3-region", "", "S3 region")
-	flags.String("s3-bucket", "", "S3 bucket")
-	flags.String("s3-key-id", "", "S3 key ID")
-	flags.String("s3-secret-key", "", "S3 secret key")
-	flags.Bool("s3-path-style", false, "S3 use path style addressing")
-	flags.Bool("s3-disable-ssl", false, "S3 disable SSL")
+	flags.String("id-generator", "uuid", "Paste ID generator (uuid, base62, words)")
+	flags.Int("id-length", 0, "Paste ID length: characters for base62, words for words")
+	flags.String("default-expire", "never", "Default paste expiration (10m, 1h, 1d, 1w, never)")
+	flags.String("max-expire", "", "Maximum paste expiration, empty for unlimited")
+	flags.Duration("expire-sweep-interval", 10*time.Minute, "Interval between sweeps of abandoned uploads, 0 to disable")
+	flags.Bool("expire-sweep-pastes", true, "Remove expired pastes on each sweep, reading the metadata of every stored object")
+	flags.Duration("gc-interval", 0, "Interval between orphaned object collections, 0 to disable")
+
+	// Storage and garbage collection flags are shared with the subcommands working on stored pastes
+	storageFlags := rootCmd.PersistentFlags()
+	storageFlags.Duration("gc-grace-period", makaroni.DefaultGCGracePeriod, "Minimum age of orphaned objects before they are deleted")
+	storageFlags.String("storage", "s3", "Storage backend (s3, filesystem)")
+	storageFlags.String("storage-path", "", "Root directory for the filesystem storage")
+	storageFlags.String("encryption-keys", "", "Master keys encrypting stored objects, comma-separated id:base64-key, the first one is current")
+	storageFlags.Bool("dedup", false, "Store identical paste content once, shared by the pastes")
+	storageFlags.String("dedup-secret", "", "Secret keying the content IDs of deduplicated pastes, required with encryption")
+	storageFlags.Bool("compress", false, "Gzip text pastes and pages before storing them")
+	storageFlags.String("s3-endpoint", "", "S3 endpoint")
+	storageFlags.String("s3-region", "", "S3 region")
+	storageFlags.String("s3-bucket", "", "S3 bucket")
+	storageFlags.String("s3-key-id", "", "S3 key ID")
+	storageFlags.String("s3-secret-key", "", "S3 secret key")
+	storageFlags.Bool("s3-path-style", false, "S3 use path style addressing")
+	storageFlags.Bool("s3-disable-ssl", false, "S3 disable SSL")
 
 	// Bind flags with Viper
-	if err := viper.BindPFlags(flags); err != nil {
-		log.Fatalf("Error binding flags: %v", err)
+	bindFlags(flags)
+	bindFlags(storageFlags)
+}
+
+// bindFlags binds the flags to the configuration keys, "s3-bucket" to "s3_bucket"
+func bindFlags(flags *pflag.FlagSet) {
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err := viper.BindPFlag(strings.ReplaceAll(flag.Name, "-", "_"), flag); err != nil {
+			log.Fatalf("Error binding flags: %v", err)
+		}
+	})
+}
+
+// loadConfig reads the configuration from flags and environment variables
+func loadConfig() (*makaroni.Config, error) {
+	config := &makaroni.Config{}
+	if err := viper.Unmarshal(config); err != nil {
+		return nil, fmt.Errorf("error parsing configuration: %w", err)
 	}
+	return config, nil
 }
 
 // setupViper configures Viper for environment variable handling
@@ -122,19 +168,68 @@ func setupViper() {
 	viper.AutomaticEnv()
 }
 
-// SetupServer creates and configures the HTTP server.
-func SetupServer(config *makaroni.Config) (*http.Server, error) {
+// SetupServer creates and configures the HTTP server and starts background jobs bound to ctx.
+func SetupServer(ctx context.Context, config *makaroni.Config) (*http.Server, error) {
+	for _, expire := range []string{config.DefaultExpire, config.MaxExpire} {
+		if _, err := makaroni.ParseExpire(expire); err != nil {
+			return nil, fmt.Errorf("invalid expiration setting: %w", err)
+		}
+	}
+
+	// Encrypted objects must never be handed out by the storage itself
+	if config.EncryptionKeys != "" && (config.DirectUploads || config.PresignedLinks) {
+		return nil, errors.New("direct uploads and presigned links cannot be used with encryption at rest")
+	}
+	// Deduplicated pastes are pointers, the storage would hand out or accept them in place of the content
+	if config.Dedup && (config.DirectUploads || config.PresignedLinks) {
+		return nil, errors.New("direct uploads and presigned links cannot be used with deduplication")
+	}
+	// Compressed objects are stored without a Content-Encoding the storage could serve them with
+	if config.Compress && (config.DirectUploads || config.PresignedLinks) {
+		return nil, errors.New("direct uploads and presigned links cannot be used with compression")
+	}
+
+	idGenerator, err := makaroni.NewIDGenerator(config.IDGenerator, config.IDLength)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id generator setting: %w", err)
+	}
+
 	indexHTML, err := makaroni.RenderIndexPage(config.LogoURL, config.IndexURL, config.FaviconURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to render index page: %w", err)
 	}
 
-	uploader, err := NewS3Uploader(config)
+	storage, err := NewStorage(config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create S3 uploader: %w", err)
+		return nil, fmt.Errorf("failed to create storage: %w", err)
 	}
 
-	mux := SetupRoutes(indexHTML, uploader, config)
+	handler := &makaroni.PasteHandler{
+		IndexHTML:          indexHTML,
+		Storage:            storage,
+		ResultURLPrefix:    config.ResultURLPrefix,
+		Style:              config.Style,
+		MultipartMaxMemory: config.MultipartMaxMemory,
+		Config:             config,
+		IDGenerator:        idGenerator,
+	}
+
+	if config.ExpireSweepInterval > 0 {
+		sweeper := &makaroni.Sweeper{
+			Storage:       storage,
+			Interval:      config.ExpireSweepInterval,
+			Pastes:        config.ExpireSweepPastes,
+			UploadRemoved: handler.ForgetUpload,
+		}
+		go sweeper.Run(ctx)
+	}
+
+	if config.GCInterval > 0 {
+		collector := &makaroni.Collector{Storage: storage, Interval: config.GCInterval, GracePeriod: config.GCGracePeriod}
+		go collector.Run(ctx)
+	}
+
+	mux := SetupRoutes(handler)
 
 	return &http.Server{
 		Addr:    config.Address,
@@ -142,10 +237,61 @@ func SetupServer(config *makaroni.Config) (*http.Server, error) {
 	}, nil
 }
 
+// NewStorage creates the storage backend selected in the configuration, encrypting it when master keys are set
+// and deduplicating and compressing paste content when enabled.
+func NewStorage(config *makaroni.Config) (makaroni.Storage, error) {
+	var storage makaroni.Storage
+	switch config.Storage {
+	case "", "s3":
+		uploader, err := NewS3Uploader(config)
+		if err != nil {
+			return nil, err
+		}
+		storage = uploader
+	case "filesystem":
+		fileStorage, err := makaroni.NewFileStorage(config.StoragePath)
+		if err != nil {
+			return nil, err
+		}
+		storage = fileStorage
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", config.Storage)
+	}
+
+	if config.EncryptionKeys != "" {
+		keys, err := makaroni.ParseMasterKeys(config.EncryptionKeys)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption keys: %w", err)
+		}
+		encrypted, err := makaroni.NewEncryptedStorage(storage, keys)
+		if err != nil {
+			return nil, err
+		}
+		storage = encrypted
+	}
+
+	// Deduplication sees the content before it is encrypted, unkeyed IDs would reveal which content it is
+	if config.Dedup {
+		if config.EncryptionKeys != "" && config.DedupSecret == "" {
+			return nil, errors.New("deduplication with encryption at rest requires a dedup secret")
+		}
+		storage = makaroni.NewDedupStorage(storage, []byte(config.DedupSecret))
+	}
+	// Compression comes first, gzip output is deterministic so identical content still matches
+	if config.Compress {
+		storage = makaroni.NewCompressedStorage(storage)
+	}
+	return storage, nil
+}
+
 // NewS3Uploader creates a new S3 uploader.
 func NewS3Uploader(config *makaroni.Config) (*makaroni.Uploader, error) {
 	log.Info("Initializing uploader")
 
+	if config.S3Endpoint == "" || config.S3Bucket == "" {
+		return nil, errors.New("s3_endpoint and s3_bucket must be set for the s3 storage")
+	}
+
 	uploaderConfig := makaroni.UploaderConfig{
 		Endpoint:            config.S3Endpoint,
 		DisableSSL:          config.S3DisableSSL,
@@ -170,7 +316,7 @@ func NewS3Uploader(config *makaroni.Config) (*makaroni.Uploader, error) {
 }
 
 // SetupRoutes sets up the HTTP routes.
-func SetupRoutes(indexHTML []byte, uploader *makaroni.Uploader, config *makaroni.Config) *http.ServeMux {
+func SetupRoutes(handler *makaroni.PasteHandler) *http.ServeMux {
 	fileServer := http.FileServer(http.Dir("./resources/static"))
 	mux := http.NewServeMux()
 
@@ -178,14 +324,7 @@ func SetupRoutes(indexHTML []byte, uploader *makaroni.Uploader, config *makaroni
 	mux.Handle("/static/", LogStaticFileRequest(http.StripPrefix("/static/", fileServer)))
 
 	// Main handler
-	mux.Handle("/", &makaroni.PasteHandler{
-		IndexHTML:          indexHTML,
-		Uploader:           uploader,
-		ResultURLPrefix:    config.ResultURLPrefix,
-		Style:              config.Style,
-		MultipartMaxMemory: config.MultipartMaxMemory,
-		Config:             config,
-	})
+	mux.Handle("/", handler)
 
 	return mux
 }
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/kaero/makaroni"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// outputFormats lists the supported --output values
+var outputFormats = map[string]bool{"text": true, "json": true, "url": true}
+
+// pasteFlags holds the options of the paste command
+type pasteFlags struct {
+	syntax   string
+	expire   string
+	views    int
+	burn     bool
+	slug     string
+	filename string
+	asFile   bool
+	encrypt  bool
+	password string
+	output   string
+	delete   string
+}
+
+// newPasteCommand creates the client command uploading files or stdin to a makaroni server
+func newPasteCommand() *cobra.Command {
+	opts := &pasteFlags{}
+
+	cmd := &cobra.Command{
+		Use:   "paste [file...]",
+		Short: "Upload files or stdin to a makaroni server",
+		Long: "Upload files or stdin to a makaroni server and print the view, raw and delete URLs.\n" +
+			"Created pastes are recorded in a local history file, so they can be removed with --delete.",
+		Example: "  makaroni paste --server https://paste.example.com main.go\n" +
+			"  kubectl logs app | makaroni paste --expire 1d\n" +
+			"  makaroni paste --encrypt secrets.env\n" +
+			"  makaroni paste --delete <id>",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !outputFormats[opts.output] {
+				return fmt.Errorf("unknown output format %q", opts.output)
+			}
+
+			server := viper.GetString("server")
+			if server == "" && opts.delete == "" {
+				return errors.New("server URL is not set, use --server or MKRN_SERVER")
+			}
+
+			historyPath := viper.GetString("history")
+			if historyPath == "" {
+				historyPath = defaultHistoryPath()
+			}
+			history, err := LoadHistory(historyPath)
+			if err != nil {
+				return err
+			}
+
+			if opts.delete != "" {
+				return deletePaste(cmd.Context(), cmd.OutOrStdout(), history, server, opts.delete)
+			}
+
+			if len(args) == 0 {
+				args = []string{"-"}
+			}
+			client := makaroni.NewClient(server)
+			for _, source := range args {
+				paste, err := uploadPaste(cmd.Context(), client, source, opts)
+				if err != nil {
+					return fmt.Errorf("%s: %w", source, err)
+				}
+
+				history.Add(HistoryEntry{
+					ID:         paste.ID,
+					Server:     client.BaseURL,
+					URL:        paste.URL,
+					RawURL:     paste.RawURL,
+					DeleteKey:  paste.DeleteKey,
+					Source:     source,
+					CreateTime: time.Now().UTC(),
+				})
+				if err := history.Save(); err != nil {
+					return fmt.Errorf("paste %s created, but %w", paste.URL, err)
+				}
+				if err := printPaste(cmd.OutOrStdout(), paste, opts.output); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.String("server", "", "Makaroni server URL")
+	flags.String("history", "", "History file (default is makaroni/history.json in the user config directory)")
+	flags.StringVarP(&opts.syntax, "syntax", "s", "", "Syntax for highlighting, guessed from the file name when empty")
+	flags.StringVarP(&opts.expire, "expire", "e", "", "Expiration period (10m, 1h, 1d, 1w, never)")
+	flags.IntVar(&opts.views, "views", 0, "Number of views before the paste is deleted")
+	flags.BoolVar(&opts.burn, "burn", false, "Delete the paste after the first view")
+	flags.StringVar(&opts.slug, "slug", "", "Custom paste ID")
+	flags.StringVarP(&opts.filename, "filename", "f", "", "File name, used to guess the syntax and for file uploads")
+	flags.BoolVar(&opts.asFile, "as-file", false, "Upload as a downloadable file instead of a highlighted paste")
+	flags.BoolVarP(&opts.encrypt, "encrypt", "E", false, "Encrypt the text before uploading, the key is only part of the printed URL")
+	flags.StringVar(&opts.password, "password", "", "Password viewers must enter to open the paste")
+	flags.StringVarP(&opts.output, "output", "o", "text", "Output format (text, json, url)")
+	flags.StringVar(&opts.delete, "delete", "", "Delete a paste from the history by ID or URL")
+
+	for _, name := range []string{"server", "history"} {
+		if err := viper.BindPFlag(name, flags.Lookup(name)); err != nil {
+			log.Fatalf("Error binding flags: %v", err)
+		}
+	}
+
+	return cmd
+}
+
+// uploadPaste reads a file or stdin ("-") and creates a paste from it
+func uploadPaste(ctx context.Context, client *makaroni.Client, source string, opts *pasteFlags) (*makaroni.PasteInfo, error) {
+	var content []byte
+	var err error
+	if source == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	filename := opts.filename
+	if filename == "" && source != "-" {
+		filename = filepath.Base(source)
+	}
+
+	options := makaroni.PasteOptions{
+		Syntax:   opts.syntax,
+		Expire:   opts.expire,
+		Views:    opts.views,
+		Burn:     opts.burn,
+		Slug:     opts.slug,
+		Encrypt:  opts.encrypt,
+		Password: opts.password,
+	}
+
+	if opts.encrypt && (opts.asFile || !isText(content)) {
+		return nil, errors.New("only text pastes can be encrypted")
+	}
+
+	// Binary content cannot be highlighted, so it is always uploaded as a file
+	if opts.asFile || !isText(content) {
+		if filename == "" {
+			filename = "paste"
+		}
+		options.FileName = filename
+		options.ContentType = mime.TypeByExtension(filepath.Ext(filename))
+		if options.ContentType == "" {
+			options.ContentType = http.DetectContentType(content)
+		}
+	} else if options.Syntax == "" && filename != "" {
+		if lexer := lexers.Match(filename); lexer != nil {
+			options.Syntax = lexer.Config().Name
+		}
+	}
+
+	return client.CreatePaste(ctx, bytes.NewReader(content), options)
+}
+
+// deletePaste removes a paste recorded in the history
+func deletePaste(ctx context.Context, w io.Writer, history *History, server, idOrURL string) error {
+	entry, ok := history.Find(idOrURL)
+	if !ok {
+		return fmt.Errorf("paste %q not found in history %s", idOrURL, history.path)
+	}
+	if entry.Server != "" {
+		server = entry.Server
+	}
+
+	err := makaroni.NewClient(server).DeletePaste(ctx, entry.ID, entry.DeleteKey)
+	var apiErr *makaroni.APIError
+	switch {
+	case err == nil:
+		fmt.Fprintf(w, "Deleted %s\n", entry.URL)
+	case errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusGone):
+		fmt.Fprintf(w, "Paste %s no longer exists\n", entry.URL)
+	default:
+		return err
+	}
+
+	history.Remove(entry)
+	return history.Save()
+}
+
+// printPaste writes the created paste in the requested output format
+func printPaste(w io.Writer, paste *makaroni.PasteInfo, format string) error {
+	var err error
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(paste)
+	case "url":
+		_, err = fmt.Fprintln(w, paste.URL)
+	default:
+		_, err = fmt.Fprintf(w, "%s\nraw: %s\ndelete: makaroni paste --delete %s\n", paste.URL, paste.RawURL, paste.ID)
+	}
+	return err
+}
+
+// isText reports whether the content can be shown as a highlighted text paste
+func isText(content []byte) bool {
+	return utf8.Valid(content) && !bytes.ContainsRune(content, 0)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/kaero/makaroni"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// newRenderCommand creates the command rendering a paste page locally, without a server or storage
+func newRenderCommand() *cobra.Command {
+	var lexer, style, output string
+
+	cmd := &cobra.Command{
+		Use:   "render [file]",
+		Short: "Render a highlighted HTML page locally",
+		Long: "Highlight a file or stdin exactly like a text paste and write the page as a self-contained HTML file.\n" +
+			"Nothing is uploaded, the page does not load anything from the network.",
+		Example: "  makaroni render -l go -s monokai main.go\n" +
+			"  kubectl logs app | makaroni render -o incident.html",
+		Args:          cobra.MaximumNArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			source := "-"
+			if len(args) == 1 {
+				source = args[0]
+			}
+
+			var content []byte
+			var err error
+			if source == "-" {
+				content, err = io.ReadAll(os.Stdin)
+			} else {
+				content, err = os.ReadFile(source)
+			}
+			if err != nil {
+				return err
+			}
+
+			// An empty lexer lets the highlighter guess from the content
+			if lexer == "" && source != "-" {
+				if l := lexers.Match(source); l != nil {
+					lexer = l.Config().Name
+				}
+			}
+			if style == "" {
+				style = viper.GetString("style")
+			}
+
+			page, err := makaroni.RenderStandalone(string(content), lexer, style)
+			if err != nil {
+				return fmt.Errorf("failed to render: %w", err)
+			}
+
+			if output == "" && source != "-" {
+				output = source + ".html"
+			}
+			if output == "" || output == "-" {
+				_, err = cmd.OutOrStdout().Write(page)
+				return err
+			}
+			if err := os.WriteFile(output, page, 0o644); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.ErrOrStderr(), "Rendered", output)
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&lexer, "lexer", "l", "", "Lexer name, guessed from the file name or content when empty")
+	flags.StringVarP(&style, "style", "s", "", "Formatting style (default is the server style setting)")
+	flags.StringVarP(&output, "output", "o", "", "Output file, - for stdout (default is <file>.html, stdout for stdin)")
+
+	return cmd
+}
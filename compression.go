@@ -0,0 +1,240 @@
+package makaroni
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	contentEncodingMetadataKey = "content-encoding" // Encoding of the stored content, "gzip"
+	contentSizeMetadataKey     = "content-size"     // Size of the content before it was compressed
+
+	encodingGzip = "gzip"
+
+	// minCompressSize keeps small objects as they are, the gzip overhead outweighs what they would save
+	minCompressSize = 512
+)
+
+// CompressedStorage gzips text pastes and pages before they reach the underlying storage and decompresses them
+// when they are read. Files uploaded as streams are stored as they are. The stored gzip can be sent as it is
+// to clients accepting it, see EncodedStorage.
+//
+// CompressedStorage implements neither MultipartStorage nor PresignStorage: the storage does not know
+// the objects are compressed, so pastes have to be served by makaroni.
+type CompressedStorage struct {
+	storage Storage
+}
+
+var (
+	_ Storage        = (*CompressedStorage)(nil)
+	_ EncodedStorage = (*CompressedStorage)(nil)
+)
+
+// NewCompressedStorage wraps storage
+func NewCompressedStorage(storage Storage) *CompressedStorage {
+	return &CompressedStorage{storage: storage}
+}
+
+// Unwrap returns the wrapped storage
+func (c *CompressedStorage) Unwrap() Storage {
+	return c.storage
+}
+
+// UploadString stores string content under the key, compressed if it is text worth compressing
+func (c *CompressedStorage) UploadString(ctx context.Context, key string, content string, contentType string, metadata map[string]string) error {
+	stored := decodedMetadata(metadata)
+	if len(content) < minCompressSize || isInternalKey(key) || !isCompressible(contentType) {
+		return c.storage.UploadString(ctx, key, content, contentType, stored)
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(writer, content); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	if buf.Len() >= len(content) {
+		return c.storage.UploadString(ctx, key, content, contentType, stored)
+	}
+
+	stored[contentEncodingMetadataKey] = encodingGzip
+	stored[contentSizeMetadataKey] = strconv.Itoa(len(content))
+	return c.storage.UploadString(ctx, key, buf.String(), contentType, stored)
+}
+
+// UploadReader stores data read from reader under the key as it is
+func (c *CompressedStorage) UploadReader(ctx context.Context, key string, reader io.Reader, contentType string, metadata map[string]string) error {
+	return c.storage.UploadReader(ctx, key, reader, contentType, decodedMetadata(metadata))
+}
+
+// HeadObject returns object information with the size of the decompressed content
+func (c *CompressedStorage) HeadObject(ctx context.Context, key string) (*ObjectInfo, error) {
+	info, err := c.storage.HeadObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return decodedInfo(info), nil
+}
+
+// GetMetadata returns the user metadata of an object without the compression metadata
+func (c *CompressedStorage) GetMetadata(ctx context.Context, key string) (map[string]string, error) {
+	metadata, err := c.storage.GetMetadata(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return decodedMetadata(metadata), nil
+}
+
+// UpdateMetadata replaces the user metadata of an object, keeping its compression metadata
+func (c *CompressedStorage) UpdateMetadata(ctx context.Context, key string, metadata map[string]string) error {
+	current, err := c.storage.GetMetadata(ctx, key)
+	if err != nil {
+		return err
+	}
+	stored := decodedMetadata(metadata)
+	if current[contentEncodingMetadataKey] != "" {
+		stored[contentEncodingMetadataKey] = current[contentEncodingMetadataKey]
+		stored[contentSizeMetadataKey] = current[contentSizeMetadataKey]
+	}
+	return c.storage.UpdateMetadata(ctx, key, stored)
+}
+
+// GetObject returns the decompressed object content, the caller must close the reader
+func (c *CompressedStorage) GetObject(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	body, info, err := c.storage.GetObject(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Metadata[contentEncodingMetadataKey] == "" {
+		return body, info, nil
+	}
+
+	reader, err := gzip.NewReader(body)
+	if err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+	return limitedReadCloser{Reader: reader, Closer: body}, decodedInfo(info), nil
+}
+
+// GetObjectRange returns length bytes of the decompressed object starting at offset.
+// A compressed object is decompressed from its start, the bytes before offset are skipped.
+func (c *CompressedStorage) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	metadata, err := c.storage.GetMetadata(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if metadata[contentEncodingMetadataKey] == "" {
+		return c.storage.GetObjectRange(ctx, key, offset, length)
+	}
+
+	body, _, err := c.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, body, offset); err != nil && !errors.Is(err, io.EOF) {
+		body.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return body, nil
+	}
+	return limitedReadCloser{Reader: io.LimitReader(body, length), Closer: body}, nil
+}
+
+// DeleteObjects removes multiple objects, missing keys are ignored
+func (c *CompressedStorage) DeleteObjects(ctx context.Context, keys []string) error {
+	return c.storage.DeleteObjects(ctx, keys)
+}
+
+// ListObjects calls fn for every object whose key starts with prefix, sizes are the stored sizes
+func (c *CompressedStorage) ListObjects(ctx context.Context, prefix string, fn ListFunc) error {
+	return c.storage.ListObjects(ctx, prefix, fn)
+}
+
+// HeadEncoded returns information on the object as stored and its content encoding, empty if it is not compressed.
+// The ETag of a compressed object is suffixed, the stored bytes are a different representation of the content.
+func (c *CompressedStorage) HeadEncoded(ctx context.Context, key string) (*ObjectInfo, string, error) {
+	info, err := c.storage.HeadObject(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	encoding := info.Metadata[contentEncodingMetadataKey]
+	result := *info
+	result.Metadata = decodedMetadata(info.Metadata)
+	if encoding != "" && strings.HasSuffix(info.ETag, `"`) {
+		result.ETag = strings.TrimSuffix(info.ETag, `"`) + "-" + encoding + `"`
+	}
+	return &result, encoding, nil
+}
+
+// GetEncodedRange returns length bytes of the object as stored starting at offset
+func (c *CompressedStorage) GetEncodedRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	return c.storage.GetObjectRange(ctx, key, offset, length)
+}
+
+// isCompressible reports whether content of the type is text that compresses well
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml":
+		return true
+	}
+	return false
+}
+
+// decodedMetadata returns a copy of the metadata without the compression entries
+func decodedMetadata(metadata map[string]string) map[string]string {
+	result := copyMetadata(metadata)
+	delete(result, contentEncodingMetadataKey)
+	delete(result, contentSizeMetadataKey)
+	return result
+}
+
+// decodedInfo describes a stored object as seen through the compression
+func decodedInfo(info *ObjectInfo) *ObjectInfo {
+	if info.Metadata[contentEncodingMetadataKey] == "" {
+		return info
+	}
+	result := *info
+	result.Size, _ = strconv.ParseInt(info.Metadata[contentSizeMetadataKey], 10, 64)
+	result.Metadata = decodedMetadata(info.Metadata)
+	return &result
+}
+
+// acceptsEncoding reports whether the client accepts responses with the content encoding
+func acceptsEncoding(req *http.Request, encoding string) bool {
+	for _, part := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != encoding && name != "*" {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				q, err := strconv.ParseFloat(value, 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package makaroni
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressedStorage(t *testing.T) {
+	backend := NewMemoryStorage()
+	storage := NewCompressedStorage(backend)
+	ctx := context.Background()
+
+	content := strings.Repeat("2026-10-17 12:00:00 INFO build step finished\n", 200)
+	if err := storage.UploadString(ctx, "log", content, contentTypeText, map[string]string{"syntax": "plain"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	stored, err := backend.HeadObject(ctx, "log")
+	if err != nil || stored.Size >= int64(len(content))/10 || stored.Metadata[contentEncodingMetadataKey] != encodingGzip {
+		t.Fatalf("expected compressed content, got %+v: %v", stored, err)
+	}
+	info, err := storage.HeadObject(ctx, "log")
+	if err != nil || info.Size != int64(len(content)) || len(info.Metadata) != 1 {
+		t.Fatalf("unexpected object info %+v: %v", info, err)
+	}
+	if data, _ := readObject(t, storage, "log"); data != content {
+		t.Fatal("content changed on the way through compression")
+	}
+
+	if err := storage.UpdateMetadata(ctx, "log", map[string]string{"views": "1"}); err != nil {
+		t.Fatalf("update metadata: %v", err)
+	}
+	reader, err := storage.GetObjectRange(ctx, "log", 45, 10)
+	if err != nil {
+		t.Fatalf("get range: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "2026-10-17" {
+		t.Fatalf("unexpected range content %q", data)
+	}
+
+	// Small objects, binary content and streams are stored as they are
+	for key, upload := range map[string]func() error{
+		"small":  func() error { return storage.UploadString(ctx, "small", "short", contentTypeText, nil) },
+		"binary": func() error { return storage.UploadString(ctx, "binary", content, "image/png", nil) },
+		"stream": func() error {
+			return storage.UploadReader(ctx, "stream", strings.NewReader(content), contentTypeText, nil)
+		},
+	} {
+		if err := upload(); err != nil {
+			t.Fatalf("upload %s: %v", key, err)
+		}
+		if _, encoding, err := storage.HeadEncoded(ctx, key); err != nil || encoding != "" {
+			t.Fatalf("%s: expected no encoding, got %q: %v", key, encoding, err)
+		}
+	}
+}
+
+func TestServeCompressedPaste(t *testing.T) {
+	handler, backend := newTestHandler(t)
+	handler.Storage = NewCompressedStorage(backend)
+
+	content := strings.Repeat("panic: runtime error\n", 100)
+	object := pasteCookie(t, serve(handler, newMultipartRequest(t, map[string]string{"content": content, "syntax": "plain"}, nil)))
+	for _, key := range []string{object.RawKey, object.HtmlKey} {
+		if info, _ := backend.HeadObject(context.Background(), key); info.Metadata[contentEncodingMetadataKey] != encodingGzip {
+			t.Fatalf("%s is not stored compressed", key)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/"+object.RawKey, nil)
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	resp := serve(handler, req)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != encodingGzip || resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected a gzipped response, got %d %v", resp.StatusCode, resp.Header)
+	}
+	reader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	if data, _ := io.ReadAll(reader); string(data) != content {
+		t.Fatal("unexpected decompressed content")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/"+object.RawKey, nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0")
+	resp = serve(handler, req)
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" || string(data) != content {
+		t.Fatalf("expected the decompressed paste, got %d %v", resp.StatusCode, resp.Header)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/"+object.RawKey, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=7-19")
+	resp = serve(handler, req)
+	data, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || resp.Header.Get("Content-Encoding") != "" || string(data) != "runtime error" {
+		t.Fatalf("unexpected range response %d %q", resp.StatusCode, data)
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	for header, expected := range map[string]bool{
+		"":                   false,
+		"gzip":               true,
+		"deflate, GZIP":      true,
+		"gzip;q=0.5, br":     true,
+		"gzip;q=0":           false,
+		"br, *":              true,
+		"identity, deflate":  false,
+		"gzip; q=0.0, *;q=1": false,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", header)
+		if acceptsEncoding(req, encodingGzip) != expected {
+			t.Errorf("%q: expected %v", header, expected)
+		}
+	}
+}
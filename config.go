@@ -5,6 +5,7 @@ import (
 	"github.com/spf13/viper"
 	"reflect"
 	"strings"
+	"time"
 )
 
 // In config.go
@@ -25,7 +26,17 @@ func GetConfig() *Config {
 type Config struct {
 	// Server settings
 	Address            string `mapstructure:"address"`
-	MultipartMaxMemory int64  `mapstructure:"multipart_max_memory"`
+	MultipartMaxMemory int64  `mapstructure:"multipart_max_memory"` // Limit for the form fields of a multipart upload, files are streamed
+	MaxUploadSize      int64  `mapstructure:"max_upload_size"`      // Largest accepted file upload in bytes, 0 for unlimited
+	TrustedProxyHeader string `mapstructure:"trusted_proxy_header"` // Header a trusted reverse proxy puts the client address in, e.g. "X-Forwarded-For"
+
+	// Resumable upload settings
+	UploadExpire  time.Duration `mapstructure:"upload_expire"`  // How long an unfinished upload is kept after its last request
+	DirectUploads bool          `mapstructure:"direct_uploads"` // Let clients upload files straight to S3 with presigned requests
+	PresignExpire time.Duration `mapstructure:"presign_expire"` // How long presigned storage URLs stay valid
+
+	// Link pastes through /p/<id>, which redirects to a fresh presigned URL, so the bucket can stay private
+	PresignedLinks bool `mapstructure:"presigned_links"`
 
 	// URLs
 	IndexURL        string `mapstructure:"index_url"`
@@ -34,6 +45,33 @@ type Config struct {
 	FaviconURL      string `mapstructure:"favicon_url"`
 	Style           string `mapstructure:"style"`
 
+	// Paste ID settings
+	IDGenerator string `mapstructure:"id_generator"` // Paste ID format: "uuid" (default), "base62" or "words"
+	IDLength    int    `mapstructure:"id_length"`    // Characters for base62 IDs, words for word-based IDs
+
+	// Expiration settings
+	DefaultExpire       string        `mapstructure:"default_expire"`        // Expiration used when none is requested, e.g. "1w" or "never"
+	MaxExpire           string        `mapstructure:"max_expire"`            // Longest allowed expiration, empty for unlimited
+	ExpireSweepInterval time.Duration `mapstructure:"expire_sweep_interval"` // How often abandoned uploads are removed, 0 disables the sweeper
+	ExpireSweepPastes   bool          `mapstructure:"expire_sweep_pastes"`   // Whether sweeps remove expired pastes, reading every object
+
+	// Garbage collection settings
+	GCInterval    time.Duration `mapstructure:"gc_interval"`     // How often orphaned objects are collected, 0 disables the collector
+	GCGracePeriod time.Duration `mapstructure:"gc_grace_period"` // Minimum age of an orphaned object before it is deleted
+
+	// Storage settings
+	Storage     string `mapstructure:"storage"`      // Storage backend: "s3" (default) or "filesystem"
+	StoragePath string `mapstructure:"storage_path"` // Root directory for the filesystem backend
+
+	// Encryption at rest: comma-separated "id:base64-key" master keys, the first one encrypts new objects
+	EncryptionKeys string `mapstructure:"encryption_keys"`
+	// Deduplication: identical paste content is stored once and shared by the pastes
+	Dedup bool `mapstructure:"dedup"`
+	// Secret keying the content IDs of deduplicated pastes, required with encryption at rest
+	DedupSecret string `mapstructure:"dedup_secret"`
+	// Compression: text pastes and pages are stored gzipped
+	Compress bool `mapstructure:"compress"`
+
 	// S3 settings
 	S3Endpoint   string `mapstructure:"s3_endpoint"`
 	S3Region     string `mapstructure:"s3_region"`
@@ -73,20 +111,28 @@ func MaskSecret(secret string) string {
 // LogConfig logs configuration settings while hiding secrets
 func LogConfig() {
 	categories := map[string][]string{
-		"Server": {"address", "multipart_max_memory"},
-		"URL":    {"index_url", "result_url_prefix", "logo_url", "favicon_url", "style"},
-		"S3":     {"s3_endpoint", "s3_region", "s3_bucket", "s3_key_id", "s3_secret_key", "s3_path_style", "s3_disable_ssl"},
+		"Server":  {"address", "multipart_max_memory", "max_upload_size", "trusted_proxy_header", "upload_expire", "direct_uploads", "presign_expire", "presigned_links"},
+		"URL":     {"index_url", "result_url_prefix", "logo_url", "favicon_url", "style"},
+		"IDs":     {"id_generator", "id_length"},
+		"Expire":  {"default_expire", "max_expire", "expire_sweep_interval", "expire_sweep_pastes"},
+		"GC":      {"gc_interval", "gc_grace_period"},
+		"Storage": {"storage", "storage_path", "encryption_keys", "dedup", "dedup_secret", "compress"},
+		"S3":      {"s3_endpoint", "s3_region", "s3_bucket", "s3_key_id", "s3_secret_key", "s3_path_style", "s3_disable_ssl"},
 	}
 
 	for category, keys := range categories {
 		log.Debugf("%s settings:", category)
 		for _, key := range keys {
 			value := viper.Get(key)
-			if key == "s3_secret_key" && value != nil {
-				valueStr, ok := value.(string)
-				if ok && valueStr != "" {
+			if valueStr, ok := value.(string); ok && valueStr != "" {
+				switch key {
+				case "s3_secret_key":
 					log.Debugf("  MKRN_%s: %s", strings.ToUpper(key), MaskSecret(valueStr))
 					continue
+				case "encryption_keys", "dedup_secret":
+					// Even a few characters of a key weaken it, nothing of these is shown
+					log.Debugf("  MKRN_%s: [redacted]", strings.ToUpper(key))
+					continue
 				}
 			}
 			log.Debugf("  MKRN_%s: %v", strings.ToUpper(key), value)
@@ -0,0 +1,92 @@
+package makaroni
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxRawPasteSize limits pastes sent as a plain request body
+	maxRawPasteSize = 32 << 20
+	// syntaxHeader selects the syntax for clients that cannot easily add query parameters
+	syntaxHeader         = "X-Syntax"
+	contentTypePlainText = "text/plain; charset=utf-8"
+)
+
+// isMultipartRequest reports whether the request body is a multipart form
+func isMultipartRequest(req *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// wantsPlainText reports whether the client expects a plain-text answer instead of HTML pages.
+// Browsers submitting the index form always accept text/html, command line tools usually do not.
+func wantsPlainText(req *http.Request) bool {
+	if !isMultipartRequest(req) {
+		return true
+	}
+	return !strings.Contains(req.Header.Get("Accept"), contentTypeHTML)
+}
+
+// pasteParam returns a paste parameter from the parsed form or the query string.
+// The syntax may also be passed in the X-Syntax header.
+func pasteParam(req *http.Request, name string) string {
+	values := req.Form
+	if values == nil {
+		values = req.URL.Query()
+	}
+	if value := values.Get(name); value != "" {
+		return value
+	}
+	if name == "syntax" {
+		return req.Header.Get(syntaxHeader)
+	}
+	return ""
+}
+
+// getRawContent builds a paste request from a plain request body, e.g. `curl --data-binary @file`
+func (p *PasteHandler) getRawContent(w http.ResponseWriter, req *http.Request) (*pasteRequest, error) {
+	content, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxRawPasteSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return &pasteRequest{
+		Content: string(content),
+		Syntax:  pasteParam(req, "syntax"),
+		Expire:  pasteParam(req, "expire"),
+		Views:   pasteParam(req, "views"),
+		Burn:    pasteParam(req, "burn"),
+		Slug:    pasteParam(req, "slug"),
+	}, nil
+}
+
+// respondPlainCreated answers with the paste URL on the first line, followed by the raw and delete URLs
+func (p *PasteHandler) respondPlainCreated(w http.ResponseWriter, result *pasteResult) {
+	w.Header().Set("Content-Type", contentTypePlainText)
+	w.Header().Set("Location", result.URL)
+	w.WriteHeader(http.StatusCreated)
+
+	if _, err := fmt.Fprintf(w, "%s\nraw: %s\ndelete: %s\n", result.URL, result.RawURL, p.deleteURL(result)); err != nil {
+		log.Error("Error writing plain response: ", err)
+	}
+}
+
+// respondError sends an error as plain text or as the HTML error page
+func (p *PasteHandler) respondError(w http.ResponseWriter, plain bool, statusCode int, message string) {
+	if !plain {
+		p.RespondWithError(w, statusCode, message, p.Config)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypePlainText)
+	w.WriteHeader(statusCode)
+	if _, err := fmt.Fprintf(w, "error: %s\n", message); err != nil {
+		log.Error("Error writing plain error response: ", err)
+	}
+}
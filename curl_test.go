@@ -0,0 +1,91 @@
+package makaroni
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// parsePlainResponse splits the plain-text answer into the paste, raw and delete URLs
+func parsePlainResponse(t *testing.T, resp *http.Response) (string, string, string) {
+	t.Helper()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", resp.StatusCode, body)
+	}
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "raw: ") || !strings.HasPrefix(lines[2], "delete: ") {
+		t.Fatalf("unexpected plain response %q", body)
+	}
+	return lines[0], strings.TrimPrefix(lines[1], "raw: "), strings.TrimPrefix(lines[2], "delete: ")
+}
+
+func TestPostRawBody(t *testing.T) {
+	handler, storage := newTestHandler(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/?syntax=go&expire=1h", strings.NewReader("package main\n"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp := serve(handler, req)
+	pasteURL, rawURL, deleteURL := parsePlainResponse(t, resp)
+
+	if resp.Header.Get("Location") != pasteURL || !strings.HasPrefix(pasteURL, testURLPrefix) {
+		t.Fatalf("unexpected paste URL %q", pasteURL)
+	}
+	rawKey := strings.TrimPrefix(rawURL, testURLPrefix)
+	raw, info := readObject(t, storage, rawKey)
+	if raw != "package main\n" || info.Metadata[expireMetadataKey] == "" {
+		t.Fatalf("unexpected raw object %q %v", raw, info.Metadata)
+	}
+	html, _ := readObject(t, storage, rawKey+".html")
+	if !strings.Contains(html, "<span") {
+		t.Fatal("content was not highlighted with the requested syntax")
+	}
+
+	parsed, err := url.Parse(deleteURL)
+	if err != nil {
+		t.Fatalf("parse delete URL: %v", err)
+	}
+	resp = serve(handler, httptest.NewRequest(http.MethodDelete, "/?"+parsed.RawQuery, nil))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("delete with the returned URL failed: %d", resp.StatusCode)
+	}
+}
+
+func TestPostSyntaxHeader(t *testing.T) {
+	handler, storage := newTestHandler(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("SELECT 1;"))
+	req.Header.Set(syntaxHeader, "sql")
+	_, rawURL, _ := parsePlainResponse(t, serve(handler, req))
+
+	html, _ := readObject(t, storage, strings.TrimPrefix(rawURL, testURLPrefix)+".html")
+	if !strings.Contains(html, "SELECT</span>") {
+		t.Fatal("content was not highlighted as SQL")
+	}
+}
+
+func TestPostSprungeField(t *testing.T) {
+	handler, storage := newTestHandler(t)
+
+	req := newMultipartRequest(t, map[string]string{"f": "from stdin"}, nil)
+	req.Header.Del("Accept")
+	_, rawURL, _ := parsePlainResponse(t, serve(handler, req))
+
+	if raw, _ := readObject(t, storage, strings.TrimPrefix(rawURL, testURLPrefix)); raw != "from stdin" {
+		t.Fatalf("unexpected raw content %q", raw)
+	}
+}
+
+func TestPostEmptyRawBody(t *testing.T) {
+	handler, _ := newTestHandler(t)
+
+	resp := serve(handler, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("")))
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest || string(body) != "error: Empty paste\n" {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,493 @@
+package makaroni
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	blobMetadataKey     = "blob"      // Key of the blob holding the content, on pointers and index entries
+	blobIDMetadataKey   = "blob-id"   // ID of the content in the index, on pointers
+	blobSizeMetadataKey = "blob-size" // Content size, on pointers
+	blobRefsMetadataKey = "blob-refs" // Number of pointers to the blob, on index entries
+
+	// blobDataPrefix holds the content of deduplicated pastes, every blob under its own random key
+	blobDataPrefix = ".blobs/data/"
+	// blobIndexPrefix maps the ID of content, "<prefix><hex>", to the blob storing it
+	blobIndexPrefix = ".blobs/index/"
+
+	blobLockStripes = 64
+)
+
+// DedupStorage stores identical paste content once. The raw object of a paste becomes an empty pointer
+// carrying the paste metadata and referring to a blob; blobs are found by the ID of their content and are
+// deleted with the last pointer referring to them. The ID is an HMAC of the SHA-256 of the content, computed
+// while it is uploaded, so the index does not reveal which content encrypted pastes hold.
+// Other objects, such as paste pages, are stored as they are.
+//
+// Reference counts are updated under a lock held by this process, so a storage must not be shared by
+// several makaroni processes with deduplication enabled, including the admin and gc commands while a
+// server is running. DedupStorage implements neither MultipartStorage
+// nor PresignStorage, the storage must never be handed a pointer instead of the content.
+type DedupStorage struct {
+	storage Storage
+	secret  []byte
+	locks   [blobLockStripes]sync.Mutex
+}
+
+var _ Storage = (*DedupStorage)(nil)
+
+// NewDedupStorage wraps storage, content IDs are keyed with the secret. Changing the secret
+// leaves existing pastes intact, only their content is no longer shared with new ones.
+func NewDedupStorage(storage Storage, secret []byte) *DedupStorage {
+	return &DedupStorage{storage: storage, secret: secret}
+}
+
+// Unwrap returns the wrapped storage
+func (d *DedupStorage) Unwrap() Storage {
+	return d.storage
+}
+
+// UploadString stores string content under the key, reusing a blob with the same content if there is one
+func (d *DedupStorage) UploadString(ctx context.Context, key string, content string, contentType string, metadata map[string]string) error {
+	if !isPasteContent(key, metadata) {
+		return d.storage.UploadString(ctx, key, content, contentType, metadata)
+	}
+
+	sum := sha256.Sum256([]byte(content))
+	id := d.contentID(sum[:])
+	blobKey, err := d.reference(ctx, id, func() (string, error) {
+		// The content is known in advance, so it is only uploaded when no blob holds it yet
+		blobKey := newBlobKey()
+		return blobKey, d.storage.UploadString(ctx, blobKey, content, contentType, nil)
+	})
+	if err != nil {
+		return err
+	}
+	return d.writePointer(ctx, key, blobKey, id, int64(len(content)), contentType, metadata)
+}
+
+// UploadReader stores data read from reader under the key. The content is streamed to a new blob while
+// it is hashed, the blob is dropped again if another one already holds the same content.
+func (d *DedupStorage) UploadReader(ctx context.Context, key string, reader io.Reader, contentType string, metadata map[string]string) error {
+	if !isPasteContent(key, metadata) {
+		return d.storage.UploadReader(ctx, key, reader, contentType, metadata)
+	}
+
+	uploaded := newBlobKey()
+	hashing := &hashingReader{source: reader, hash: sha256.New()}
+	if err := d.storage.UploadReader(ctx, uploaded, hashing, contentType, nil); err != nil {
+		d.deleteBlobs(ctx, uploaded)
+		return err
+	}
+
+	id := d.contentID(hashing.hash.Sum(nil))
+	blobKey, err := d.reference(ctx, id, func() (string, error) {
+		return uploaded, nil
+	})
+	if err != nil {
+		d.deleteBlobs(ctx, uploaded)
+		return err
+	}
+	if blobKey != uploaded {
+		log.Debugf("Content of %s is already stored in %s", key, blobKey)
+		d.deleteBlobs(ctx, uploaded)
+	}
+	return d.writePointer(ctx, key, blobKey, id, hashing.size, contentType, metadata)
+}
+
+// HeadObject returns object information, for a pointer the size of its content and the content ID as ETag
+func (d *DedupStorage) HeadObject(ctx context.Context, key string) (*ObjectInfo, error) {
+	info, err := d.storage.HeadObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return contentInfo(info), nil
+}
+
+// GetMetadata returns the user metadata of an object without the pointer metadata
+func (d *DedupStorage) GetMetadata(ctx context.Context, key string) (map[string]string, error) {
+	metadata, err := d.storage.GetMetadata(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return pointerlessMetadata(metadata), nil
+}
+
+// UpdateMetadata replaces the user metadata of an object, a pointer keeps referring to its blob
+func (d *DedupStorage) UpdateMetadata(ctx context.Context, key string, metadata map[string]string) error {
+	current, err := d.storage.GetMetadata(ctx, key)
+	if err != nil {
+		return err
+	}
+	stored := pointerlessMetadata(metadata)
+	if isPointer(current) {
+		for _, name := range []string{blobMetadataKey, blobIDMetadataKey, blobSizeMetadataKey} {
+			stored[name] = current[name]
+		}
+	}
+	return d.storage.UpdateMetadata(ctx, key, stored)
+}
+
+// GetObject returns the object content, read from the blob for a pointer. The caller must close the reader.
+func (d *DedupStorage) GetObject(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	body, info, err := d.storage.GetObject(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isPointer(info.Metadata) {
+		return body, info, nil
+	}
+	body.Close()
+
+	content, _, err := d.storage.GetObject(ctx, info.Metadata[blobMetadataKey])
+	if err != nil {
+		return nil, nil, fmt.Errorf("blob of %s: %w", key, err)
+	}
+	return content, contentInfo(info), nil
+}
+
+// GetObjectRange returns length bytes of the object starting at offset, read from the blob for a pointer
+func (d *DedupStorage) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	metadata, err := d.storage.GetMetadata(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !isPointer(metadata) {
+		return d.storage.GetObjectRange(ctx, key, offset, length)
+	}
+	body, err := d.storage.GetObjectRange(ctx, metadata[blobMetadataKey], offset, length)
+	if err != nil {
+		return nil, fmt.Errorf("blob of %s: %w", key, err)
+	}
+	return body, nil
+}
+
+// DeleteObjects removes multiple objects, missing keys are ignored. Blobs are deleted
+// once no pointer refers to them anymore.
+func (d *DedupStorage) DeleteObjects(ctx context.Context, keys []string) error {
+	var plain []string
+	for _, key := range keys {
+		if isInternalKey(key) {
+			plain = append(plain, key)
+			continue
+		}
+		metadata, err := d.storage.GetMetadata(ctx, key)
+		if errors.Is(err, ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !isPointer(metadata) {
+			plain = append(plain, key)
+			continue
+		}
+		for id := metadata[blobIDMetadataKey]; id != ""; {
+			if id, err = d.deletePointer(ctx, key, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(plain) == 0 {
+		return nil
+	}
+	return d.storage.DeleteObjects(ctx, plain)
+}
+
+// deletePointer deletes the pointer under key and releases its reference, provided it still refers to
+// the content with the ID. Checked under the lock of the ID, concurrent deletes of a paste release it once.
+// When the pointer has been replaced meanwhile, the ID it refers to now is returned to try again with.
+func (d *DedupStorage) deletePointer(ctx context.Context, key, id string) (string, error) {
+	mu := d.lock(id)
+	mu.Lock()
+	defer mu.Unlock()
+
+	metadata, err := d.storage.GetMetadata(ctx, key)
+	if errors.Is(err, ErrObjectNotFound) {
+		// Deleted by another call, which released the reference
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if isPointer(metadata) && metadata[blobIDMetadataKey] != id {
+		return metadata[blobIDMetadataKey], nil
+	}
+
+	// The pointer goes first: should releasing fail, a blob is kept too long rather than lost
+	if err := d.storage.DeleteObjects(ctx, []string{key}); err != nil {
+		return "", err
+	}
+	if !isPointer(metadata) {
+		return "", nil
+	}
+	return "", d.releaseLocked(ctx, id)
+}
+
+// ListObjects calls fn for every object whose key starts with prefix, sizes are the stored sizes
+func (d *DedupStorage) ListObjects(ctx context.Context, prefix string, fn ListFunc) error {
+	return d.storage.ListObjects(ctx, prefix, fn)
+}
+
+// reference adds a reference to the blob holding the content with the ID and returns its key.
+// When there is none, store is called to provide the blob, which is then indexed.
+func (d *DedupStorage) reference(ctx context.Context, id string, store func() (string, error)) (string, error) {
+	mu := d.lock(id)
+	mu.Lock()
+	defer mu.Unlock()
+
+	index, err := d.storage.GetMetadata(ctx, blobIndexPrefix+id)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return "", fmt.Errorf("look up blob %s: %w", id, err)
+	}
+	if err == nil {
+		refs, _ := strconv.Atoi(index[blobRefsMetadataKey])
+		index[blobRefsMetadataKey] = strconv.Itoa(refs + 1)
+		if err := d.storage.UpdateMetadata(ctx, blobIndexPrefix+id, index); err != nil {
+			return "", fmt.Errorf("reference blob %s: %w", id, err)
+		}
+		return index[blobMetadataKey], nil
+	}
+
+	blobKey, err := store()
+	if err != nil {
+		d.deleteBlobs(ctx, blobKey)
+		return "", err
+	}
+	index = map[string]string{blobMetadataKey: blobKey, blobRefsMetadataKey: "1"}
+	if err := d.storage.UploadString(ctx, blobIndexPrefix+id, "", contentTypeText, index); err != nil {
+		d.deleteBlobs(ctx, blobKey)
+		return "", fmt.Errorf("index blob %s: %w", id, err)
+	}
+	return blobKey, nil
+}
+
+// release drops a reference to the blob holding the content with the ID, deleting it with the last one
+func (d *DedupStorage) release(ctx context.Context, id string) error {
+	mu := d.lock(id)
+	mu.Lock()
+	defer mu.Unlock()
+	return d.releaseLocked(ctx, id)
+}
+
+// releaseLocked drops a reference like release, the caller holds the lock of the ID
+func (d *DedupStorage) releaseLocked(ctx context.Context, id string) error {
+	index, err := d.storage.GetMetadata(ctx, blobIndexPrefix+id)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("look up blob %s: %w", id, err)
+	}
+
+	refs, _ := strconv.Atoi(index[blobRefsMetadataKey])
+	if refs > 1 {
+		index[blobRefsMetadataKey] = strconv.Itoa(refs - 1)
+		if err := d.storage.UpdateMetadata(ctx, blobIndexPrefix+id, index); err != nil {
+			return fmt.Errorf("release blob %s: %w", id, err)
+		}
+		return nil
+	}
+
+	log.Debug("Deleting unreferenced blob: ", index[blobMetadataKey])
+	if err := d.storage.DeleteObjects(ctx, []string{blobIndexPrefix + id, index[blobMetadataKey]}); err != nil {
+		return fmt.Errorf("delete blob %s: %w", id, err)
+	}
+	return nil
+}
+
+// writePointer stores the paste object referring to a blob. A pointer it replaces is released,
+// and the new reference is dropped again if the pointer cannot be written.
+func (d *DedupStorage) writePointer(ctx context.Context, key, blobKey, id string, size int64, contentType string, metadata map[string]string) error {
+	pointer := pointerlessMetadata(metadata)
+	pointer[blobMetadataKey] = blobKey
+	pointer[blobIDMetadataKey] = id
+	pointer[blobSizeMetadataKey] = strconv.FormatInt(size, 10)
+
+	for {
+		previous, err := d.storage.GetMetadata(ctx, key)
+		if errors.Is(err, ErrObjectNotFound) || (err == nil && !isPointer(previous)) {
+			err = d.storage.UploadString(ctx, key, "", contentType, pointer)
+		} else if err == nil {
+			var replaced bool
+			replaced, err = d.replacePointer(ctx, key, previous[blobIDMetadataKey], contentType, pointer)
+			if replaced {
+				// The pointer is written, an error can only come from releasing the previous one
+				return err
+			}
+			if err == nil {
+				continue
+			}
+		}
+
+		if err != nil {
+			if releaseErr := d.release(ctx, id); releaseErr != nil {
+				log.Error("Error releasing blob: ", releaseErr)
+			}
+		}
+		return err
+	}
+}
+
+// replacePointer overwrites the pointer under key and releases its reference, provided it still refers to
+// the content with previousID. Checked under the lock of that ID, a concurrent delete cannot release it again.
+func (d *DedupStorage) replacePointer(ctx context.Context, key, previousID, contentType string, pointer map[string]string) (bool, error) {
+	mu := d.lock(previousID)
+	mu.Lock()
+	defer mu.Unlock()
+
+	current, err := d.storage.GetMetadata(ctx, key)
+	if errors.Is(err, ErrObjectNotFound) || (err == nil && current[blobIDMetadataKey] != previousID) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := d.storage.UploadString(ctx, key, "", contentType, pointer); err != nil {
+		return false, err
+	}
+	return true, d.releaseLocked(ctx, previousID)
+}
+
+// collectBlobs finds blobs stored before the cutoff that no index entry refers to and, unless dryRun is set,
+// deletes them. They are left behind when a process stops between storing a blob and indexing it.
+func (d *DedupStorage) collectBlobs(ctx context.Context, before time.Time, dryRun bool) ([]string, error) {
+	referenced := make(map[string]bool)
+	err := d.storage.ListObjects(ctx, blobIndexPrefix, func(info *ObjectInfo) error {
+		index, err := d.storage.GetMetadata(ctx, info.Key)
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		referenced[index[blobMetadataKey]] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var unreferenced []string
+	err = d.storage.ListObjects(ctx, blobDataPrefix, func(info *ObjectInfo) error {
+		if !referenced[info.Key] && info.LastModified.Before(before) {
+			log.Debug("Found unreferenced blob: ", info.Key)
+			unreferenced = append(unreferenced, info.Key)
+		}
+		return nil
+	})
+	if err != nil || dryRun {
+		return unreferenced, err
+	}
+
+	for start := 0; start < len(unreferenced); start += sweepBatchSize {
+		end := start + sweepBatchSize
+		if end > len(unreferenced) {
+			end = len(unreferenced)
+		}
+		if err := d.storage.DeleteObjects(ctx, unreferenced[start:end]); err != nil {
+			return unreferenced, err
+		}
+	}
+	return unreferenced, nil
+}
+
+// deleteBlobs removes blobs nothing refers to, failures only leave garbage behind
+func (d *DedupStorage) deleteBlobs(ctx context.Context, keys ...string) {
+	if err := d.storage.DeleteObjects(ctx, keys); err != nil {
+		log.Error("Error deleting blobs: ", err)
+	}
+}
+
+// lock returns the lock guarding the reference count of the content with the ID
+func (d *DedupStorage) lock(id string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return &d.locks[h.Sum32()%blobLockStripes]
+}
+
+// contentID returns the index ID of content with the SHA-256 sum
+func (d *DedupStorage) contentID(sum []byte) string {
+	mac := hmac.New(sha256.New, d.secret)
+	mac.Write(sum)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// findDedupStorage returns the DedupStorage among the wrappers of storage, nil if there is none
+func findDedupStorage(storage Storage) *DedupStorage {
+	for {
+		switch s := storage.(type) {
+		case *DedupStorage:
+			return s
+		case interface{ Unwrap() Storage }:
+			storage = s.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// newBlobKey returns a new random blob key
+func newBlobKey() string {
+	return blobDataPrefix + uuid.NewString()
+}
+
+// isPasteContent reports whether an object is the raw content of a paste, which is deduplicated
+func isPasteContent(key string, metadata map[string]string) bool {
+	return !isInternalKey(key) && metadata[htmlKeyMetadataKey] != ""
+}
+
+// isPointer reports whether stored metadata describes a pointer to a blob
+func isPointer(metadata map[string]string) bool {
+	return metadata[blobMetadataKey] != "" && metadata[blobIDMetadataKey] != ""
+}
+
+// pointerlessMetadata returns a copy of the metadata without the pointer entries
+func pointerlessMetadata(metadata map[string]string) map[string]string {
+	result := copyMetadata(metadata)
+	delete(result, blobMetadataKey)
+	delete(result, blobIDMetadataKey)
+	delete(result, blobSizeMetadataKey)
+	return result
+}
+
+// contentInfo describes a stored object as seen through its pointer
+func contentInfo(info *ObjectInfo) *ObjectInfo {
+	if !isPointer(info.Metadata) {
+		return info
+	}
+	result := *info
+	result.Size, _ = strconv.ParseInt(info.Metadata[blobSizeMetadataKey], 10, 64)
+	result.ETag = `"` + info.Metadata[blobIDMetadataKey] + `"`
+	result.Metadata = pointerlessMetadata(info.Metadata)
+	return &result
+}
+
+// hashingReader hashes and counts the data read from source
+type hashingReader struct {
+	source io.Reader
+	hash   hash.Hash
+	size   int64
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.source.Read(p)
+	r.hash.Write(p[:n])
+	r.size += int64(n)
+	return n, err
+}
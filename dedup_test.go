@@ -0,0 +1,177 @@
+package makaroni
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDedupPastes(t *testing.T) {
+	handler, backend := newTestHandler(t)
+	storage := NewDedupStorage(backend, []byte("secret"))
+	handler.Storage = storage
+
+	first := createAPIPaste(t, handler, `{"content": "build log\n", "syntax": "plain"}`)
+	second := createAPIPaste(t, handler, `{"content": "build log\n", "syntax": "go"}`)
+	other := createAPIPaste(t, handler, `{"content": "other log\n"}`)
+
+	if blobs := storedKeys(t, backend, blobDataPrefix); len(blobs) != 2 {
+		t.Fatalf("expected two blobs, got %v", blobs)
+	}
+	sum := sha256.Sum256([]byte("build log\n"))
+	id := storage.contentID(sum[:])
+	if id == hex.EncodeToString(sum[:]) || id == NewDedupStorage(backend, []byte("other")).contentID(sum[:]) {
+		t.Fatalf("content ID %s is not keyed with the secret", id)
+	}
+	index, err := backend.GetMetadata(context.Background(), blobIndexPrefix+id)
+	if err != nil || index[blobRefsMetadataKey] != "2" {
+		t.Fatalf("expected two references to the shared blob, got %v: %v", index, err)
+	}
+	if pointer, _ := readObject(t, backend, first.ID); pointer != "" {
+		t.Fatalf("expected an empty pointer, got %q", pointer)
+	}
+
+	deletePaste := func(paste PasteInfo) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodDelete, apiPastesPath+"/"+paste.ID, nil)
+		req.Header.Set(deleteKeyHeader, paste.DeleteKey)
+		if resp := serve(handler, req); resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("delete %s: expected 204, got %d", paste.ID, resp.StatusCode)
+		}
+	}
+
+	deletePaste(first)
+	resp := serve(handler, httptest.NewRequest(http.MethodGet, "/"+second.ID, nil))
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "build log\n" || resp.Header.Get("ETag") != `"`+id+`"` {
+		t.Fatalf("unexpected response for the remaining paste %d %q %q", resp.StatusCode, body, resp.Header.Get("ETag"))
+	}
+
+	deletePaste(second)
+	deletePaste(other)
+	if keys := storedKeys(t, backend, ""); len(keys) != 0 {
+		t.Fatalf("expected no stored objects, got %v", keys)
+	}
+}
+
+func TestDedupStorageStreams(t *testing.T) {
+	backend := NewMemoryStorage()
+	storage := NewDedupStorage(backend, nil)
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	for _, key := range []string{"a.bin", "b.bin"} {
+		metadata := map[string]string{htmlKeyMetadataKey: strings.TrimSuffix(key, ".bin") + ".html", "filename": key}
+		if err := storage.UploadReader(ctx, key, bytes.NewReader(content), "application/octet-stream", metadata); err != nil {
+			t.Fatalf("upload %s: %v", key, err)
+		}
+	}
+	if blobs := storedKeys(t, backend, blobDataPrefix); len(blobs) != 1 {
+		t.Fatalf("expected one blob, got %v", blobs)
+	}
+
+	info, err := storage.HeadObject(ctx, "b.bin")
+	if err != nil || info.Size != int64(len(content)) || info.Metadata["filename"] != "b.bin" || isPointer(info.Metadata) {
+		t.Fatalf("unexpected object info %+v: %v", info, err)
+	}
+
+	if err := storage.UpdateMetadata(ctx, "b.bin", map[string]string{"views": "1"}); err != nil {
+		t.Fatalf("update metadata: %v", err)
+	}
+	reader, err := storage.GetObjectRange(ctx, "b.bin", 5, 7)
+	if err != nil {
+		t.Fatalf("get range: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "5678901" {
+		t.Fatalf("unexpected range content %q", data)
+	}
+
+	// Objects that are not paste content are stored as they are
+	if err := storage.UploadString(ctx, "a.html", "<p>page</p>", contentTypeHTML, map[string]string{rawKeyMetadataKey: "a.bin"}); err != nil {
+		t.Fatalf("upload page: %v", err)
+	}
+	if page, _ := readObject(t, backend, "a.html"); page != "<p>page</p>" {
+		t.Fatalf("page is not stored as is: %q", page)
+	}
+
+	if err := storage.DeleteObjects(ctx, []string{"a.bin", "a.html"}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if data, _ := readObject(t, storage, "b.bin"); data != string(content) {
+		t.Fatal("content of the remaining pointer changed")
+	}
+	if err := storage.DeleteObjects(ctx, []string{"b.bin"}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if keys := storedKeys(t, backend, ""); len(keys) != 0 {
+		t.Fatalf("expected no stored objects, got %v", keys)
+	}
+}
+
+func TestDedupLockStripes(t *testing.T) {
+	storage := NewDedupStorage(NewMemoryStorage(), []byte("secret"))
+
+	stripes := make(map[*sync.Mutex]bool)
+	for i := 0; i < 1000; i++ {
+		sum := sha256.Sum256([]byte(strconv.Itoa(i)))
+		stripes[storage.lock(storage.contentID(sum[:]))] = true
+	}
+	if len(stripes) != blobLockStripes {
+		t.Fatalf("expected content IDs to spread over %d locks, got %d", blobLockStripes, len(stripes))
+	}
+}
+
+// slowMetadataStorage is a memory storage with slow metadata reads, widening the window for races
+type slowMetadataStorage struct {
+	*MemoryStorage
+}
+
+func (s slowMetadataStorage) GetMetadata(ctx context.Context, key string) (map[string]string, error) {
+	time.Sleep(5 * time.Millisecond)
+	return s.MemoryStorage.GetMetadata(ctx, key)
+}
+
+func TestDedupConcurrentDeletesReleaseOnce(t *testing.T) {
+	backend := slowMetadataStorage{NewMemoryStorage()}
+	storage := NewDedupStorage(backend, []byte("secret"))
+	ctx := context.Background()
+
+	for _, key := range []string{"kept", "burned"} {
+		if err := storage.UploadString(ctx, key, "shared log", contentTypeText, map[string]string{htmlKeyMetadataKey: key + ".html"}); err != nil {
+			t.Fatalf("upload %s: %v", key, err)
+		}
+	}
+
+	// A burn after serving racing a DELETE of the same paste
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := storage.DeleteObjects(ctx, []string{"burned", "burned.html"}); err != nil {
+				t.Errorf("delete: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	sum := sha256.Sum256([]byte("shared log"))
+	index, err := backend.GetMetadata(ctx, blobIndexPrefix+storage.contentID(sum[:]))
+	if err != nil || index[blobRefsMetadataKey] != "1" {
+		t.Fatalf("expected one remaining reference, got %v: %v", index, err)
+	}
+	if content, _ := readObject(t, storage, "kept"); content != "shared log" {
+		t.Fatalf("content of the remaining paste is lost: %q", content)
+	}
+}
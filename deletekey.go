@@ -0,0 +1,139 @@
+package makaroni
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// deleteHashMetadataKey holds the salted hash of the delete key
+	deleteHashMetadataKey = "delete-hash"
+	// legacyDeleteMetadataKey holds the plaintext delete key of pastes created by older versions
+	legacyDeleteMetadataKey = "delete"
+	deleteKeySaltSize       = 16
+)
+
+var (
+	ErrInvalidDeleteKey = errors.New("invalid delete key")
+)
+
+// newDeleteKey returns a random delete key
+func newDeleteKey() (string, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// hashDeleteKey returns a "salt:hash" string with a random salt, both hex-encoded
+func hashDeleteKey(deleteKey string) (string, error) {
+	salt := make([]byte, deleteKeySaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return hex.EncodeToString(salt) + ":" + hex.EncodeToString(saltedHash(salt, deleteKey)), nil
+}
+
+// saltedHash computes SHA-256 over the salt followed by the delete key
+func saltedHash(salt []byte, deleteKey string) []byte {
+	hash := sha256.New()
+	hash.Write(salt)
+	hash.Write([]byte(deleteKey))
+	return hash.Sum(nil)
+}
+
+// verifyDeleteKey checks a delete key against object metadata in constant time
+func verifyDeleteKey(metadata map[string]string, deleteKey string) bool {
+	if stored, ok := metadata[deleteHashMetadataKey]; ok {
+		saltHex, hashHex, found := strings.Cut(stored, ":")
+		if !found {
+			return false
+		}
+		salt, err := hex.DecodeString(saltHex)
+		if err != nil {
+			return false
+		}
+		expected, err := hex.DecodeString(hashHex)
+		if err != nil {
+			return false
+		}
+		return subtle.ConstantTimeCompare(saltedHash(salt, deleteKey), expected) == 1
+	}
+
+	if stored, ok := metadata[legacyDeleteMetadataKey]; ok && stored != "" {
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(deleteKey)) == 1
+	}
+	return false
+}
+
+// HashLegacyDeleteKeys replaces the plaintext delete keys stored by older versions with salted hashes,
+// the pastes keep accepting the same delete keys. fn is called for every object still holding a plaintext
+// key, with dryRun nothing is changed. Returns the number of such objects; objects that cannot be updated
+// do not stop the others, the failures are returned together at the end.
+func HashLegacyDeleteKeys(ctx context.Context, storage Storage, dryRun bool, fn func(key string)) (int, error) {
+	var keys []string
+	err := storage.ListObjects(ctx, "", func(info *ObjectInfo) error {
+		if !isInternalKey(info.Key) {
+			keys = append(keys, info.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("list objects: %w", err)
+	}
+
+	hashed := 0
+	var failures []error
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return hashed, err
+		}
+		metadata, err := storage.GetMetadata(ctx, key)
+		if errors.Is(err, ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			failures = append(failures, fmt.Errorf("get metadata of %s: %w", key, err))
+			continue
+		}
+		deleteKey, ok := metadata[legacyDeleteMetadataKey]
+		if !ok {
+			continue
+		}
+
+		if fn != nil {
+			fn(key)
+		}
+		if dryRun {
+			hashed++
+			continue
+		}
+
+		if deleteKey != "" && metadata[deleteHashMetadataKey] == "" {
+			hash, err := hashDeleteKey(deleteKey)
+			if err != nil {
+				return hashed, err
+			}
+			metadata[deleteHashMetadataKey] = hash
+		}
+		delete(metadata, legacyDeleteMetadataKey)
+		if err := storage.UpdateMetadata(ctx, key, metadata); err != nil && !errors.Is(err, ErrObjectNotFound) {
+			failures = append(failures, fmt.Errorf("update metadata of %s: %w", key, err))
+			continue
+		}
+		hashed++
+	}
+	if len(failures) > 0 {
+		return hashed, fmt.Errorf("%d objects were not updated: %w", len(failures), errors.Join(failures...))
+	}
+	return hashed, nil
+}
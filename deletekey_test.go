@@ -0,0 +1,79 @@
+package makaroni
+
+import (
+	"context"
+	"testing"
+)
+
+func TestVerifyDeleteKey(t *testing.T) {
+	first, err := hashDeleteKey("secret")
+	if err != nil {
+		t.Fatalf("hash delete key: %v", err)
+	}
+	second, err := hashDeleteKey("secret")
+	if err != nil {
+		t.Fatalf("hash delete key: %v", err)
+	}
+	if first == second {
+		t.Fatal("hashes of the same key are not salted")
+	}
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		key      string
+		expected bool
+	}{
+		{name: "hash match", metadata: map[string]string{deleteHashMetadataKey: first}, key: "secret", expected: true},
+		{name: "hash mismatch", metadata: map[string]string{deleteHashMetadataKey: first}, key: "guess", expected: false},
+		{name: "malformed hash", metadata: map[string]string{deleteHashMetadataKey: "zz"}, key: "secret", expected: false},
+		{name: "legacy match", metadata: map[string]string{legacyDeleteMetadataKey: "secret"}, key: "secret", expected: true},
+		{name: "legacy mismatch", metadata: map[string]string{legacyDeleteMetadataKey: "secret"}, key: "guess", expected: false},
+		{name: "no key", metadata: map[string]string{}, key: "", expected: false},
+	}
+	for _, tt := range tests {
+		if got := verifyDeleteKey(tt.metadata, tt.key); got != tt.expected {
+			t.Errorf("%s: verifyDeleteKey = %v, want %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestHashLegacyDeleteKeys(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	legacy := map[string]string{legacyDeleteMetadataKey: "secret", "views": "3"}
+	for _, key := range []string{"old", "old.html"} {
+		if err := storage.UploadString(ctx, key, "content", contentTypeText, legacy); err != nil {
+			t.Fatalf("upload: %v", err)
+		}
+	}
+	hash, err := hashDeleteKey("other")
+	if err != nil {
+		t.Fatalf("hash delete key: %v", err)
+	}
+	if err := storage.UploadString(ctx, "new", "content", contentTypeText, map[string]string{deleteHashMetadataKey: hash}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	var listed []string
+	if count, err := HashLegacyDeleteKeys(ctx, storage, true, func(key string) { listed = append(listed, key) }); err != nil || count != 2 || len(listed) != 2 {
+		t.Fatalf("unexpected dry run %v, %d: %v", listed, count, err)
+	}
+	if count, err := HashLegacyDeleteKeys(ctx, storage, false, nil); err != nil || count != 2 {
+		t.Fatalf("expected 2 migrated objects, got %d: %v", count, err)
+	}
+
+	for _, key := range []string{"old", "old.html"} {
+		metadata, err := storage.GetMetadata(ctx, key)
+		if err != nil {
+			t.Fatalf("get metadata: %v", err)
+		}
+		if _, ok := metadata[legacyDeleteMetadataKey]; ok || metadata["views"] != "3" || !verifyDeleteKey(metadata, "secret") {
+			t.Fatalf("%s was not migrated: %v", key, metadata)
+		}
+	}
+	if count, err := HashLegacyDeleteKeys(ctx, storage, false, nil); err != nil || count != 0 {
+		t.Fatalf("expected nothing left to migrate, got %d: %v", count, err)
+	}
+}
@@ -0,0 +1,383 @@
+package makaroni
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	apiDirectUploadsPath  = "/api/v1/direct-uploads"
+	directUploadKeyPrefix = ".direct/"
+
+	// maxDirectUploadSize is the largest object S3 accepts in a single PUT or POST
+	maxDirectUploadSize = 5 << 30
+
+	// DefaultPresignExpire is how long presigned storage URLs stay valid
+	DefaultPresignExpire = time.Hour
+)
+
+// directUploadRequest is the JSON body accepted by POST /api/v1/direct-uploads
+type directUploadRequest struct {
+	FileName    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	Method      string `json:"method"` // "POST" (default) for browser forms or "PUT"
+	Expire      string `json:"expire"`
+	Views       int    `json:"views"`
+	Burn        bool   `json:"burn"`
+	Slug        string `json:"slug"`
+	Password    string `json:"password"`
+}
+
+// DirectUpload tells the client where to upload a file and how to complete the paste afterwards
+type DirectUpload struct {
+	ID          string            `json:"id"`
+	Upload      *PresignedRequest `json:"upload"`
+	Expires     time.Time         `json:"expires"` // When the upload request stops being accepted
+	CompleteURL string            `json:"completeUrl"`
+	URL         string            `json:"url"`
+	RawURL      string            `json:"rawUrl"`
+}
+
+// directUpload is a pending upload the client sends to storage itself, kept until it is completed
+type directUpload struct {
+	ID       string
+	Size     int64
+	FileName string
+	FileType string
+	Plan     *pastePlan
+	Expires  time.Time
+}
+
+func directUploadKey(id string) string {
+	return directUploadKeyPrefix + id
+}
+
+// directUploadDataKey is where the client uploads the file. The paste key only receives a copy on completion,
+// with the paste metadata, so the file is never readable without its password, expiry or view limit.
+func directUploadDataKey(id string) string {
+	return directUploadKeyPrefix + id + "/data"
+}
+
+// handleDirectUploadRequest routes requests under /api/v1/direct-uploads
+func (p *PasteHandler) handleDirectUploadRequest(w http.ResponseWriter, req *http.Request) {
+	if !p.Config.DirectUploads {
+		respondJSONError(w, http.StatusNotFound, "Unknown endpoint")
+		return
+	}
+	presigner, ok := p.Storage.(PresignStorage)
+	if !ok {
+		respondJSONError(w, http.StatusNotImplemented, "Direct uploads are not supported by the storage")
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, apiDirectUploadsPath), "/")
+	id, action, _ := strings.Cut(path, "/")
+	switch {
+	case path == "":
+		if req.Method != http.MethodPost {
+			p.respondAPIMethodNotAllowed(w, http.MethodPost)
+			return
+		}
+		p.createDirectUpload(w, req, presigner)
+	case action == "complete" && !strings.Contains(id, "."):
+		if req.Method != http.MethodPost {
+			p.respondAPIMethodNotAllowed(w, http.MethodPost)
+			return
+		}
+		p.completeDirectUpload(w, req, id)
+	default:
+		respondJSONError(w, http.StatusNotFound, "Unknown endpoint")
+	}
+}
+
+// createDirectUpload plans the paste and presigns the upload of its file to an internal key.
+// The size and content type are part of the signature, storage rejects any other file.
+func (p *PasteHandler) createDirectUpload(w http.ResponseWriter, req *http.Request, presigner PresignStorage) {
+	ctx := req.Context()
+
+	var body directUploadRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxRawPasteSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		log.Warn("Error decoding JSON body: ", err)
+		respondJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	method := strings.ToUpper(body.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+	if method != http.MethodPost && method != http.MethodPut {
+		respondJSONError(w, http.StatusBadRequest, "Method must be POST or PUT")
+		return
+	}
+	if body.Size <= 0 {
+		respondJSONError(w, http.StatusBadRequest, "Invalid size")
+		return
+	}
+	if (p.Config.MaxUploadSize > 0 && body.Size > p.Config.MaxUploadSize) || body.Size > maxDirectUploadSize {
+		log.Warn("Rejected direct upload of ", body.Size, " bytes")
+		respondJSONError(w, http.StatusRequestEntityTooLarge, "File is too large")
+		return
+	}
+
+	pr := (&apiCreateRequest{Expire: body.Expire, Views: body.Views, Burn: body.Burn, Slug: body.Slug, Password: body.Password}).pasteRequest()
+	pr.FileName, pr.FileType, pr.FileSize = body.FileName, body.ContentType, body.Size
+	pr.normalizeFile()
+
+	plan, err := p.planPaste(ctx, pr)
+	if err != nil {
+		status, message := pasteErrorStatus(err)
+		respondJSONError(w, status, message)
+		return
+	}
+	// The delete key is issued on completion and the page rendered then
+	plan.Result.DeleteKey = ""
+	plan.HTML = ""
+
+	id, err := Base62Generator{Length: 24}.NewID()
+	if err != nil {
+		log.Error("Error generating upload ID: ", err)
+		respondJSONError(w, http.StatusInternalServerError, "Failed to create upload")
+		return
+	}
+
+	expire := p.Config.PresignExpire
+	if expire <= 0 {
+		expire = DefaultPresignExpire
+	}
+	var presigned *PresignedRequest
+	if method == http.MethodPut {
+		presigned, err = presigner.PresignPut(directUploadDataKey(id), pr.FileType, pr.FileSize, expire)
+	} else {
+		presigned, err = presigner.PresignPost(directUploadDataKey(id), pr.FileType, pr.FileSize, expire)
+	}
+	if err != nil {
+		log.Error("Error presigning upload: ", err)
+		respondJSONError(w, http.StatusInternalServerError, "Failed to create upload")
+		return
+	}
+
+	// An upload started just before the URL expires may take a while, completion is accepted for longer
+	expires := time.Now().UTC().Add(expire).Truncate(time.Second)
+	upload := &directUpload{
+		ID:       id,
+		Size:     pr.FileSize,
+		FileName: pr.FileName,
+		FileType: pr.FileType,
+		Plan:     plan,
+		Expires:  p.uploadExpiry().Add(expire),
+	}
+	if err := saveDirectUpload(ctx, p.Storage, upload); err != nil {
+		log.Error("Error saving direct upload: ", err)
+		respondJSONError(w, http.StatusInternalServerError, "Failed to create upload")
+		return
+	}
+	log.Infof("Created direct upload %s of %d bytes for key: %s", id, pr.FileSize, plan.Result.RawKey)
+
+	respondJSON(w, http.StatusCreated, DirectUpload{
+		ID:          id,
+		Upload:      presigned,
+		Expires:     expires,
+		CompleteURL: strings.TrimSuffix(p.Config.IndexURL, "/") + apiDirectUploadsPath + "/" + id + "/complete",
+		URL:         plan.Result.URL,
+		RawURL:      plan.Result.RawURL,
+	})
+}
+
+// completeDirectUpload checks the uploaded file, copies it to the paste key with the paste metadata
+// and stores the download page
+func (p *PasteHandler) completeDirectUpload(w http.ResponseWriter, req *http.Request, id string) {
+	ctx := req.Context()
+
+	lock := p.uploadLock(id)
+	if !lock.TryLock() {
+		respondJSONError(w, http.StatusConflict, "Upload is being completed")
+		return
+	}
+	defer lock.Unlock()
+
+	upload, err := loadDirectUpload(ctx, p.Storage, id)
+	if err == nil && !time.Now().Before(upload.Expires) {
+		err = ErrObjectNotFound
+	}
+	if errors.Is(err, ErrObjectNotFound) {
+		respondJSONError(w, http.StatusNotFound, "Upload not found")
+		return
+	}
+	if err != nil {
+		log.Error("Error loading direct upload: ", err)
+		respondJSONError(w, http.StatusInternalServerError, "Failed to load upload")
+		return
+	}
+	result := upload.Plan.Result
+
+	// Keys are only checked when the upload is created, the slug may have been taken since
+	existing, err := headIfExists(ctx, p.Storage, result.HtmlKey)
+	if err != nil {
+		log.Error("Error checking paste keys: ", err)
+		respondJSONError(w, http.StatusInternalServerError, "Failed to complete upload")
+		return
+	}
+	if existing != nil {
+		respondJSONError(w, http.StatusConflict, "This slug is already taken")
+		return
+	}
+
+	dataKey := directUploadDataKey(id)
+	info, err := p.Storage.HeadObject(ctx, dataKey)
+	if errors.Is(err, ErrObjectNotFound) {
+		respondJSONError(w, http.StatusConflict, "File has not been uploaded")
+		return
+	}
+	if err != nil {
+		log.Error("Error checking uploaded file: ", err)
+		respondJSONError(w, http.StatusInternalServerError, "Failed to complete upload")
+		return
+	}
+	if info.Size != upload.Size || info.ContentType != upload.FileType {
+		log.Warnf("Direct upload %s does not match: %d bytes of %s", id, info.Size, info.ContentType)
+		if err := p.Storage.DeleteObjects(ctx, []string{dataKey}); err != nil {
+			log.Error("Error removing mismatching upload: ", err)
+		}
+		respondJSONError(w, http.StatusBadRequest, "Uploaded file does not match the request")
+		return
+	}
+
+	pr := &pasteRequest{FileName: upload.FileName, FileType: upload.FileType, FileSize: upload.Size}
+	if result.DeleteKey, err = p.attachPasteMetadata(ctx, upload, pr); err != nil {
+		log.Error("Error completing direct upload: ", err)
+		respondJSONError(w, http.StatusInternalServerError, "Failed to complete upload")
+		return
+	}
+
+	if err := p.Storage.DeleteObjects(ctx, []string{dataKey, directUploadKey(id)}); err != nil {
+		log.Error("Error removing direct upload state: ", err)
+	}
+	p.uploadLocks.Delete(id)
+	log.Info("Completed direct upload with key: ", result.RawKey)
+
+	paste := p.createdPasteInfo(pr, result)
+	w.Header().Set("Location", p.apiURL(paste.ID))
+	respondJSON(w, http.StatusCreated, paste)
+}
+
+// attachPasteMetadata copies the uploaded file to the paste key with a new delete key and the paste metadata
+// and stores its page
+func (p *PasteHandler) attachPasteMetadata(ctx context.Context, upload *directUpload, pr *pasteRequest) (string, error) {
+	result := upload.Plan.Result
+
+	deleteKey, err := newDeleteKey()
+	if err != nil {
+		return "", err
+	}
+	deleteHash, err := hashDeleteKey(deleteKey)
+	if err != nil {
+		return "", err
+	}
+	upload.Plan.RawMetadata[deleteHashMetadataKey] = deleteHash
+	upload.Plan.HtmlMetadata[deleteHashMetadataKey] = deleteHash
+
+	html, _, err := p.renderFilePage(pr, pasteID(result.HtmlKey))
+	if err != nil {
+		return "", err
+	}
+	if err := copyObject(ctx, p.Storage, directUploadDataKey(upload.ID), result.RawKey, upload.FileType, upload.Plan.RawMetadata); err != nil {
+		return "", err
+	}
+	if err := p.Storage.UploadString(ctx, result.HtmlKey, html, contentTypeHTML, upload.Plan.HtmlMetadata); err != nil {
+		p.rollbackPaste(result)
+		return "", err
+	}
+	return deleteKey, nil
+}
+
+// copyObject copies an object with new metadata, through makaroni if the storage cannot copy it itself
+func copyObject(ctx context.Context, storage Storage, srcKey, dstKey, contentType string, metadata map[string]string) error {
+	if copier, ok := storage.(CopyStorage); ok {
+		return copier.CopyObject(ctx, srcKey, dstKey, contentType, metadata)
+	}
+
+	reader, _, err := storage.GetObject(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return storage.UploadReader(ctx, dstKey, reader, contentType, metadata)
+}
+
+// loadDirectUpload reads a pending direct upload
+func loadDirectUpload(ctx context.Context, storage Storage, id string) (*directUpload, error) {
+	reader, _, err := storage.GetObject(ctx, directUploadKey(id))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	upload := &directUpload{}
+	if err := json.NewDecoder(reader).Decode(upload); err != nil {
+		return nil, fmt.Errorf("decode direct upload %s: %w", id, err)
+	}
+	if upload.Plan == nil || upload.Plan.Result == nil {
+		return nil, fmt.Errorf("direct upload %s has no paste", id)
+	}
+	return upload, nil
+}
+
+// saveDirectUpload writes a pending direct upload
+func saveDirectUpload(ctx context.Context, storage Storage, upload *directUpload) error {
+	state, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	if err := storage.UploadString(ctx, directUploadKey(upload.ID), string(state), contentTypeJSON, nil); err != nil {
+		return fmt.Errorf("save direct upload %s: %w", upload.ID, err)
+	}
+	return nil
+}
+
+// sweepDirectUploads removes direct uploads that were never completed, along with the file uploaded for them,
+// returning the IDs of those removed
+func sweepDirectUploads(ctx context.Context, storage Storage, now time.Time) ([]string, error) {
+	var expired []*directUpload
+	err := storage.ListObjects(ctx, directUploadKeyPrefix, func(info *ObjectInfo) error {
+		id := strings.TrimPrefix(info.Key, directUploadKeyPrefix)
+		if strings.Contains(id, "/") {
+			// An uploaded file, removed along with its upload
+			return nil
+		}
+		upload, err := loadDirectUpload(ctx, storage, id)
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !now.Before(upload.Expires) {
+			expired = append(expired, upload)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make([]string, 0, len(expired))
+	for _, upload := range expired {
+		log.Info("Removing abandoned direct upload: ", upload.ID)
+		if err := storage.DeleteObjects(ctx, []string{directUploadDataKey(upload.ID), directUploadKey(upload.ID)}); err != nil {
+			return removed, err
+		}
+		removed = append(removed, upload.ID)
+	}
+	return removed, nil
+}
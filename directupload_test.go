@@ -0,0 +1,221 @@
+package makaroni
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// presigningStorage is a memory storage pretending to issue presigned requests
+type presigningStorage struct {
+	*MemoryStorage
+}
+
+func (s presigningStorage) PresignGet(key string, expires time.Duration) (string, error) {
+	return "http://s3.test/bucket/" + key + "?signature=1", nil
+}
+
+func (s presigningStorage) PresignPut(key string, contentType string, size int64, expires time.Duration) (*PresignedRequest, error) {
+	return &PresignedRequest{Method: http.MethodPut, URL: "http://s3.test/bucket/" + key, Headers: map[string]string{"Content-Type": contentType}}, nil
+}
+
+func (s presigningStorage) PresignPost(key string, contentType string, size int64, expires time.Duration) (*PresignedRequest, error) {
+	return &PresignedRequest{Method: http.MethodPost, URL: "http://s3.test/bucket", Fields: map[string]string{"key": key}}, nil
+}
+
+// newDirectUploadHandler creates a test handler with direct uploads enabled
+func newDirectUploadHandler(t *testing.T) (*PasteHandler, *MemoryStorage) {
+	t.Helper()
+
+	handler, storage := newTestHandler(t)
+	handler.Storage = presigningStorage{storage}
+	handler.Config.DirectUploads = true
+	return handler, storage
+}
+
+// requestDirectUpload asks for a presigned upload and decodes the response
+func requestDirectUpload(t *testing.T, handler http.Handler, body string) DirectUpload {
+	t.Helper()
+
+	resp := serve(handler, httptest.NewRequest(http.MethodPost, apiDirectUploadsPath, strings.NewReader(body)))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	var upload DirectUpload
+	if err := json.NewDecoder(resp.Body).Decode(&upload); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return upload
+}
+
+// completeDirectUpload calls the completion endpoint of the upload
+func completeDirectUpload(handler http.Handler, upload DirectUpload) *http.Response {
+	return serve(handler, httptest.NewRequest(http.MethodPost, strings.TrimPrefix(upload.CompleteURL, "http://paste.test"), nil))
+}
+
+func TestDirectUpload(t *testing.T) {
+	handler, storage := newDirectUploadHandler(t)
+	ctx := context.Background()
+
+	upload := requestDirectUpload(t, handler, `{"filename":"report.pdf","contentType":"application/pdf","size":8,"method":"put","expire":"1d"}`)
+	if upload.Upload.Method != http.MethodPut || !strings.HasSuffix(upload.Upload.URL, directUploadDataKey(upload.ID)) {
+		t.Fatalf("unexpected presigned request %+v", upload.Upload)
+	}
+	rawKey := strings.TrimPrefix(upload.RawURL, testURLPrefix)
+	dataKey := directUploadDataKey(upload.ID)
+
+	if resp := completeDirectUpload(handler, upload); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409 before the file is uploaded, got %d", resp.StatusCode)
+	}
+
+	// The client uploads to storage itself, the file is not served before the paste is complete
+	if err := storage.UploadString(ctx, dataKey, "%PDF-1.4", "application/pdf", nil); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if resp := serve(handler, httptest.NewRequest(http.MethodGet, "/"+rawKey, nil)); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 before completion, got %d", resp.StatusCode)
+	}
+	if resp := serve(handler, httptest.NewRequest(http.MethodGet, "/"+dataKey, nil)); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected the uploaded file to stay internal, got %d", resp.StatusCode)
+	}
+	resp := completeDirectUpload(handler, upload)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 on completion, got %d", resp.StatusCode)
+	}
+	var paste PasteInfo
+	if err := json.NewDecoder(resp.Body).Decode(&paste); err != nil {
+		t.Fatalf("decode paste: %v", err)
+	}
+	if paste.URL != upload.URL || paste.FileName != "report.pdf" || paste.DeleteKey == "" || paste.Expire == nil {
+		t.Fatalf("unexpected paste %+v", paste)
+	}
+
+	raw, err := storage.HeadObject(ctx, rawKey)
+	if err != nil || !verifyDeleteKey(raw.Metadata, paste.DeleteKey) || raw.Metadata[htmlKeyMetadataKey] != paste.ID+".html" {
+		t.Fatalf("raw object lacks the paste metadata: %v %v", raw, err)
+	}
+	if html, _ := readObject(t, storage, paste.ID+".html"); !strings.Contains(html, "report.pdf") {
+		t.Fatal("file download page does not describe the file")
+	}
+	if content, _ := readObject(t, storage, rawKey); content != "%PDF-1.4" {
+		t.Fatalf("unexpected file content %q", content)
+	}
+	if _, err := storage.HeadObject(ctx, dataKey); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatal("uploaded file was not removed after completion")
+	}
+
+	if resp := completeDirectUpload(handler, upload); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for a completed upload, got %d", resp.StatusCode)
+	}
+}
+
+func TestDirectUploadRejects(t *testing.T) {
+	handler, storage := newDirectUploadHandler(t)
+	ctx := context.Background()
+
+	upload := requestDirectUpload(t, handler, `{"filename":"a.txt","contentType":"text/plain","size":5}`)
+	if upload.Upload.Method != http.MethodPost {
+		t.Fatalf("expected a POST upload by default, got %s", upload.Upload.Method)
+	}
+	dataKey := directUploadDataKey(upload.ID)
+	if err := storage.UploadString(ctx, dataKey, "too long", "text/plain", nil); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if resp := completeDirectUpload(handler, upload); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a mismatching file, got %d", resp.StatusCode)
+	}
+	if _, err := storage.HeadObject(ctx, dataKey); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatal("mismatching file was not removed")
+	}
+
+	handler.Config.MaxUploadSize = 10
+	for body, status := range map[string]int{
+		`{"filename":"a.txt","size":11}`:                  http.StatusRequestEntityTooLarge,
+		`{"filename":"a.txt","size":0}`:                   http.StatusBadRequest,
+		`{"filename":"a.txt","size":1,"method":"DELETE"}`: http.StatusBadRequest,
+		`{"filename":"a.txt","size":1,"unknown":true}`:    http.StatusBadRequest,
+	} {
+		resp := serve(handler, httptest.NewRequest(http.MethodPost, apiDirectUploadsPath, strings.NewReader(body)))
+		if resp.StatusCode != status {
+			t.Errorf("%s: expected %d, got %d", body, status, resp.StatusCode)
+		}
+	}
+
+	handler.Config.DirectUploads = false
+	resp := serve(handler, httptest.NewRequest(http.MethodPost, apiDirectUploadsPath, strings.NewReader(`{"size":1}`)))
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 when direct uploads are disabled, got %d", resp.StatusCode)
+	}
+}
+
+func TestSweepDirectUploads(t *testing.T) {
+	handler, storage := newDirectUploadHandler(t)
+	ctx := context.Background()
+
+	upload := requestDirectUpload(t, handler, `{"filename":"a.bin","size":3}`)
+	dataKey := directUploadDataKey(upload.ID)
+	if err := storage.UploadString(ctx, dataKey, "abc", "application/octet-stream", nil); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	if removed, err := sweepDirectUploads(ctx, storage, time.Now()); err != nil || len(removed) != 0 {
+		t.Fatalf("pending upload was swept: %v, %v", removed, err)
+	}
+	if removed, err := sweepDirectUploads(ctx, storage, time.Now().Add(DefaultUploadExpire+2*DefaultPresignExpire)); err != nil || len(removed) != 1 || removed[0] != upload.ID {
+		t.Fatalf("expected the upload to be swept, got %v: %v", removed, err)
+	}
+	for _, key := range []string{dataKey, directUploadKey(upload.ID)} {
+		if _, err := storage.HeadObject(ctx, key); !errors.Is(err, ErrObjectNotFound) {
+			t.Fatalf("object %s of the abandoned upload was not removed", key)
+		}
+	}
+}
+
+func TestUploaderPresignPost(t *testing.T) {
+	uploader, err := NewUploader(UploaderConfig{
+		Endpoint:            "http://minio.test:9000",
+		PathStyleAddressing: true,
+		Region:              "eu-west-1",
+		Bucket:              "pastes",
+		KeyID:               "key",
+		Secret:              "secret",
+	})
+	if err != nil {
+		t.Fatalf("create uploader: %v", err)
+	}
+
+	presigned, err := uploader.PresignPost("abc.pdf", "application/pdf", 1024, time.Hour)
+	if err != nil {
+		t.Fatalf("presign: %v", err)
+	}
+	if presigned.URL != "http://minio.test:9000/pastes" || presigned.Fields["key"] != "abc.pdf" || presigned.Fields["x-amz-signature"] == "" {
+		t.Fatalf("unexpected presigned POST %+v", presigned)
+	}
+	if !strings.HasPrefix(presigned.Fields["x-amz-credential"], "key/") || !strings.HasSuffix(presigned.Fields["x-amz-credential"], "/eu-west-1/s3/aws4_request") {
+		t.Fatalf("unexpected credential scope %q", presigned.Fields["x-amz-credential"])
+	}
+
+	policy, err := base64.StdEncoding.DecodeString(presigned.Fields["policy"])
+	if err != nil {
+		t.Fatalf("decode policy: %v", err)
+	}
+	for _, condition := range []string{`["content-length-range",1024,1024]`, `{"Content-Type":"application/pdf"}`, `{"bucket":"pastes"}`} {
+		if !strings.Contains(string(policy), condition) {
+			t.Errorf("policy %s lacks %s", policy, condition)
+		}
+	}
+
+	put, err := uploader.PresignPut("abc.pdf", "application/pdf", 1024, time.Hour)
+	if err != nil {
+		t.Fatalf("presign put: %v", err)
+	}
+	if put.Headers["Content-Type"] != "application/pdf" || !strings.Contains(put.URL, "content-length") {
+		t.Fatalf("content length and type are not signed: %+v", put)
+	}
+}
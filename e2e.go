@@ -0,0 +1,88 @@
+package makaroni
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// encryptedMetadataKey marks the objects of an end-to-end encrypted paste with the envelope version
+	encryptedMetadataKey  = "e2e"
+	encryptedPasteVersion = 1
+	e2eKeySize            = 32
+	e2eNonceSize          = 12
+)
+
+// ErrInvalidEncryptedPaste is returned when the content of an encrypted paste is not a valid envelope
+var ErrInvalidEncryptedPaste = errors.New("invalid encrypted paste")
+
+// EncryptedPaste is the stored content of an end-to-end encrypted paste. The text is sealed with AES-256-GCM
+// by the client; the key is only ever part of the URL fragment, which browsers do not send to the server.
+type EncryptedPaste struct {
+	Version    int    `json:"v"`
+	IV         []byte `json:"iv"` // Base64 in JSON
+	Ciphertext []byte `json:"ct"` // Base64 in JSON, the GCM tag is appended
+}
+
+// parseEncryptedPaste validates an envelope submitted by a client and returns it re-encoded,
+// so the stored content never carries anything but the expected fields
+func parseEncryptedPaste(content string) (*EncryptedPaste, string, error) {
+	var paste EncryptedPaste
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&paste); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidEncryptedPaste, err)
+	}
+	if paste.Version != encryptedPasteVersion {
+		return nil, "", fmt.Errorf("%w: unsupported version %d", ErrInvalidEncryptedPaste, paste.Version)
+	}
+	if len(paste.IV) != e2eNonceSize || len(paste.Ciphertext) < aes.BlockSize {
+		return nil, "", fmt.Errorf("%w: malformed iv or ciphertext", ErrInvalidEncryptedPaste)
+	}
+
+	encoded, err := json.Marshal(&paste)
+	if err != nil {
+		return nil, "", err
+	}
+	return &paste, string(encoded), nil
+}
+
+// SealPaste encrypts text for an end-to-end encrypted paste. It returns the envelope to submit
+// as the paste content and the key to append to the paste URL as its fragment.
+func SealPaste(content string) (string, string, error) {
+	key := make([]byte, e2eKeySize)
+	iv := make([]byte, e2eNonceSize)
+	if _, err := rand.Read(key); err != nil {
+		return "", "", fmt.Errorf("generate key: %w", err)
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return "", "", fmt.Errorf("generate iv: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", "", err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", "", err
+	}
+
+	paste := EncryptedPaste{Version: encryptedPasteVersion, IV: iv, Ciphertext: aead.Seal(nil, iv, []byte(content), nil)}
+	encoded, err := json.Marshal(&paste)
+	if err != nil {
+		return "", "", err
+	}
+	return string(encoded), base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// isEncrypted reports whether the client asked for an end-to-end encrypted paste
+func (r *pasteRequest) isEncrypted() bool {
+	return r.Encrypted != "" && r.Encrypted != "0" && r.Encrypted != "false"
+}
@@ -0,0 +1,109 @@
+package makaroni
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// openPaste decrypts an envelope the way the paste page does in the browser
+func openPaste(t *testing.T, envelope, key string) string {
+	t.Helper()
+
+	var paste EncryptedPaste
+	if err := json.Unmarshal([]byte(envelope), &paste); err != nil {
+		t.Fatalf("decode envelope: %v", err)
+	}
+	rawKey, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil {
+		t.Fatalf("decode key: %v", err)
+	}
+	block, err := aes.NewCipher(rawKey)
+	if err != nil {
+		t.Fatalf("create cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("create gcm: %v", err)
+	}
+	plain, err := aead.Open(nil, paste.IV, paste.Ciphertext, nil)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	return string(plain)
+}
+
+func TestEncryptedPaste(t *testing.T) {
+	handler, storage := newTestHandler(t)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	ctx := context.Background()
+
+	paste, err := client.CreatePaste(ctx, strings.NewReader("password=hunter2\n"), PasteOptions{Syntax: "ini", Encrypt: true})
+	if err != nil {
+		t.Fatalf("create paste: %v", err)
+	}
+	pasteURL, key, ok := strings.Cut(paste.URL, "#")
+	if !ok || pasteURL != testURLPrefix+paste.ID+".html" || !paste.Encrypted || paste.ContentType != contentTypeJSON {
+		t.Fatalf("unexpected paste %+v", paste)
+	}
+
+	envelope, info := readObject(t, storage, paste.ID)
+	if strings.Contains(envelope, "hunter2") || info.ContentType != contentTypeJSON || info.Metadata[encryptedMetadataKey] != "1" {
+		t.Fatalf("unexpected stored paste %q %+v", envelope, info)
+	}
+	if text := openPaste(t, envelope, key); text != "password=hunter2\n" {
+		t.Fatalf("unexpected decrypted content %q", text)
+	}
+
+	html, _ := readObject(t, storage, paste.ID+".html")
+	if strings.Contains(html, "hunter2") || !strings.Contains(html, `"ct":`) || !strings.Contains(html, `"syntax":"ini"`) {
+		t.Fatal("page does not carry the ciphertext for the browser")
+	}
+
+	if info, err := client.GetPaste(ctx, paste.ID); err != nil || !info.Encrypted {
+		t.Fatalf("metadata does not report the encryption: %+v %v", info, err)
+	}
+}
+
+func TestEncryptedPasteRejects(t *testing.T) {
+	handler, storage := newTestHandler(t)
+
+	envelope, _, err := SealPaste("text")
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	for _, content := range []string{
+		"plain text",
+		strings.Replace(envelope, `"v":1`, `"v":2`, 1),
+		strings.Replace(envelope, `"v":1`, `"v":1,"key":"leak"`, 1),
+		`{"v":1,"iv":"AAAA","ct":"AAAAAAAAAAAAAAAAAAAAAA=="}`,
+	} {
+		body, _ := json.Marshal(apiCreateRequest{Content: content, Encrypted: true})
+		req := httptest.NewRequest(http.MethodPost, apiPastesPath, strings.NewReader(string(body)))
+		if message := decodeAPIError(t, serve(handler, req), http.StatusBadRequest); message != "Invalid encrypted paste" {
+			t.Fatalf("%s: unexpected error %q", content, message)
+		}
+	}
+
+	file := &testFile{field: "file", name: "a.txt", contentType: "text/plain", content: envelope}
+	resp := serve(handler, newMultipartRequest(t, map[string]string{"encrypted": "true"}, file))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an encrypted file, got %d", resp.StatusCode)
+	}
+
+	// The syntax ends up in the page's script data and must not be able to close it
+	body, _ := json.Marshal(apiCreateRequest{Content: envelope, Encrypted: true, Syntax: "</script><script>alert(1)//"})
+	paste := createAPIPaste(t, handler, string(body))
+	if html, _ := readObject(t, storage, paste.ID+".html"); strings.Contains(html, "<script>alert") {
+		t.Fatal("syntax is not escaped in the page")
+	}
+}
@@ -0,0 +1,504 @@
+package makaroni
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	dataKeyMetadataKey   = "enc-key"    // Data key of the object, wrapped with a master key
+	masterKeyMetadataKey = "enc-key-id" // ID of the master key wrapping the data key
+
+	encryptionChunkSize = 64 << 10 // Plaintext bytes sealed together, so ranges can be decrypted without the whole object
+	encryptionTagSize   = 16
+	dataKeySize         = 32
+)
+
+var (
+	// ErrUnknownMasterKey is returned when an object is encrypted with a master key that is not configured
+	ErrUnknownMasterKey = errors.New("unknown master key")
+	// ErrDecrypt is returned when stored content or a data key fails authentication
+	ErrDecrypt = errors.New("decryption failed")
+)
+
+// MasterKey wraps the data keys of encrypted objects
+type MasterKey struct {
+	ID  string
+	Key []byte
+}
+
+// ParseMasterKeys parses a comma-separated list of "id:base64-key" entries holding 32-byte keys.
+// The first key encrypts new objects, the others are only used to read objects encrypted before a rotation.
+func ParseMasterKeys(value string) ([]MasterKey, error) {
+	var keys []MasterKey
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("master key %q is not in the id:base64-key form", entry)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate master key id %q", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("master key %q: %w", id, err)
+		}
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("master key %q must be %d bytes, got %d", id, dataKeySize, len(key))
+		}
+		seen[id] = true
+		keys = append(keys, MasterKey{ID: id, Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no master key given")
+	}
+	return keys, nil
+}
+
+// EncryptedStorage encrypts objects before they reach the underlying storage. Every object gets a random
+// data key, its content is sealed with AES-GCM in chunks and the data key, wrapped with the current master key,
+// is kept in the object metadata. Objects stored before encryption was enabled are read as they are.
+//
+// EncryptedStorage implements neither MultipartStorage nor PresignStorage: the storage must never hand out
+// ciphertext, so pastes have to be served by makaroni.
+type EncryptedStorage struct {
+	storage Storage
+	masters map[string]cipher.AEAD
+	current string
+}
+
+var _ Storage = (*EncryptedStorage)(nil)
+
+// NewEncryptedStorage wraps storage, the first master key encrypts new objects
+func NewEncryptedStorage(storage Storage, keys []MasterKey) (*EncryptedStorage, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no master key given")
+	}
+	e := &EncryptedStorage{storage: storage, masters: make(map[string]cipher.AEAD, len(keys)), current: keys[0].ID}
+	for _, key := range keys {
+		aead, err := newGCM(key.Key)
+		if err != nil {
+			return nil, fmt.Errorf("master key %q: %w", key.ID, err)
+		}
+		e.masters[key.ID] = aead
+	}
+	return e, nil
+}
+
+// UploadString encrypts and stores string content under the key
+func (e *EncryptedStorage) UploadString(ctx context.Context, key string, content string, contentType string, metadata map[string]string) error {
+	return e.UploadReader(ctx, key, strings.NewReader(content), contentType, metadata)
+}
+
+// UploadReader encrypts data read from reader with a new data key and stores it under the key
+func (e *EncryptedStorage) UploadReader(ctx context.Context, key string, reader io.Reader, contentType string, metadata map[string]string) error {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return fmt.Errorf("generate data key: %w", err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+	wrapped, err := e.wrapKey(key, dataKey)
+	if err != nil {
+		return err
+	}
+
+	stored := copyMetadata(metadata)
+	stored[dataKeyMetadataKey] = wrapped
+	stored[masterKeyMetadataKey] = e.current
+	return e.storage.UploadReader(ctx, key, &encryptingReader{aead: aead, source: reader}, contentType, stored)
+}
+
+// HeadObject returns object information with the plaintext size and without the encryption metadata
+func (e *EncryptedStorage) HeadObject(ctx context.Context, key string) (*ObjectInfo, error) {
+	info, err := e.storage.HeadObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return plaintextInfo(info), nil
+}
+
+// GetMetadata returns the user metadata of an object without the encryption metadata
+func (e *EncryptedStorage) GetMetadata(ctx context.Context, key string) (map[string]string, error) {
+	metadata, err := e.storage.GetMetadata(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return userMetadata(metadata), nil
+}
+
+// UpdateMetadata replaces the user metadata of an object, keeping its wrapped data key
+func (e *EncryptedStorage) UpdateMetadata(ctx context.Context, key string, metadata map[string]string) error {
+	current, err := e.storage.GetMetadata(ctx, key)
+	if err != nil {
+		return err
+	}
+	stored := userMetadata(metadata)
+	if current[dataKeyMetadataKey] != "" {
+		stored[dataKeyMetadataKey] = current[dataKeyMetadataKey]
+		stored[masterKeyMetadataKey] = current[masterKeyMetadataKey]
+	}
+	return e.storage.UpdateMetadata(ctx, key, stored)
+}
+
+// GetObject returns the decrypted object content, the caller must close the reader
+func (e *EncryptedStorage) GetObject(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	body, info, err := e.storage.GetObject(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isEncrypted(info.Metadata) {
+		return body, info, nil
+	}
+
+	aead, err := e.dataKey(key, info.Metadata)
+	if err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+	reader := &decryptingReader{aead: aead, source: body, last: encryptedChunks(info.Size) - 1}
+	return limitedReadCloser{Reader: reader, Closer: body}, plaintextInfo(info), nil
+}
+
+// GetObjectRange returns length bytes of the decrypted object starting at offset. Only the chunks
+// covering the range are read from the underlying storage.
+func (e *EncryptedStorage) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	info, err := e.storage.HeadObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !isEncrypted(info.Metadata) {
+		return e.storage.GetObjectRange(ctx, key, offset, length)
+	}
+
+	aead, err := e.dataKey(key, info.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	size := plaintextSize(info.Size)
+	if offset > size {
+		offset = size
+	}
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	first := offset / encryptionChunkSize
+	last := (offset + length - 1) / encryptionChunkSize
+	sealedChunk := int64(encryptionChunkSize + encryptionTagSize)
+	start := first * sealedChunk
+	end := (last + 1) * sealedChunk
+	if end > info.Size {
+		end = info.Size
+	}
+	body, err := e.storage.GetObjectRange(ctx, key, start, end-start)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &decryptingReader{aead: aead, source: body, chunk: first, last: encryptedChunks(info.Size) - 1}
+	if _, err := io.CopyN(io.Discard, reader, offset-first*encryptionChunkSize); err != nil {
+		body.Close()
+		return nil, err
+	}
+	return limitedReadCloser{Reader: io.LimitReader(reader, length), Closer: body}, nil
+}
+
+// DeleteObjects removes multiple objects, missing keys are ignored
+func (e *EncryptedStorage) DeleteObjects(ctx context.Context, keys []string) error {
+	return e.storage.DeleteObjects(ctx, keys)
+}
+
+// ListObjects calls fn for every object whose key starts with prefix, sizes are the stored sizes
+func (e *EncryptedStorage) ListObjects(ctx context.Context, prefix string, fn ListFunc) error {
+	return e.storage.ListObjects(ctx, prefix, fn)
+}
+
+// Rekey wraps the data keys of objects encrypted with an older master key with the current one.
+// The content is not rewritten, so the old master key can be dropped afterwards. fn is called for
+// every object that needs rekeying, with dryRun nothing is changed. Returns the number of such objects.
+// An object that cannot be rekeyed, such as one too large for S3 to copy onto itself, does not stop
+// the others; the failures are returned together at the end.
+func (e *EncryptedStorage) Rekey(ctx context.Context, dryRun bool, fn func(key, masterKeyID string)) (int, error) {
+	var keys []string
+	err := e.storage.ListObjects(ctx, "", func(info *ObjectInfo) error {
+		keys = append(keys, info.Key)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("list objects: %w", err)
+	}
+
+	rekeyed := 0
+	var failures []error
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return rekeyed, err
+		}
+		metadata, err := e.storage.GetMetadata(ctx, key)
+		if errors.Is(err, ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			failures = append(failures, fmt.Errorf("get metadata of %s: %w", key, err))
+			continue
+		}
+		masterKeyID := metadata[masterKeyMetadataKey]
+		if !isEncrypted(metadata) || masterKeyID == e.current {
+			continue
+		}
+
+		if fn != nil {
+			fn(key, masterKeyID)
+		}
+		if dryRun {
+			rekeyed++
+			continue
+		}
+
+		dataKey, err := e.unwrapKey(key, metadata)
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+		wrapped, err := e.wrapKey(key, dataKey)
+		if err != nil {
+			return rekeyed, err
+		}
+		metadata[dataKeyMetadataKey] = wrapped
+		metadata[masterKeyMetadataKey] = e.current
+		if err := e.storage.UpdateMetadata(ctx, key, metadata); err != nil && !errors.Is(err, ErrObjectNotFound) {
+			failures = append(failures, fmt.Errorf("update metadata of %s: %w", key, err))
+			continue
+		}
+		rekeyed++
+	}
+	if len(failures) > 0 {
+		return rekeyed, fmt.Errorf("%d objects were not rekeyed: %w", len(failures), errors.Join(failures...))
+	}
+	return rekeyed, nil
+}
+
+// wrapKey encrypts a data key with the current master key, bound to the object key
+func (e *EncryptedStorage) wrapKey(key string, dataKey []byte) (string, error) {
+	master := e.masters[e.current]
+	nonce := make([]byte, master.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(master.Seal(nonce, nonce, dataKey, []byte(key))), nil
+}
+
+// unwrapKey decrypts the data key of an object
+func (e *EncryptedStorage) unwrapKey(key string, metadata map[string]string) ([]byte, error) {
+	masterKeyID := metadata[masterKeyMetadataKey]
+	master, ok := e.masters[masterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w %q", key, ErrUnknownMasterKey, masterKeyID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(metadata[dataKeyMetadataKey])
+	if err != nil || len(sealed) < master.NonceSize() {
+		return nil, fmt.Errorf("%s: malformed data key: %w", key, ErrDecrypt)
+	}
+	dataKey, err := master.Open(nil, sealed[:master.NonceSize()], sealed[master.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("%s: data key: %w", key, ErrDecrypt)
+	}
+	return dataKey, nil
+}
+
+// dataKey returns the cipher of an object's content
+func (e *EncryptedStorage) dataKey(key string, metadata map[string]string) (cipher.AEAD, error) {
+	dataKey, err := e.unwrapKey(key, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(dataKey)
+}
+
+// newGCM creates an AES-GCM cipher
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// isEncrypted reports whether stored metadata describes an encrypted object
+func isEncrypted(metadata map[string]string) bool {
+	return metadata[dataKeyMetadataKey] != ""
+}
+
+// userMetadata returns a copy of the metadata without the encryption entries
+func userMetadata(metadata map[string]string) map[string]string {
+	result := copyMetadata(metadata)
+	delete(result, dataKeyMetadataKey)
+	delete(result, masterKeyMetadataKey)
+	return result
+}
+
+// plaintextInfo describes a stored object as seen through the encryption
+func plaintextInfo(info *ObjectInfo) *ObjectInfo {
+	if !isEncrypted(info.Metadata) {
+		return info
+	}
+	result := *info
+	result.Size = plaintextSize(info.Size)
+	result.Metadata = userMetadata(info.Metadata)
+	return &result
+}
+
+// encryptedChunks returns the number of sealed chunks in an object of the stored size, there is at least one
+func encryptedChunks(size int64) int64 {
+	sealedChunk := int64(encryptionChunkSize + encryptionTagSize)
+	if size <= sealedChunk {
+		return 1
+	}
+	return (size + sealedChunk - 1) / sealedChunk
+}
+
+// plaintextSize returns the content size of an encrypted object of the stored size
+func plaintextSize(size int64) int64 {
+	if size := size - encryptedChunks(size)*encryptionTagSize; size > 0 {
+		return size
+	}
+	return 0
+}
+
+// chunkNonce returns the nonce of a chunk. Data keys are never reused, so the chunk index is enough;
+// the final chunk is marked so a truncated object fails to decrypt.
+func chunkNonce(chunk int64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, uint64(chunk))
+	if final {
+		nonce[8] = 1
+	}
+	return nonce
+}
+
+// encryptingReader seals the data read from source chunk by chunk
+type encryptingReader struct {
+	aead    cipher.AEAD
+	source  io.Reader
+	chunk   int64
+	next    []byte // Plaintext read ahead, an empty one marks the previous chunk as the final one
+	started bool
+	done    bool
+	sealed  []byte
+	out     []byte // Sealed bytes not returned yet
+}
+
+func (r *encryptingReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.sealChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// sealChunk seals the next chunk, reading one more ahead to know whether it is the final one
+func (r *encryptingReader) sealChunk() error {
+	if !r.started {
+		first, err := readChunk(r.source)
+		if err != nil {
+			return err
+		}
+		r.next, r.started = first, true
+	}
+	following, err := readChunk(r.source)
+	if err != nil {
+		return err
+	}
+
+	final := len(following) == 0
+	r.sealed = r.aead.Seal(r.sealed[:0], chunkNonce(r.chunk, final), r.next, nil)
+	r.out = r.sealed
+	r.chunk++
+	r.next, r.done = following, final
+	return nil
+}
+
+// readChunk reads up to a chunk of plaintext, it is empty at the end of the source
+func readChunk(source io.Reader) ([]byte, error) {
+	buf := make([]byte, encryptionChunkSize)
+	n, err := io.ReadFull(source, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return buf[:n], nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// decryptingReader opens the sealed chunks read from source, starting with chunk
+type decryptingReader struct {
+	aead   cipher.AEAD
+	source io.Reader
+	chunk  int64 // Index of the next chunk
+	last   int64 // Index of the final chunk of the object
+	buf    []byte
+	out    []byte // Plaintext not returned yet
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.chunk > r.last {
+			return 0, io.EOF
+		}
+		if err := r.openChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// openChunk reads and authenticates the next chunk
+func (r *decryptingReader) openChunk() error {
+	if r.buf == nil {
+		r.buf = make([]byte, encryptionChunkSize+encryptionTagSize)
+	}
+	n, err := io.ReadFull(r.source, r.buf)
+	if err != nil && !(errors.Is(err, io.ErrUnexpectedEOF) && r.chunk == r.last) {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("read chunk %d: %w", r.chunk, err)
+	}
+
+	plain, err := r.aead.Open(r.buf[:0], chunkNonce(r.chunk, r.chunk == r.last), r.buf[:n], nil)
+	if err != nil {
+		return fmt.Errorf("chunk %d: %w", r.chunk, ErrDecrypt)
+	}
+	r.out = plain
+	r.chunk++
+	return nil
+}
@@ -0,0 +1,246 @@
+package makaroni
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testMasterKey derives a master key from its ID
+func testMasterKey(id string) MasterKey {
+	key := sha256.Sum256([]byte(id))
+	return MasterKey{ID: id, Key: key[:]}
+}
+
+// newEncryptedStorage wraps a memory storage, the first ID is the current master key
+func newEncryptedStorage(t *testing.T, backend Storage, ids ...string) *EncryptedStorage {
+	t.Helper()
+
+	var keys []MasterKey
+	for _, id := range ids {
+		keys = append(keys, testMasterKey(id))
+	}
+	storage, err := NewEncryptedStorage(backend, keys)
+	if err != nil {
+		t.Fatalf("create encrypted storage: %v", err)
+	}
+	return storage
+}
+
+func TestEncryptedStorageRanges(t *testing.T) {
+	backend := NewMemoryStorage()
+	storage := newEncryptedStorage(t, backend, "k1")
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 3*encryptionChunkSize/16+100)
+	if err := storage.UploadReader(ctx, "big.bin", bytes.NewReader(content), "application/octet-stream", nil); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	stored, err := backend.HeadObject(ctx, "big.bin")
+	if err != nil || stored.Size != int64(len(content))+4*encryptionTagSize || !isEncrypted(stored.Metadata) {
+		t.Fatalf("unexpected stored object %+v: %v", stored, err)
+	}
+	raw, _ := readObject(t, backend, "big.bin")
+	if strings.Contains(raw, "0123456789abcdef") {
+		t.Fatal("content is stored in plaintext")
+	}
+
+	info, err := storage.HeadObject(ctx, "big.bin")
+	if err != nil || info.Size != int64(len(content)) || len(info.Metadata) != 0 {
+		t.Fatalf("unexpected object info %+v: %v", info, err)
+	}
+
+	for _, r := range [][2]int64{{0, -1}, {5, 10}, {encryptionChunkSize - 3, 6}, {encryptionChunkSize, encryptionChunkSize}, {int64(len(content)) - 7, -1}, {int64(len(content)), 5}} {
+		reader, err := storage.GetObjectRange(ctx, "big.bin", r[0], r[1])
+		if err != nil {
+			t.Fatalf("range %v: %v", r, err)
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		end := int64(len(content))
+		if r[1] >= 0 && r[0]+r[1] < end {
+			end = r[0] + r[1]
+		}
+		if err != nil || !bytes.Equal(data, content[r[0]:end]) {
+			t.Fatalf("range %v: unexpected content of %d bytes: %v", r, len(data), err)
+		}
+	}
+
+	if err := storage.UploadString(ctx, "empty", "", contentTypeText, nil); err != nil {
+		t.Fatalf("upload empty: %v", err)
+	}
+	if data, info := readObject(t, storage, "empty"); data != "" || info.Size != 0 {
+		t.Fatalf("unexpected empty object %q, %+v", data, info)
+	}
+}
+
+func TestEncryptedStorageDetectsTampering(t *testing.T) {
+	backend := NewMemoryStorage()
+	storage := newEncryptedStorage(t, backend, "k1")
+	ctx := context.Background()
+
+	content := strings.Repeat("secret log line\n", encryptionChunkSize/8)
+	if err := storage.UploadString(ctx, "abc", content, contentTypeText, map[string]string{"views": "1"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	info, _ := backend.HeadObject(ctx, "abc")
+	stored, _ := readObject(t, backend, "abc")
+
+	for name, tampered := range map[string]string{
+		"flipped":   stored[:10] + string(stored[10]^1) + stored[11:],
+		"truncated": stored[:encryptionChunkSize+encryptionTagSize],
+	} {
+		if err := backend.UploadString(ctx, "abc", tampered, contentTypeText, info.Metadata); err != nil {
+			t.Fatalf("%s: upload: %v", name, err)
+		}
+		reader, _, err := storage.GetObject(ctx, "abc")
+		if err != nil {
+			t.Fatalf("%s: get: %v", name, err)
+		}
+		_, err = io.ReadAll(reader)
+		reader.Close()
+		if !errors.Is(err, ErrDecrypt) {
+			t.Fatalf("%s: expected ErrDecrypt, got %v", name, err)
+		}
+	}
+
+	// A data key only opens the object it was generated for
+	if err := backend.UploadString(ctx, "other", stored, contentTypeText, info.Metadata); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if _, _, err := storage.GetObject(ctx, "other"); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("expected ErrDecrypt for a moved object, got %v", err)
+	}
+
+	if _, _, err := newEncryptedStorage(t, backend, "k2").GetObject(ctx, "abc"); !errors.Is(err, ErrUnknownMasterKey) {
+		t.Fatalf("expected ErrUnknownMasterKey, got %v", err)
+	}
+}
+
+func TestEncryptedStorageMetadata(t *testing.T) {
+	backend := NewMemoryStorage()
+	storage := newEncryptedStorage(t, backend, "k1")
+	ctx := context.Background()
+
+	if err := backend.UploadString(ctx, "legacy", "plain", contentTypeText, map[string]string{"views": "0"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if data, _ := readObject(t, storage, "legacy"); data != "plain" {
+		t.Fatalf("unencrypted object is not readable: %q", data)
+	}
+
+	if err := storage.UploadString(ctx, "abc", "content", contentTypeText, map[string]string{"views": "0"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if err := storage.UpdateMetadata(ctx, "abc", map[string]string{"views": "1"}); err != nil {
+		t.Fatalf("update metadata: %v", err)
+	}
+	metadata, err := storage.GetMetadata(ctx, "abc")
+	if err != nil || len(metadata) != 1 || metadata["views"] != "1" {
+		t.Fatalf("unexpected metadata %v: %v", metadata, err)
+	}
+	if data, _ := readObject(t, storage, "abc"); data != "content" {
+		t.Fatalf("object is not readable after a metadata update: %q", data)
+	}
+}
+
+func TestEncryptedStorageRekey(t *testing.T) {
+	backend := NewMemoryStorage()
+	ctx := context.Background()
+
+	old := newEncryptedStorage(t, backend, "old")
+	for _, key := range []string{"a", "a.html"} {
+		if err := old.UploadString(ctx, key, "content of "+key, contentTypeText, map[string]string{"expire": "never"}); err != nil {
+			t.Fatalf("upload: %v", err)
+		}
+	}
+	rotated := newEncryptedStorage(t, backend, "new", "old")
+	if err := rotated.UploadString(ctx, "b", "content of b", contentTypeText, nil); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	var listed []string
+	count, err := rotated.Rekey(ctx, true, func(key, masterKeyID string) { listed = append(listed, key+":"+masterKeyID) })
+	if err != nil || count != 2 || strings.Join(listed, ",") != "a:old,a.html:old" {
+		t.Fatalf("unexpected dry run %v, %d: %v", listed, count, err)
+	}
+	if count, err := rotated.Rekey(ctx, false, nil); err != nil || count != 2 {
+		t.Fatalf("expected 2 rekeyed objects, got %d: %v", count, err)
+	}
+
+	current := newEncryptedStorage(t, backend, "new")
+	for _, key := range []string{"a", "a.html", "b"} {
+		if data, info := readObject(t, current, key); data != "content of "+key || (key != "b" && info.Metadata["expire"] != "never") {
+			t.Fatalf("%s not readable with the new key: %q %v", key, data, info.Metadata)
+		}
+	}
+	if count, err := current.Rekey(ctx, false, nil); err != nil || count != 0 {
+		t.Fatalf("expected nothing left to rekey, got %d: %v", count, err)
+	}
+}
+
+func TestEncryptedStorageRekeyContinuesPastFailures(t *testing.T) {
+	backend := NewMemoryStorage()
+	ctx := context.Background()
+
+	old := newEncryptedStorage(t, backend, "old")
+	for _, key := range []string{"a", "big", "c"} {
+		if err := old.UploadString(ctx, key, "content of "+key, contentTypeText, nil); err != nil {
+			t.Fatalf("upload: %v", err)
+		}
+	}
+
+	failing := &failingStorage{MemoryStorage: backend, fail: func(key string) bool { return key == "big" }}
+	rotated := newEncryptedStorage(t, failing, "new", "old")
+	count, err := rotated.Rekey(ctx, false, nil)
+	if count != 2 || err == nil || !strings.Contains(err.Error(), "update metadata of big") {
+		t.Fatalf("expected 2 rekeyed objects and the failure of big, got %d: %v", count, err)
+	}
+
+	current := newEncryptedStorage(t, backend, "new")
+	for _, key := range []string{"a", "c"} {
+		if data, _ := readObject(t, current, key); data != "content of "+key {
+			t.Fatalf("%s not readable with the new key: %q", key, data)
+		}
+	}
+}
+
+func TestParseMasterKeys(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(testMasterKey("x").Key)
+
+	keys, err := ParseMasterKeys("new:" + key + ", old:" + key)
+	if err != nil || len(keys) != 2 || keys[0].ID != "new" || keys[1].ID != "old" {
+		t.Fatalf("unexpected keys %v: %v", keys, err)
+	}
+	for _, value := range []string{"", key, "a:" + key + ",a:" + key, "a:short", "a:not base64"} {
+		if _, err := ParseMasterKeys(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestServeEncryptedPaste(t *testing.T) {
+	handler, backend := newTestHandler(t)
+	handler.Storage = newEncryptedStorage(t, backend, "k1")
+
+	object := pasteCookie(t, serve(handler, newMultipartRequest(t, map[string]string{"content": "customer=alice\n", "syntax": "plain"}, nil)))
+	if stored, _ := readObject(t, backend, object.RawKey); strings.Contains(stored, "alice") {
+		t.Fatal("paste is stored in plaintext")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/"+object.RawKey, nil)
+	req.Header.Set("Range", "bytes=9-13")
+	resp := serve(handler, req)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "alice" {
+		t.Fatalf("unexpected range response %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,189 @@
+package makaroni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	expireMetadataKey = "expire"
+	expireNever       = "never"
+	sweepBatchSize    = 1000 // S3 DeleteObjects accepts up to 1000 keys per request
+
+	// maxExpire bounds expiration periods, keeping expiry times far from the time.Duration limit
+	maxExpire = 100 * 365 * 24 * time.Hour
+)
+
+// expireUnits are the units an expiration period may use
+var expireUnits = map[byte]time.Duration{
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+var (
+	ErrInvalidExpire = errors.New("invalid expiration")
+)
+
+// ParseExpire parses an expiration period: a count of minutes, hours, days or weeks like "10m", "1h", "1d"
+// or "1w", up to about 100 years. An empty value or "never" returns zero, meaning the paste never expires.
+func ParseExpire(value string) (time.Duration, error) {
+	value = strings.TrimSpace(strings.ToLower(value))
+	if value == "" || value == expireNever {
+		return 0, nil
+	}
+
+	unit, ok := expireUnits[value[len(value)-1]]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidExpire, value)
+	}
+	count, err := strconv.ParseInt(strings.TrimSpace(value[:len(value)-1]), 10, 64)
+	if err != nil || count <= 0 || count > int64(maxExpire/unit) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidExpire, value)
+	}
+	return time.Duration(count) * unit, nil
+}
+
+// expireTTL returns the paste lifetime for a requested expiration, applying the configured default and maximum
+func expireTTL(requested string, config *Config) (time.Duration, error) {
+	if requested == "" {
+		requested = config.DefaultExpire
+	}
+	ttl, err := ParseExpire(requested)
+	if err != nil {
+		return 0, err
+	}
+
+	maxTTL, err := ParseExpire(config.MaxExpire)
+	if err != nil {
+		return 0, err
+	}
+	if maxTTL > 0 && (ttl == 0 || ttl > maxTTL) {
+		log.Debugf("Requested expiration %q exceeds the maximum, using %s", requested, maxTTL)
+		ttl = maxTTL
+	}
+	return ttl, nil
+}
+
+// expireTime returns the expiration time stored in metadata, ok is false for pastes that never expire
+func expireTime(metadata map[string]string) (time.Time, bool) {
+	value, exists := metadata[expireMetadataKey]
+	if !exists || value == "" {
+		return time.Time{}, false
+	}
+	expire, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Warnf("Invalid expire metadata %q: %v", value, err)
+		return time.Time{}, false
+	}
+	return expire, true
+}
+
+// isExpired reports whether the metadata marks an object as expired at the given time
+func isExpired(metadata map[string]string, now time.Time) bool {
+	expire, ok := expireTime(metadata)
+	return ok && !now.Before(expire)
+}
+
+// Sweeper periodically removes abandoned uploads and tombstones of burned pastes from storage.
+// With Pastes set, as the server does by default, it removes expired pastes as well, at the cost of
+// reading the metadata of every stored object on each sweep.
+type Sweeper struct {
+	Storage  Storage
+	Interval time.Duration
+	Pastes   bool
+	// UploadRemoved, when set, is called with the ID of every upload the sweep removes
+	UploadRemoved func(id string)
+}
+
+// Run sweeps storage every Interval until the context is cancelled
+func (s *Sweeper) Run(ctx context.Context) {
+	log.Infof("Starting expired paste sweeper with interval %s, expired pastes removed: %t", s.Interval, s.Pastes)
+
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Sweep(ctx); err != nil && ctx.Err() == nil {
+			log.Error("Error sweeping expired pastes: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Info("Expired paste sweeper stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep deletes expired objects and abandoned uploads and returns how many were removed
+func (s *Sweeper) Sweep(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+	var expired []string
+	deleted := 0
+
+	flush := func() error {
+		if len(expired) == 0 {
+			return nil
+		}
+		if err := s.Storage.DeleteObjects(ctx, expired); err != nil {
+			return err
+		}
+		deleted += len(expired)
+		expired = expired[:0]
+		return nil
+	}
+
+	prefix := burnedKeyPrefix
+	if s.Pastes {
+		prefix = ""
+	}
+	err := s.Storage.ListObjects(ctx, prefix, func(info *ObjectInfo) error {
+		metadata, err := s.Storage.GetMetadata(ctx, info.Key)
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !isExpired(metadata, now) {
+			return nil
+		}
+
+		log.Debug("Found expired object: ", info.Key)
+		expired = append(expired, info.Key)
+		if len(expired) >= sweepBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	for _, sweepPending := range []func(context.Context, Storage, time.Time) ([]string, error){sweepUploads, sweepDirectUploads} {
+		if err != nil {
+			break
+		}
+		var removed []string
+		removed, err = sweepPending(ctx, s.Storage, now)
+		deleted += len(removed)
+		if s.UploadRemoved != nil {
+			for _, id := range removed {
+				s.UploadRemoved(id)
+			}
+		}
+	}
+
+	if deleted > 0 {
+		log.Infof("Deleted %d expired objects", deleted)
+	}
+	return deleted, err
+}
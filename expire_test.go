@@ -0,0 +1,128 @@
+package makaroni
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseExpire(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{value: "", expected: 0},
+		{value: "never", expected: 0},
+		{value: "10m", expected: 10 * time.Minute},
+		{value: "1h", expected: time.Hour},
+		{value: "1d", expected: 24 * time.Hour},
+		{value: "30d", expected: 30 * 24 * time.Hour},
+		{value: "1w", expected: 7 * 24 * time.Hour},
+		{value: "0d", wantErr: true},
+		{value: "-1h", wantErr: true},
+		{value: "soon", wantErr: true},
+		{value: "10ms", wantErr: true},
+		{value: "90s", wantErr: true},
+		{value: "1h30m", wantErr: true},
+		{value: "5214w", expected: 5214 * 7 * 24 * time.Hour},
+		{value: "5215w", wantErr: true},
+		{value: "99999999999w", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		duration, err := ParseExpire(tt.value)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidExpire) {
+				t.Errorf("ParseExpire(%q): expected ErrInvalidExpire, got %v", tt.value, err)
+			}
+			continue
+		}
+		if err != nil || duration != tt.expected {
+			t.Errorf("ParseExpire(%q) = %s, %v; want %s", tt.value, duration, err, tt.expected)
+		}
+	}
+}
+
+func TestExpireTTLAppliesMaximum(t *testing.T) {
+	config := &Config{DefaultExpire: "never", MaxExpire: "30d"}
+
+	for requested, expected := range map[string]time.Duration{
+		"":      30 * 24 * time.Hour,
+		"never": 30 * 24 * time.Hour,
+		"1w":    7 * 24 * time.Hour,
+		"60d":   30 * 24 * time.Hour,
+	} {
+		ttl, err := expireTTL(requested, config)
+		if err != nil || ttl != expected {
+			t.Errorf("expireTTL(%q) = %s, %v; want %s", requested, ttl, err, expected)
+		}
+	}
+}
+
+func TestPostPasteWithExpire(t *testing.T) {
+	handler, storage := newTestHandler(t)
+
+	resp := serve(handler, newMultipartRequest(t, map[string]string{"content": "log", "expire": "1h"}, nil))
+	object := pasteCookie(t, resp)
+
+	metadata, err := storage.GetMetadata(context.Background(), object.HtmlKey)
+	if err != nil {
+		t.Fatalf("get metadata: %v", err)
+	}
+	expire, ok := expireTime(metadata)
+	if !ok || expire.Before(time.Now().Add(59*time.Minute)) || expire.After(time.Now().Add(time.Hour)) {
+		t.Fatalf("unexpected expire metadata %q", metadata[expireMetadataKey])
+	}
+
+	resp = serve(handler, newMultipartRequest(t, map[string]string{"content": "log", "expire": "tomorrow"}, nil))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid expiration, got %d", resp.StatusCode)
+	}
+}
+
+func TestExpiredPasteIsNotServedAndSwept(t *testing.T) {
+	handler, storage := newTestHandler(t)
+	ctx := context.Background()
+
+	past := map[string]string{expireMetadataKey: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)}
+	future := map[string]string{expireMetadataKey: time.Now().Add(time.Hour).UTC().Format(time.RFC3339)}
+	for key, metadata := range map[string]map[string]string{
+		"old": past, "old.html": past, "fresh": future, "fresh.html": future, "forever": nil,
+	} {
+		if err := storage.UploadString(ctx, key, "content", contentTypeText, metadata); err != nil {
+			t.Fatalf("upload %s: %v", key, err)
+		}
+	}
+
+	if resp := serve(handler, httptest.NewRequest(http.MethodGet, "/old.html", nil)); resp.StatusCode != http.StatusGone {
+		t.Fatalf("expected 410 for an expired paste, got %d", resp.StatusCode)
+	}
+	if resp := serve(handler, httptest.NewRequest(http.MethodGet, "/fresh.html", nil)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for a fresh paste, got %d", resp.StatusCode)
+	}
+
+	sweeper := &Sweeper{Storage: storage, Interval: time.Hour}
+	if deleted, err := sweeper.Sweep(ctx); err != nil || deleted != 0 {
+		t.Fatalf("expected pastes to be kept unless enabled, got %d deleted: %v", deleted, err)
+	}
+
+	sweeper.Pastes = true
+	deleted, err := sweeper.Sweep(ctx)
+	if err != nil || deleted != 2 {
+		t.Fatalf("expected 2 deleted objects, got %d: %v", deleted, err)
+	}
+	for _, key := range []string{"old", "old.html"} {
+		if _, err := storage.HeadObject(ctx, key); !errors.Is(err, ErrObjectNotFound) {
+			t.Fatalf("expired object %s was not removed", key)
+		}
+	}
+	for _, key := range []string{"fresh", "fresh.html", "forever"} {
+		if _, err := storage.HeadObject(ctx, key); err != nil {
+			t.Fatalf("object %s was removed: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package makaroni
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	fsObjectsDir = "objects"
+	fsTempDir    = "tmp"
+
+	// fsTrailerLengthSize is the size of the length of the metadata closing an object file
+	fsTrailerLengthSize = 8
+)
+
+// FileStorage is the local filesystem implementation of Storage.
+//
+// Every object is a single file under <root>/objects/<key>: the content, followed by the content type and
+// metadata as JSON and the length of that JSON. Every write goes to <root>/tmp first and is moved into place
+// with one rename, so readers see either the previous or the new object, never parts of both.
+type FileStorage struct {
+	root string
+}
+
+var _ Storage = (*FileStorage)(nil)
+
+// fileMetadata is the on-disk representation of object information
+type fileMetadata struct {
+	ContentType  string            `json:"contentType"`
+	ETag         string            `json:"etag"`
+	LastModified time.Time         `json:"lastModified"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// NewFileStorage creates a filesystem storage rooted at the given directory
+func NewFileStorage(root string) (*FileStorage, error) {
+	if root == "" {
+		return nil, errors.New("storage path is not set")
+	}
+
+	for _, dir := range []string{fsObjectsDir, fsTempDir} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o750); err != nil {
+			return nil, fmt.Errorf("failed to create storage directory: %w", err)
+		}
+	}
+	log.Info("Filesystem storage initialized at ", root)
+
+	return &FileStorage{root: root}, nil
+}
+
+// objectPath returns the path of the object file for a key
+func (f *FileStorage) objectPath(key string) (string, error) {
+	if key == "" || strings.Contains(key, "\\") || !fs.ValidPath(key) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(f.root, fsObjectsDir, filepath.FromSlash(key)), nil
+}
+
+// UploadString stores string content on disk
+func (f *FileStorage) UploadString(ctx context.Context, key string, content string, contentType string, metadata map[string]string) error {
+	return f.UploadReader(ctx, key, strings.NewReader(content), contentType, metadata)
+}
+
+// UploadReader stores data read from reader on disk
+func (f *FileStorage) UploadReader(ctx context.Context, key string, reader io.Reader, contentType string, metadata map[string]string) error {
+	log.Debugf("Starting upload for key: %s", key)
+
+	path, err := f.objectPath(key)
+	if err != nil {
+		return err
+	}
+
+	err = f.writeObject(path, func(w io.Writer) (*fileMetadata, error) {
+		hash := md5.New()
+		if _, err := io.Copy(io.MultiWriter(w, hash), &contextReader{ctx: ctx, r: reader}); err != nil {
+			return nil, err
+		}
+		return &fileMetadata{
+			ContentType:  contentType,
+			ETag:         `"` + hex.EncodeToString(hash.Sum(nil)) + `"`,
+			LastModified: time.Now().UTC(),
+			Metadata:     metadata,
+		}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("upload failed for key %s: %w", key, err)
+	}
+
+	log.Debugf("Upload succeeded for key: %s, location: %s", key, path)
+	return nil
+}
+
+// writeObject writes a new object file to the temp directory and renames it to path. The content is
+// written by write, which returns the metadata stored after it.
+func (f *FileStorage) writeObject(path string, write func(io.Writer) (*fileMetadata, error)) error {
+	file, err := os.CreateTemp(filepath.Join(f.root, fsTempDir), "upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	err = writeObjectFile(file, write)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// writeObjectFile writes the content and the metadata trailer of an object file
+func writeObjectFile(w io.Writer, write func(io.Writer) (*fileMetadata, error)) error {
+	meta, err := write(w)
+	if err != nil {
+		return err
+	}
+	trailer, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	trailer = binary.BigEndian.AppendUint64(trailer, uint64(len(trailer)))
+	_, err = w.Write(trailer)
+	return err
+}
+
+// openObject opens the object file for a key, returning the file and the object information
+func (f *FileStorage) openObject(key string) (*os.File, *ObjectInfo, error) {
+	path, err := f.objectPath(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, fmt.Errorf("open %s: %w", key, ErrObjectNotFound)
+		}
+		return nil, nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if stat.IsDir() {
+		// Only holds the objects whose keys continue with a slash
+		file.Close()
+		return nil, nil, fmt.Errorf("open %s: %w", key, ErrObjectNotFound)
+	}
+
+	info, err := readObjectInfo(file, stat.Size())
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to read object %s: %w", key, err)
+	}
+	info.Key = key
+	return file, info, nil
+}
+
+// readObjectInfo reads the metadata trailer of an object file of fileSize bytes
+func readObjectInfo(file *os.File, fileSize int64) (*ObjectInfo, error) {
+	if fileSize < fsTrailerLengthSize {
+		return nil, errors.New("truncated object file")
+	}
+	var length [fsTrailerLengthSize]byte
+	if _, err := file.ReadAt(length[:], fileSize-fsTrailerLengthSize); err != nil {
+		return nil, err
+	}
+	trailerSize := int64(binary.BigEndian.Uint64(length[:]))
+	size := fileSize - fsTrailerLengthSize - trailerSize
+	if trailerSize < 0 || size < 0 {
+		return nil, errors.New("corrupt object file")
+	}
+
+	trailer := make([]byte, trailerSize)
+	if _, err := file.ReadAt(trailer, size); err != nil {
+		return nil, err
+	}
+	var meta fileMetadata
+	if err := json.Unmarshal(trailer, &meta); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	if meta.Metadata == nil {
+		meta.Metadata = map[string]string{}
+	}
+
+	return &ObjectInfo{
+		Size:         size,
+		ContentType:  meta.ContentType,
+		ETag:         meta.ETag,
+		LastModified: meta.LastModified,
+		Metadata:     meta.Metadata,
+	}, nil
+}
+
+// HeadObject reads object information and metadata from disk
+func (f *FileStorage) HeadObject(ctx context.Context, key string) (*ObjectInfo, error) {
+	file, info, err := f.openObject(key)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return info, nil
+}
+
+// GetMetadata retrieves metadata for an object
+func (f *FileStorage) GetMetadata(ctx context.Context, key string) (map[string]string, error) {
+	info, err := f.HeadObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return info.Metadata, nil
+}
+
+// UpdateMetadata replaces the metadata of an object by rewriting its file, like S3 copies an object onto itself
+func (f *FileStorage) UpdateMetadata(ctx context.Context, key string, metadata map[string]string) error {
+	file, info, err := f.openObject(key)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	path, _ := f.objectPath(key)
+	err = f.writeObject(path, func(w io.Writer) (*fileMetadata, error) {
+		if _, err := io.Copy(w, &contextReader{ctx: ctx, r: io.LimitReader(file, info.Size)}); err != nil {
+			return nil, err
+		}
+		return &fileMetadata{
+			ContentType:  info.ContentType,
+			ETag:         info.ETag,
+			LastModified: info.LastModified,
+			Metadata:     metadata,
+		}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update metadata for key %s: %w", key, err)
+	}
+	return nil
+}
+
+// GetObject opens the object file for reading its content
+func (f *FileStorage) GetObject(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	file, info, err := f.openObject(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(file, info.Size), Closer: file}, info, nil
+}
+
+// GetObjectRange opens the object file positioned at offset
+func (f *FileStorage) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	file, info, err := f.openObject(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	remaining := info.Size - offset
+	if remaining < 0 {
+		remaining = 0
+	}
+	if length < 0 || length > remaining {
+		length = remaining
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(file, length), Closer: file}, nil
+}
+
+// DeleteObjects removes object files, missing files are ignored
+func (f *FileStorage) DeleteObjects(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	log.Infof("Deleting %d objects in batch", len(keys))
+
+	var failed int
+	for _, key := range keys {
+		path, err := f.objectPath(key)
+		if err == nil {
+			err = os.Remove(path)
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Warnf("Failed to delete object %s: %v", key, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d objects", failed)
+	}
+
+	log.Debugf("Successfully deleted %d objects", len(keys))
+	return nil
+}
+
+// ListObjects walks the objects directory in lexical order
+func (f *FileStorage) ListObjects(ctx context.Context, prefix string, fn ListFunc) error {
+	objectsRoot := filepath.Join(f.root, fsObjectsDir)
+	return filepath.WalkDir(objectsRoot, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(objectsRoot, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		file, info, err := f.openObject(key)
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		file.Close()
+		return fn(&ObjectInfo{
+			Key:          key,
+			Size:         info.Size,
+			LastModified: info.LastModified,
+		})
+	})
+}
+
+// limitedReadCloser reads from a limited reader and closes the underlying one
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// contextReader stops reading once the context is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
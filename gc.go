@@ -0,0 +1,91 @@
+package makaroni
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultGCGracePeriod protects pastes that are still being written from the collector
+const DefaultGCGracePeriod = time.Hour
+
+// Collector removes orphaned objects: raw content without its page and pages without their raw content.
+// They are left behind by failed uploads and by deleting only one of the two objects.
+// With deduplication it also removes blobs no index entry refers to, left behind the same way.
+type Collector struct {
+	Storage     Storage
+	Interval    time.Duration
+	GracePeriod time.Duration // Orphans younger than this are kept, their paste may still be in progress
+}
+
+// Run collects garbage every Interval until the context is cancelled
+func (c *Collector) Run(ctx context.Context) {
+	log.Infof("Starting orphaned object collector with interval %s", c.Interval)
+
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.Collect(ctx, false); err != nil && ctx.Err() == nil {
+			log.Error("Error collecting orphaned objects: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Info("Orphaned object collector stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect finds orphaned pastes and blobs and, unless dryRun is set, deletes those past the grace period.
+// It returns all orphaned pastes found, including the ones still within the grace period.
+func (c *Collector) Collect(ctx context.Context, dryRun bool) ([]*StoredPaste, error) {
+	now := time.Now()
+	var orphans, expired []*StoredPaste
+
+	err := ListPastes(ctx, c.Storage, func(paste *StoredPaste) error {
+		if paste.Raw != nil && paste.HTML != nil {
+			return nil
+		}
+		orphans = append(orphans, paste)
+		if c.Expired(paste, now) {
+			log.Debug("Found orphaned object: ", paste.Keys())
+			expired = append(expired, paste)
+		}
+		return nil
+	})
+	if err != nil {
+		return orphans, err
+	}
+
+	if !dryRun && len(expired) > 0 {
+		if err := DeletePastes(ctx, c.Storage, expired); err != nil {
+			return orphans, err
+		}
+		log.Infof("Deleted %d orphaned pastes", len(expired))
+	}
+
+	// Blobs are stored before they are indexed, the grace period keeps those still being indexed
+	if dedup := findDedupStorage(c.Storage); dedup != nil {
+		blobs, err := dedup.collectBlobs(ctx, now.Add(-c.GracePeriod), dryRun)
+		if err != nil {
+			return orphans, err
+		}
+		switch {
+		case len(blobs) == 0:
+		case dryRun:
+			log.Infof("Found %d unreferenced blobs", len(blobs))
+		default:
+			log.Infof("Deleted %d unreferenced blobs", len(blobs))
+		}
+	}
+	return orphans, nil
+}
+
+// Expired reports whether an orphan is past the grace period and may be deleted
+func (c *Collector) Expired(paste *StoredPaste, now time.Time) bool {
+	return now.Sub(paste.CreateTime()) >= c.GracePeriod
+}
@@ -0,0 +1,95 @@
+package makaroni
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCollectorDeletesOrphans(t *testing.T) {
+	handler, storage := newTestHandler(t)
+	ctx := context.Background()
+
+	complete := pasteCookie(t, serve(handler, newMultipartRequest(t, map[string]string{"content": "complete"}, nil)))
+	broken := pasteCookie(t, serve(handler, newMultipartRequest(t, map[string]string{"content": "broken"}, nil)))
+	if err := storage.DeleteObjects(ctx, []string{broken.HtmlKey}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := storage.UploadString(ctx, "lonely.html", "<html>", contentTypeHTML, map[string]string{rawKeyMetadataKey: "lonely"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	collector := &Collector{Storage: storage, GracePeriod: time.Hour}
+	orphans, err := collector.Collect(ctx, false)
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if len(orphans) != 2 {
+		t.Fatalf("expected 2 orphans, got %d", len(orphans))
+	}
+	if _, err := storage.HeadObject(ctx, broken.RawKey); err != nil {
+		t.Fatal("orphan deleted within the grace period")
+	}
+
+	collector.GracePeriod = 0
+	if _, err := collector.Collect(ctx, true); err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if _, err := storage.HeadObject(ctx, broken.RawKey); err != nil {
+		t.Fatal("orphan deleted in a dry run")
+	}
+
+	if _, err := collector.Collect(ctx, false); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	for _, key := range []string{broken.RawKey, "lonely.html"} {
+		if _, err := storage.HeadObject(ctx, key); !errors.Is(err, ErrObjectNotFound) {
+			t.Fatalf("orphan %s not deleted: %v", key, err)
+		}
+	}
+	for _, key := range []string{complete.RawKey, complete.HtmlKey} {
+		if _, err := storage.HeadObject(ctx, key); err != nil {
+			t.Fatalf("complete paste object %s deleted: %v", key, err)
+		}
+	}
+}
+
+func TestCollectorDeletesUnreferencedBlobs(t *testing.T) {
+	backend := NewMemoryStorage()
+	storage := NewCompressedStorage(NewDedupStorage(backend, []byte("secret")))
+	ctx := context.Background()
+
+	if err := storage.UploadString(ctx, "paste", "content", contentTypeText, map[string]string{htmlKeyMetadataKey: "paste.html"}); err != nil {
+		t.Fatalf("upload paste: %v", err)
+	}
+	if err := storage.UploadString(ctx, "paste.html", "<html>", contentTypeHTML, map[string]string{rawKeyMetadataKey: "paste"}); err != nil {
+		t.Fatalf("upload page: %v", err)
+	}
+	stray := blobDataPrefix + "stray"
+	if err := backend.UploadString(ctx, stray, "never indexed", contentTypeText, nil); err != nil {
+		t.Fatalf("upload blob: %v", err)
+	}
+
+	collector := &Collector{Storage: storage, GracePeriod: time.Hour}
+	if _, err := collector.Collect(ctx, false); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	collector.GracePeriod = 0
+	if _, err := collector.Collect(ctx, true); err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if blobs := storedKeys(t, backend, blobDataPrefix); len(blobs) != 2 {
+		t.Fatalf("blob deleted within the grace period or in a dry run, left %v", blobs)
+	}
+
+	if _, err := collector.Collect(ctx, false); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if blobs := storedKeys(t, backend, blobDataPrefix); len(blobs) != 1 || blobs[0] == stray {
+		t.Fatalf("expected only the referenced blob to be kept, got %v", blobs)
+	}
+	if content, _ := readObject(t, storage, "paste"); content != "content" {
+		t.Fatalf("unexpected paste content %q", content)
+	}
+}
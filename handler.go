@@ -1,40 +1,46 @@
 package makaroni
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"mime/multipart"
 	"net/http"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
-	pasteDataCookieName = "paste_data"
-	cookieMaxAge        = 86400 * 365 // 365 days
-	contentTypeHTML     = "text/html"
-	contentTypeText     = "text/plain"
+	pasteDataCookieName   = "paste_data"
+	keyGenerationAttempts = 5
+	cookieMaxAge          = 86400 * 365 // 365 days
+	contentTypeHTML       = "text/html"
+	contentTypeText       = "text/plain"
 )
 
 var (
 	ErrEmptyFormContent = errors.New("empty form content")
+	ErrKeysExhausted    = errors.New("no free paste ID found")
 )
 
 // PasteHandler structure for handling uploads
 type PasteHandler struct {
 	IndexHTML          []byte
-	Uploader           *Uploader
+	Storage            Storage
 	Style              string
 	ResultURLPrefix    string
 	MultipartMaxMemory int64
 	Config             *Config
+	IDGenerator        IDGenerator // Generates paste IDs, UUIDs when nil
+
+	viewsMu          sync.Mutex     // Serializes view counter updates
+	uploadLocks      sync.Map       // Upload ID to *sync.Mutex, serializes the requests for one upload
+	passwordAttempts attemptLimiter // Failed password attempts per paste
 }
 
 // PasteObject represents a single uploaded object data
@@ -98,9 +104,32 @@ func (p *PasteHandler) setCookies(w http.ResponseWriter, keyRaw, keyHtml, keyDel
 func (p *PasteHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	log.Info("Received request: ", req.Method, " ", req.URL.Path)
 
+	if req.URL.Path == apiDirectUploadsPath || strings.HasPrefix(req.URL.Path, apiDirectUploadsPath+"/") {
+		p.handleDirectUploadRequest(w, req)
+		return
+	}
+	if req.URL.Path == tusUploadsPath || strings.HasPrefix(req.URL.Path, tusUploadsPath+"/") {
+		p.handleTusRequest(w, req)
+		return
+	}
+	if req.URL.Path == apiPastesPath || strings.HasPrefix(req.URL.Path, apiPastesPath+"/") {
+		p.handleAPIRequest(w, req)
+		return
+	}
+	if strings.HasPrefix(req.URL.Path, unlockPath) {
+		p.handleUnlock(w, req)
+		return
+	}
+
 	switch req.Method {
-	case http.MethodGet:
-		p.handleGetRequest(w)
+	case http.MethodGet, http.MethodHead:
+		if req.URL.Path == "/" {
+			p.handleGetRequest(w)
+		} else if p.Config.PresignedLinks && strings.HasPrefix(req.URL.Path, presignedLinkPath) {
+			p.handlePresignedLink(w, req)
+		} else {
+			p.handleServeRequest(w, req)
+		}
 	case http.MethodPost:
 		p.handlePostRequest(w, req)
 	case http.MethodDelete:
@@ -123,59 +152,82 @@ func (p *PasteHandler) handleGetRequest(w http.ResponseWriter) {
 
 // handlePostRequest handles POST requests for uploading content
 func (p *PasteHandler) handlePostRequest(w http.ResponseWriter, req *http.Request) {
-	if err := req.ParseMultipartForm(p.MultipartMaxMemory); err != nil {
-		log.Warn("Error parsing form: ", err)
-		p.RespondWithError(w, http.StatusBadRequest, "Invalid form", p.Config)
-		return
+	plain := wantsPlainText(req)
+
+	var pr *pasteRequest
+	var result *pasteResult
+	var err error
+	if isMultipartRequest(req) {
+		if pr, result, err = p.streamMultipartPaste(req.Context(), req); err != nil {
+			log.Warn("Error reading form: ", err)
+			status, message := pasteErrorStatus(err)
+			p.respondError(w, plain, status, message)
+			return
+		}
+	} else {
+		if pr, err = p.getRawContent(w, req); err != nil {
+			log.Warn("Error reading request body: ", err)
+			p.respondError(w, plain, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
 	}
 
-	keyRaw, keyHtml, keyDelete, err := p.generateKeys()
-	if err != nil {
-		p.RespondWithError(w, http.StatusInternalServerError, "Failed to generate keys", p.Config)
-		return
+	if result == nil {
+		if pr.empty() {
+			if plain {
+				p.respondError(w, plain, http.StatusBadRequest, "Empty paste")
+				return
+			}
+			log.Info("Empty form content, redirecting to index")
+			p.redirectToURL(w, req, "/")
+			return
+		}
+
+		if result, err = p.createPaste(req.Context(), pr); err != nil {
+			status, message := pasteErrorStatus(err)
+			p.respondError(w, plain, status, message)
+			return
+		}
 	}
 
-	metadata := map[string]*string{
-		"delete": &keyDelete,
+	if plain {
+		p.respondPlainCreated(w, result)
+		return
 	}
 
-	urlHTML := p.ResultURLPrefix + keyHtml
-	urlRaw := p.ResultURLPrefix + keyRaw
+	// Set cookie with paste data
+	p.setCookies(w, result.RawKey, result.HtmlKey, result.DeleteKey)
 
-	content, file, header, err := p.getFormContent(w, req)
-	if err != nil {
-		if errors.Is(err, ErrEmptyFormContent) {
-			return // getFormContent already handles the redirect
-		}
-		p.RespondWithError(w, http.StatusInternalServerError, "Failed to get form content", p.Config)
+	// Following the redirect would use up one of the views, so show the links instead
+	if result.MaxViews > 0 {
+		p.respondCreated(w, result.URL, result.MaxViews)
 		return
 	}
 
-	var html string
-	if file != nil {
-		html, keyRaw, err = p.processFileUpload(req, file, header, keyRaw, metadata)
-	} else {
-		html, err = p.processTextUpload(req, content, keyRaw, urlRaw, metadata)
-	}
+	p.redirectToURL(w, req, result.URL)
+	log.Debug("Redirecting to URL: ", result.URL)
+}
 
+// respondCreated renders the page with links to a newly created paste
+func (p *PasteHandler) respondCreated(w http.ResponseWriter, urlHTML string, maxViews int) {
+	html, err := RenderCreated(CreatedData{
+		LogoURL:    p.Config.LogoURL,
+		IndexURL:   p.Config.IndexURL,
+		FaviconURL: p.Config.FaviconURL,
+		URL:        urlHTML,
+		MaxViews:   maxViews,
+	})
 	if err != nil {
-		p.RespondWithError(w, http.StatusInternalServerError, "Failed to process upload", p.Config)
+		log.Error("Error rendering created page: ", err)
+		p.RespondWithError(w, http.StatusInternalServerError, "Failed to render page", p.Config)
 		return
 	}
 
-	if err = p.Uploader.UploadString(req.Context(), keyHtml, html, contentTypeHTML, metadata); err != nil {
-		log.Error("Error uploading HTML: ", err)
-		p.RespondWithError(w, http.StatusInternalServerError, "Failed to upload HTML content", p.Config)
-		return
+	SetCommonHeaders(w, contentTypeHTML)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(html); err != nil {
+		log.Error("Error sending created page: ", err)
 	}
-
-	log.Info("Uploaded HTML content with key: ", keyHtml)
-
-	// Set cookie with paste data
-	p.setCookies(w, keyRaw, keyHtml, keyDelete)
-
-	p.redirectToURL(w, req, urlHTML)
-	log.Debug("Redirecting to URL: ", urlHTML)
 }
 
 // handleDeleteRequest handles DELETE requests to remove pastes
@@ -191,80 +243,138 @@ func (p *PasteHandler) handleDeleteRequest(w http.ResponseWriter, req *http.Requ
 		return
 	}
 
-	log.Info("Deleting paste with rawKey: ", rawKey, ", using deleteKey: ", deleteKey)
+	log.Info("Deleting paste with rawKey: ", rawKey)
+
+	err := p.deletePaste(req.Context(), rawKey, htmlKey, deleteKey)
+	switch {
+	case err == nil, errors.Is(err, ErrObjectNotFound):
+		w.WriteHeader(http.StatusOK)
+	case errors.Is(err, ErrInvalidDeleteKey):
+		p.RespondWithError(w, http.StatusForbidden, "Invalid delete key", p.Config)
+	default:
+		p.RespondWithError(w, http.StatusInternalServerError, "Failed to delete objects", p.Config)
+	}
+}
 
+// deletePaste removes both objects of a paste after checking the delete key against each of them
+func (p *PasteHandler) deletePaste(ctx context.Context, rawKey, htmlKey, deleteKey string) error {
 	// Prepare list of keys to delete
 	keysToDelete := []string{rawKey, htmlKey}
 	for _, key := range keysToDelete {
-		metadata, err := p.Uploader.GetMetadata(req.Context(), key)
-		if err != nil && err.(awserr.Error).Code() == "NotFound" {
-			w.WriteHeader(http.StatusOK)
-			return
+		metadata, err := p.Storage.GetMetadata(ctx, key)
+		if errors.Is(err, ErrObjectNotFound) {
+			return err
 		}
 		if err != nil {
 			log.Error("Error retrieving metadata: ", err)
-			p.RespondWithError(w, http.StatusNotFound, "Metadata not found", p.Config)
-			return
+			return err
 		}
 
-		storedDeleteKey, exists := metadata["delete"]
-		if !exists || storedDeleteKey == nil || *storedDeleteKey != deleteKey {
+		if !verifyDeleteKey(metadata, deleteKey) {
 			log.Warn("Invalid delete key provided for: ", rawKey)
-			p.RespondWithError(w, http.StatusForbidden, "Invalid delete key", p.Config)
-			return
+			return ErrInvalidDeleteKey
 		}
 	}
 
 	// Delete all objects in a single batch request
-	if err := p.Uploader.DeleteObjects(req.Context(), keysToDelete); err != nil {
+	if err := p.Storage.DeleteObjects(ctx, keysToDelete); err != nil {
 		log.Error("Error deleting objects: ", err)
-		p.RespondWithError(w, http.StatusInternalServerError, "Failed to delete objects", p.Config)
-		return
+		return err
 	}
 
 	log.Info("Successfully deleted paste with key: ", rawKey)
-	w.WriteHeader(http.StatusOK)
+	return nil
 }
 
-// generateKeys generates unique keys for raw and HTML content
-func (p *PasteHandler) generateKeys() (string, string, string, error) {
-	uuidV4, err := uuid.NewRandom()
-	deleteUuid, err := uuid.NewRandom()
+// generateKeys generates unique keys for raw and HTML content, using the slug as the paste ID when set
+func (p *PasteHandler) generateKeys(ctx context.Context, slug string) (string, string, string, error) {
+	generator := p.IDGenerator
+	if generator == nil {
+		generator = UUIDGenerator{}
+	}
+
+	keyDelete, err := newDeleteKey()
 	if err != nil {
 		log.Error("Error generating UUID: ", err)
 		return "", "", "", err
 	}
-	keyRaw := uuidV4.String()
-	keyHtml := keyRaw + ".html"
-	keyDelete := deleteUuid.String()
-	return keyRaw, keyHtml, keyDelete, nil
+
+	if slug != "" {
+		if err := validateSlug(slug); err != nil {
+			return "", "", "", err
+		}
+		taken, err := p.keysTaken(ctx, slug, slug+".html")
+		if err != nil {
+			return "", "", "", err
+		}
+		if taken {
+			log.Info("Requested slug is already taken: ", slug)
+			return "", "", "", ErrSlugTaken
+		}
+		return slug, slug + ".html", keyDelete, nil
+	}
+
+	for attempt := 0; attempt < keyGenerationAttempts; attempt++ {
+		keyRaw, err := generator.NewID()
+		if err != nil {
+			log.Error("Error generating paste ID: ", err)
+			return "", "", "", err
+		}
+		keyHtml := keyRaw + ".html"
+
+		taken, err := p.keysTaken(ctx, keyRaw, keyHtml)
+		if err != nil {
+			return "", "", "", err
+		}
+		if !taken {
+			return keyRaw, keyHtml, keyDelete, nil
+		}
+		log.Debug("Generated paste ID is already taken: ", keyRaw)
+	}
+
+	log.Error("Failed to generate a free paste ID")
+	return "", "", "", ErrKeysExhausted
 }
 
-// processFileUpload handles file upload and returns the rendered HTML
-func (p *PasteHandler) processFileUpload(req *http.Request, file multipart.File, header *multipart.FileHeader, keyRaw string, metadata map[string]*string) (string, string, error) {
-	fileExtension := filepath.Ext(header.Filename)
-	contentType := header.Header.Get("Content-Type")
+// keysTaken reports whether any of the keys already exists in storage
+func (p *PasteHandler) keysTaken(ctx context.Context, keys ...string) (bool, error) {
+	for _, key := range keys {
+		_, err := p.Storage.GetMetadata(ctx, key)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, ErrObjectNotFound) {
+			log.Error("Error checking paste ID: ", err)
+			return false, err
+		}
+	}
+	return false, nil
+}
 
+// renderFilePage returns the raw key with the file extension and the rendered file download page
+func (p *PasteHandler) renderFilePage(pr *pasteRequest, keyRaw string) (string, string, error) {
+	keyHtml := keyRaw + ".html"
+	fileExtension := filepath.Ext(pr.FileName)
 	if len(fileExtension) > 0 {
 		keyRaw = keyRaw + fileExtension
 	}
 
-	if err := p.Uploader.UploadReader(req.Context(), keyRaw, file, contentType, metadata); err != nil {
-		log.Error("Error uploading file: ", err)
-		return "", "", err
+	// Relative to the page, unless pages are only reachable through presigned links
+	downloadURL := keyRaw
+	if p.Config.PresignedLinks {
+		_, downloadURL = p.pasteURLs(keyRaw, keyHtml)
 	}
 
-	log.Info("Uploaded file with key: ", keyRaw)
-	log.Debug("File Size: " + fmt.Sprintf("%d", header.Size))
-	log.Debug("MIME Header: " + header.Header.Get("Content-Type"))
+	log.Debug("File Size: " + fmt.Sprintf("%d", pr.FileSize))
+	log.Debug("MIME Header: " + pr.FileType)
 
 	data := FileDownloadData{
 		LogoURL:     p.Config.LogoURL,
 		IndexURL:    p.Config.IndexURL,
 		FaviconURL:  p.Config.FaviconURL,
-		FileName:    header.Filename,
-		DownloadURL: keyRaw,
-		CanView:     CanViewInBrowser(contentType),
+		FileName:    pr.FileName,
+		DownloadURL: downloadURL,
+		CanView:     CanViewInBrowser(pr.FileType),
 	}
 
 	downloadHtml, err := RenderFileDownload(data)
@@ -276,9 +386,9 @@ func (p *PasteHandler) processFileUpload(req *http.Request, file multipart.File,
 	return string(downloadHtml), keyRaw, nil
 }
 
-// processTextUpload handles text content upload and returns the rendered HTML
-func (p *PasteHandler) processTextUpload(req *http.Request, content, keyRaw, urlRaw string, metadata map[string]*string) (string, error) {
-	syntax := req.Form.Get("syntax")
+// renderTextPage returns the highlighted page of a text paste
+func (p *PasteHandler) renderTextPage(pr *pasteRequest, urlRaw string) (string, error) {
+	syntax := pr.Syntax
 	if len(syntax) == 0 {
 		syntax = "plaintext"
 	}
@@ -292,18 +402,9 @@ func (p *PasteHandler) processTextUpload(req *http.Request, content, keyRaw, url
 		DownloadURL: urlRaw,
 	}
 
-	// If content longer than 100 kilobytes, do not highlight it
-	if len(content) > 1024*100 {
-		log.Debugf("Content size more than 100kb: '%d' bytes, using pre tag", len(content))
-		prePageData.Content = content
-	} else {
-		log.Debugf("Content size: '%d' bytes, highlighting it", len(content))
-		highlightBuilder := strings.Builder{}
-		if err := highlight(&highlightBuilder, content, syntax, p.Style); err != nil {
-			log.Error("Error highlighting content: ", err)
-			return "", err
-		}
-		prePageData.Content = highlightBuilder.String()
+	var err error
+	if prePageData.Content, err = highlightContent(pr.Content, syntax, p.Style); err != nil {
+		return "", err
 	}
 
 	preHtmlPage, err := RenderOutputPre(prePageData)
@@ -312,35 +413,37 @@ func (p *PasteHandler) processTextUpload(req *http.Request, content, keyRaw, url
 		return "", err
 	}
 
-	if err := p.Uploader.UploadString(req.Context(), keyRaw, content, contentTypeText, metadata); err != nil {
-		log.Error("Error uploading raw content: ", err)
-		return "", err
-	}
-
-	log.Info("Uploaded raw content with key: ", keyRaw)
 	return string(preHtmlPage), nil
 }
 
-// getFormContent extracts content, file, and header from the form
-func (p *PasteHandler) getFormContent(w http.ResponseWriter, req *http.Request) (string, multipart.File, *multipart.FileHeader, error) {
-	content := req.Form.Get("content")
-	file, header, err := req.FormFile("file")
-	if err != nil && !errors.Is(err, http.ErrMissingFile) {
-		log.Warn("Error retrieving the file: ", err)
-		return "", nil, nil, err
+// renderEncryptedPage returns the page of an end-to-end encrypted paste, which carries the ciphertext
+// and decrypts it in the browser with the key from the URL fragment
+func (p *PasteHandler) renderEncryptedPage(pr *pasteRequest) (string, error) {
+	paste, _, err := parseEncryptedPaste(pr.Content)
+	if err != nil {
+		return "", err
 	}
 
-	if file != nil {
-		defer file.Close()
+	// json.Marshal escapes <, > and &, so the data cannot close the script element it is embedded in
+	data, err := json.Marshal(struct {
+		Syntax string          `json:"syntax"`
+		Paste  *EncryptedPaste `json:"paste"`
+	}{Syntax: pr.Syntax, Paste: paste})
+	if err != nil {
+		return "", err
 	}
 
-	if file == nil && len(content) == 0 {
-		log.Info("Empty form content, redirecting to index")
-		p.redirectToURL(w, req, "/")
-		return "", nil, nil, ErrEmptyFormContent
+	page, err := RenderOutputPreEncrypted(EncryptedPreData{
+		LogoURL:    p.Config.LogoURL,
+		IndexURL:   p.Config.IndexURL,
+		FaviconURL: p.Config.FaviconURL,
+		Paste:      string(data),
+	})
+	if err != nil {
+		log.Error("Error rendering encrypted output pre HTML: ", err)
+		return "", err
 	}
-
-	return content, file, header, nil
+	return string(page), nil
 }
 
 // redirectToURL redirects the user to the specified URL.
@@ -0,0 +1,296 @@
+package makaroni
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const testURLPrefix = "http://paste.test/"
+
+func init() {
+	log.SetOutput(io.Discard)
+}
+
+// newTestHandler creates a PasteHandler backed by in-memory storage
+func newTestHandler(t *testing.T) (*PasteHandler, *MemoryStorage) {
+	t.Helper()
+
+	config := &Config{
+		IndexURL:        "http://paste.test",
+		ResultURLPrefix: testURLPrefix,
+		LogoURL:         "http://paste.test/static/logo.png",
+		FaviconURL:      "http://paste.test/static/favicon.ico",
+		Style:           "default",
+	}
+	storage := NewMemoryStorage()
+	return &PasteHandler{
+		IndexHTML:          []byte("<html>index</html>"),
+		Storage:            storage,
+		Style:              config.Style,
+		ResultURLPrefix:    config.ResultURLPrefix,
+		MultipartMaxMemory: 1 << 20,
+		Config:             config,
+	}, storage
+}
+
+// testFile describes a file part of a multipart form
+type testFile struct {
+	field       string
+	name        string
+	contentType string
+	content     string
+}
+
+// newMultipartRequest builds a browser-like POST request with the given form fields and optional file
+func newMultipartRequest(t *testing.T, fields map[string]string, file *testFile) *http.Request {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			t.Fatalf("write field: %v", err)
+		}
+	}
+	if file != nil {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="`+file.field+`"; filename="`+file.name+`"`)
+		header.Set("Content-Type", file.contentType)
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			t.Fatalf("create part: %v", err)
+		}
+		if _, err := part.Write([]byte(file.content)); err != nil {
+			t.Fatalf("write part: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close writer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	return req
+}
+
+// pasteCookie decodes the paste_data cookie set by the handler
+func pasteCookie(t *testing.T, resp *http.Response) PasteObject {
+	t.Helper()
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name != pasteDataCookieName {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(cookie.Value)
+		if err != nil {
+			t.Fatalf("decode cookie: %v", err)
+		}
+		var data PasteData
+		if err := json.Unmarshal(raw, &data); err != nil {
+			t.Fatalf("unmarshal cookie: %v", err)
+		}
+		if len(data.Objects) != 1 {
+			t.Fatalf("expected one object in cookie, got %d", len(data.Objects))
+		}
+		return data.Objects[0]
+	}
+	t.Fatal("paste_data cookie not set")
+	return PasteObject{}
+}
+
+// readObject returns the stored content of a key
+func readObject(t *testing.T, storage Storage, key string) (string, *ObjectInfo) {
+	t.Helper()
+
+	reader, info, err := storage.GetObject(context.Background(), key)
+	if err != nil {
+		t.Fatalf("get object %s: %v", key, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("read object %s: %v", key, err)
+	}
+	return string(data), info
+}
+
+// serve runs the request through the handler and returns the response
+func serve(handler http.Handler, req *http.Request) *http.Response {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder.Result()
+}
+
+func TestGetIndex(t *testing.T) {
+	handler, _ := newTestHandler(t)
+
+	resp := serve(handler, httptest.NewRequest(http.MethodGet, "/", nil))
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if string(body) != "<html>index</html>" {
+		t.Fatalf("unexpected index body: %q", body)
+	}
+}
+
+func TestPostTextPaste(t *testing.T) {
+	handler, storage := newTestHandler(t)
+
+	req := newMultipartRequest(t, map[string]string{"content": "package main\n", "syntax": "go"}, nil)
+	resp := serve(handler, req)
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected 302, got %d", resp.StatusCode)
+	}
+	object := pasteCookie(t, resp)
+	if location := resp.Header.Get("Location"); location != testURLPrefix+object.HtmlKey {
+		t.Fatalf("unexpected redirect location %q", location)
+	}
+	if object.HtmlKey != object.RawKey+".html" {
+		t.Fatalf("unexpected html key %q for raw key %q", object.HtmlKey, object.RawKey)
+	}
+
+	raw, rawInfo := readObject(t, storage, object.RawKey)
+	if raw != "package main\n" || rawInfo.ContentType != contentTypeText {
+		t.Fatalf("unexpected raw object %q (%s)", raw, rawInfo.ContentType)
+	}
+	html, htmlInfo := readObject(t, storage, object.HtmlKey)
+	if htmlInfo.ContentType != contentTypeHTML || !strings.Contains(html, testURLPrefix+object.RawKey) {
+		t.Fatalf("html page does not link raw content")
+	}
+	for _, info := range []*ObjectInfo{rawInfo, htmlInfo} {
+		if _, exists := info.Metadata[legacyDeleteMetadataKey]; exists {
+			t.Fatal("plaintext delete key stored in metadata")
+		}
+		if !verifyDeleteKey(info.Metadata, object.DeleteKey) {
+			t.Fatal("stored delete hash does not match the delete key")
+		}
+	}
+}
+
+func TestPostFileUpload(t *testing.T) {
+	handler, storage := newTestHandler(t)
+
+	file := &testFile{field: "file", name: "report.pdf", contentType: "application/pdf", content: "%PDF-1.4"}
+	resp := serve(handler, newMultipartRequest(t, nil, file))
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected 302, got %d", resp.StatusCode)
+	}
+	object := pasteCookie(t, resp)
+	if !strings.HasSuffix(object.RawKey, ".pdf") {
+		t.Fatalf("raw key %q does not keep the file extension", object.RawKey)
+	}
+
+	raw, rawInfo := readObject(t, storage, object.RawKey)
+	if raw != "%PDF-1.4" || rawInfo.ContentType != "application/pdf" {
+		t.Fatalf("unexpected raw object %q (%s)", raw, rawInfo.ContentType)
+	}
+	html, _ := readObject(t, storage, object.HtmlKey)
+	if !strings.Contains(html, "report.pdf") || !strings.Contains(html, "View file") {
+		t.Fatal("file download page does not describe the file")
+	}
+}
+
+func TestPostEmptyFormRedirectsToIndex(t *testing.T) {
+	handler, storage := newTestHandler(t)
+
+	resp := serve(handler, newMultipartRequest(t, map[string]string{"content": ""}, nil))
+
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/" {
+		t.Fatalf("expected redirect to index, got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if len(storage.objects) != 0 {
+		t.Fatalf("expected no stored objects, got %d", len(storage.objects))
+	}
+}
+
+func TestPostInvalidForm(t *testing.T) {
+	handler, _ := newTestHandler(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("garbage"))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=missing")
+	resp := serve(handler, req)
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "Invalid form") {
+		t.Fatalf("error page does not contain the message: %s", body)
+	}
+}
+
+func TestDeletePaste(t *testing.T) {
+	handler, storage := newTestHandler(t)
+	object := pasteCookie(t, serve(handler, newMultipartRequest(t, map[string]string{"content": "secret"}, nil)))
+
+	deleteURL := func(key string) string {
+		query := url.Values{"raw": {object.RawKey}, "html": {object.HtmlKey}, "key": {key}}
+		return "/?" + query.Encode()
+	}
+
+	resp := serve(handler, httptest.NewRequest(http.MethodDelete, deleteURL("wrong"), nil))
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 for a wrong delete key, got %d", resp.StatusCode)
+	}
+	if _, err := storage.HeadObject(context.Background(), object.RawKey); err != nil {
+		t.Fatalf("paste removed with a wrong delete key: %v", err)
+	}
+
+	resp = serve(handler, httptest.NewRequest(http.MethodDelete, deleteURL(object.DeleteKey), nil))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	for _, key := range []string{object.RawKey, object.HtmlKey} {
+		if _, err := storage.HeadObject(context.Background(), key); err == nil {
+			t.Fatalf("object %s still exists after delete", key)
+		}
+	}
+
+	resp = serve(handler, httptest.NewRequest(http.MethodDelete, deleteURL(object.DeleteKey), nil))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for an already deleted paste, got %d", resp.StatusCode)
+	}
+}
+
+func TestDeleteMissingParameters(t *testing.T) {
+	handler, _ := newTestHandler(t)
+
+	resp := serve(handler, httptest.NewRequest(http.MethodDelete, "/?raw=abc", nil))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestUnsupportedMethod(t *testing.T) {
+	handler, _ := newTestHandler(t)
+
+	resp := serve(handler, httptest.NewRequest(http.MethodPut, "/", nil))
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("expected html error page, got %q", ct)
+	}
+	if !strings.Contains(string(body), "Unsupported method") {
+		t.Fatalf("error page does not contain the message: %s", body)
+	}
+}
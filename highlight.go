@@ -3,12 +3,34 @@ package makaroni
 import (
 	"github.com/alecthomas/chroma/formatters/html"
 	"io"
+	"strings"
 
 	"github.com/alecthomas/chroma"
 	"github.com/alecthomas/chroma/lexers"
 	"github.com/alecthomas/chroma/styles"
+	log "github.com/sirupsen/logrus"
 )
 
+// maxHighlightSize is the largest content that gets highlighted, bigger content is shown as is
+const maxHighlightSize = 1024 * 100
+
+// highlightContent returns the HTML shown on the paste page for the content
+func highlightContent(content, lexer, style string) (string, error) {
+	// If content longer than 100 kilobytes, do not highlight it
+	if len(content) > maxHighlightSize {
+		log.Debugf("Content size more than 100kb: '%d' bytes, using pre tag", len(content))
+		return content, nil
+	}
+
+	log.Debugf("Content size: '%d' bytes, highlighting it", len(content))
+	highlightBuilder := strings.Builder{}
+	if err := highlight(&highlightBuilder, content, lexer, style); err != nil {
+		log.Error("Error highlighting content: ", err)
+		return "", err
+	}
+	return highlightBuilder.String(), nil
+}
+
 func highlight(w io.Writer, source, lexer, style string) error {
 	// Determine lexer.
 	l := lexers.Get(lexer)
@@ -0,0 +1,141 @@
+package makaroni
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	IDGeneratorUUID   = "uuid"
+	IDGeneratorBase62 = "base62"
+	IDGeneratorWords  = "words"
+
+	defaultBase62Length = 8
+	defaultWordsCount   = 4
+	base62Alphabet      = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// IDGenerator produces paste IDs used as storage keys
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+// NewIDGenerator creates the generator with the given name, length is the
+// number of characters for base62 and the number of words for word-based IDs
+func NewIDGenerator(name string, length int) (IDGenerator, error) {
+	switch name {
+	case "", IDGeneratorUUID:
+		return UUIDGenerator{}, nil
+	case IDGeneratorBase62:
+		if length <= 0 {
+			length = defaultBase62Length
+		}
+		return Base62Generator{Length: length}, nil
+	case IDGeneratorWords:
+		if length <= 0 {
+			length = defaultWordsCount
+		}
+		return WordsGenerator{Words: length}, nil
+	default:
+		return nil, fmt.Errorf("unknown id generator %q", name)
+	}
+}
+
+// UUIDGenerator produces random UUIDv4 IDs
+type UUIDGenerator struct{}
+
+// NewID returns a new UUIDv4 string
+func (UUIDGenerator) NewID() (string, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// Base62Generator produces random IDs of Length alphanumeric characters
+type Base62Generator struct {
+	Length int
+}
+
+// NewID returns a new random base62 string
+func (g Base62Generator) NewID() (string, error) {
+	var builder strings.Builder
+	for i := 0; i < g.Length; i++ {
+		index, err := randomIndex(len(base62Alphabet))
+		if err != nil {
+			return "", err
+		}
+		builder.WriteByte(base62Alphabet[index])
+	}
+	return builder.String(), nil
+}
+
+// WordsGenerator produces dash-separated IDs of random words, e.g. "gold-frog-tide-menu"
+type WordsGenerator struct {
+	Words int
+}
+
+// NewID returns a new word-based ID
+func (g WordsGenerator) NewID() (string, error) {
+	words := make([]string, g.Words)
+	for i := range words {
+		index, err := randomIndex(len(wordList))
+		if err != nil {
+			return "", err
+		}
+		words[i] = wordList[index]
+	}
+	return strings.Join(words, "-"), nil
+}
+
+// randomIndex returns a uniformly distributed random number in [0, n)
+func randomIndex(n int) (int, error) {
+	index, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate random number: %w", err)
+	}
+	return int(index.Int64()), nil
+}
+
+// wordList contains short, easy to spell words for word-based IDs, all of four lowercase letters
+var wordList = []string{
+	"able", "acid", "aged", "also", "area", "army", "away", "baby", "back", "ball", "band", "bank",
+	"base", "bath", "bear", "beat", "bell", "belt", "best", "bird", "blow", "blue", "boat", "body",
+	"bone", "book", "boot", "born", "boss", "both", "bowl", "bulk", "burn", "bush", "busy", "cake",
+	"call", "calm", "camp", "card", "care", "cart", "case", "cash", "cast", "cell", "chip", "city",
+	"clay", "club", "coal", "coat", "code", "cold", "cook", "cool", "cope", "copy", "core", "corn",
+	"cost", "crew", "crop", "dark", "data", "date", "dawn", "deal", "dear", "deep", "desk", "dial",
+	"diet", "disk", "dock", "door", "dose", "down", "draw", "drop", "drum", "dual", "duck", "dust",
+	"duty", "earn", "ease", "east", "easy", "edge", "else", "even", "ever", "exit", "face", "fact",
+	"fair", "fall", "farm", "fast", "fate", "fear", "feed", "feel", "fern", "file", "fill", "film",
+	"find", "fine", "fire", "firm", "fish", "five", "flag", "flat", "flow", "folk", "food", "foot",
+	"ford", "fork", "form", "fort", "four", "free", "frog", "fuel", "full", "fund", "gain", "game",
+	"gate", "gear", "gift", "girl", "give", "glad", "goal", "gold", "golf", "good", "gray", "grow",
+	"gulf", "hair", "half", "hall", "hand", "hard", "harm", "hawk", "head", "heat", "help", "herb",
+	"hero", "high", "hill", "hint", "hold", "hole", "home", "hook", "hope", "horn", "host", "hour",
+	"huge", "idea", "iron", "item", "jazz", "join", "jump", "jury", "keen", "keep", "kind", "king",
+	"kite", "knee", "knot", "lake", "lamp", "land", "lane", "last", "lava", "lawn", "lead", "leaf",
+	"lean", "left", "lens", "life", "lift", "like", "lime", "line", "link", "lion", "list", "live",
+	"load", "loan", "lock", "logo", "long", "loop", "lord", "loud", "love", "luck", "lung", "made",
+	"mail", "main", "make", "malt", "many", "mark", "mask", "mass", "meal", "meat", "menu", "mild",
+	"milk", "mill", "mind", "mint", "mode", "moon", "more", "moss", "most", "move", "much", "nail",
+	"name", "navy", "near", "neat", "neck", "need", "nest", "news", "next", "nice", "nine", "node",
+	"none", "noon", "nose", "note", "open", "oven", "pack", "page", "pain", "pair", "palm", "park",
+	"part", "past", "path", "peak", "pear", "pine", "pink", "pipe", "plan", "play", "plot", "plum",
+	"poem", "pole", "pond", "pool", "port", "post", "pure", "quiz", "race", "rail", "rain", "rank",
+	"rare", "read", "real", "reed", "rest", "rice", "rich", "ride", "ring", "rise", "road", "rock",
+	"roof", "room", "root", "rope", "rose", "ruby", "rule", "safe", "sage", "sail", "salt", "sand",
+	"save", "seal", "seed", "ship", "shoe", "shop", "show", "side", "sign", "silk", "sing", "site",
+	"size", "skin", "slow", "snow", "soft", "soil", "song", "soup", "star", "stem", "step", "such",
+	"suit", "sure", "swan", "tail", "tale", "tall", "tank", "tape", "task", "team", "tent", "term",
+	"test", "text", "tide", "tile", "time", "tiny", "tone", "tool", "tour", "town", "tree", "trip",
+	"tube", "tune", "turn", "twin", "type", "unit", "vast", "verb", "view", "vine", "void", "vote",
+	"wage", "wait", "walk", "wall", "warm", "wave", "weak", "wear", "week", "well", "west", "whip",
+	"wide", "wild", "will", "wind", "wine", "wing", "wire", "wise", "wish", "wolf", "wood", "wool",
+	"word", "work", "yard", "year", "yoga", "zero", "zinc", "zone",
+}
@@ -0,0 +1,86 @@
+package makaroni
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestIDGenerators(t *testing.T) {
+	tests := []struct {
+		name    string
+		length  int
+		pattern string
+	}{
+		{name: "", pattern: `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[0-9a-f]{4}-[0-9a-f]{12}$`},
+		{name: IDGeneratorBase62, pattern: `^[0-9A-Za-z]{8}$`},
+		{name: IDGeneratorBase62, length: 5, pattern: `^[0-9A-Za-z]{5}$`},
+		{name: IDGeneratorWords, pattern: `^[a-z]+(-[a-z]+){3}$`},
+		{name: IDGeneratorWords, length: 2, pattern: `^[a-z]+-[a-z]+$`},
+	}
+
+	for _, tt := range tests {
+		generator, err := NewIDGenerator(tt.name, tt.length)
+		if err != nil {
+			t.Fatalf("NewIDGenerator(%q): %v", tt.name, err)
+		}
+		id, err := generator.NewID()
+		if err != nil {
+			t.Fatalf("NewID: %v", err)
+		}
+		if !regexp.MustCompile(tt.pattern).MatchString(id) {
+			t.Errorf("generator %q length %d produced %q", tt.name, tt.length, id)
+		}
+	}
+
+	if _, err := NewIDGenerator("snowflake", 0); err == nil {
+		t.Fatal("expected an error for an unknown generator")
+	}
+}
+
+func TestWordList(t *testing.T) {
+	pattern := regexp.MustCompile(`^[a-z]{4}$`)
+	seen := make(map[string]bool, len(wordList))
+	for _, word := range wordList {
+		if !pattern.MatchString(word) {
+			t.Errorf("word %q is not four lowercase letters", word)
+		}
+		if seen[word] {
+			t.Errorf("word %q is listed twice", word)
+		}
+		seen[word] = true
+	}
+}
+
+// fixedGenerator returns the configured IDs in order
+type fixedGenerator struct {
+	ids []string
+}
+
+func (g *fixedGenerator) NewID() (string, error) {
+	id := g.ids[0]
+	if len(g.ids) > 1 {
+		g.ids = g.ids[1:]
+	}
+	return id, nil
+}
+
+func TestGenerateKeysSkipsTakenIDs(t *testing.T) {
+	handler, storage := newTestHandler(t)
+	ctx := context.Background()
+	if err := storage.UploadString(ctx, "taken.html", "", contentTypeHTML, nil); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	handler.IDGenerator = &fixedGenerator{ids: []string{"taken", "free"}}
+	keyRaw, keyHtml, _, err := handler.generateKeys(ctx, "")
+	if err != nil || keyRaw != "free" || keyHtml != "free.html" {
+		t.Fatalf("unexpected keys %q %q: %v", keyRaw, keyHtml, err)
+	}
+
+	handler.IDGenerator = &fixedGenerator{ids: []string{"taken"}}
+	if _, _, _, err := handler.generateKeys(ctx, ""); !errors.Is(err, ErrKeysExhausted) {
+		t.Fatalf("expected ErrKeysExhausted, got %v", err)
+	}
+}
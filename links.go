@@ -0,0 +1,68 @@
+package makaroni
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// presignedLinkPath is the prefix of the links that redirect to presigned storage URLs
+const presignedLinkPath = "/p/"
+
+// pasteURLs returns the public URLs of a paste page and its raw content. With presigned links
+// both go through makaroni, "/p/<id>" and "/p/<id>/raw", as storage URLs expire.
+func (p *PasteHandler) pasteURLs(rawKey, htmlKey string) (string, string) {
+	if p.Config.PresignedLinks {
+		link := strings.TrimSuffix(p.Config.IndexURL, "/") + presignedLinkPath + pasteID(htmlKey)
+		return link, link + "/raw"
+	}
+	return p.ResultURLPrefix + htmlKey, p.ResultURLPrefix + rawKey
+}
+
+// handlePresignedLink redirects to a freshly presigned URL of a paste page or its raw content.
+// Pastes with a view limit or a password are served by makaroni instead, a presigned URL could be reused
+// until it expires, and so is everything when the storage cannot presign.
+func (p *PasteHandler) handlePresignedLink(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	id, action, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, presignedLinkPath), "/")
+	if id == "" || isInternalKey(id) || (action != "" && action != "raw") {
+		p.RespondWithError(w, http.StatusNotFound, "Paste not found", p.Config)
+		return
+	}
+
+	key := id + ".html"
+	info, err := p.Storage.HeadObject(ctx, key)
+	if err == nil && action == "raw" {
+		key, _ = pasteKeys(key, info.Metadata)
+		info, err = p.Storage.HeadObject(ctx, key)
+	}
+
+	presigner, ok := p.Storage.(PresignStorage)
+	if !ok || err != nil || info.Metadata[maxViewsMetadataKey] != "" || isProtected(info.Metadata) {
+		// serveObject also reports missing, burned and expired pastes
+		p.serveObject(w, req, key, p.respondHTMLError)
+		return
+	}
+	if isExpired(info.Metadata, time.Now()) {
+		log.Info("Paste has expired: ", key)
+		p.RespondWithError(w, http.StatusGone, "Paste has expired", p.Config)
+		return
+	}
+
+	expire := p.Config.PresignExpire
+	if expire <= 0 {
+		expire = DefaultPresignExpire
+	}
+	signedURL, err := presigner.PresignGet(key, expire)
+	if err != nil {
+		log.Error("Error presigning paste link: ", err)
+		p.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve paste", p.Config)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	p.redirectToURL(w, req, signedURL)
+}
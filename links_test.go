@@ -0,0 +1,59 @@
+package makaroni
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPresignedLinks(t *testing.T) {
+	handler, storage := newDirectUploadHandler(t)
+	handler.Config.PresignedLinks = true
+
+	resp := serve(handler, newMultipartRequest(t, map[string]string{"content": "package main\n", "syntax": "go"}, nil))
+	object := pasteCookie(t, resp)
+	id := pasteID(object.HtmlKey)
+
+	if location := resp.Header.Get("Location"); location != "http://paste.test/p/"+id {
+		t.Fatalf("expected a redirect to the paste link, got %q", location)
+	}
+	if html, _ := readObject(t, storage, object.HtmlKey); !strings.Contains(html, "http://paste.test/p/"+id+"/raw") {
+		t.Fatal("raw button does not use the paste link")
+	}
+
+	for path, key := range map[string]string{"/p/" + id: object.HtmlKey, "/p/" + id + "/raw": object.RawKey} {
+		resp := serve(handler, httptest.NewRequest(http.MethodGet, path, nil))
+		if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "http://s3.test/bucket/"+key+"?signature=1" {
+			t.Fatalf("%s: expected a redirect to the presigned URL of %s, got %d %q", path, key, resp.StatusCode, resp.Header.Get("Location"))
+		}
+	}
+
+	for _, path := range []string{"/p/missing", "/p/" + id + "/other", "/p/.burned"} {
+		if resp := serve(handler, httptest.NewRequest(http.MethodGet, path, nil)); resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestPresignedLinksServeViewLimitedPastes(t *testing.T) {
+	handler, storage := newDirectUploadHandler(t)
+	handler.Config.PresignedLinks = true
+
+	file := &testFile{field: "file", name: "report.pdf", contentType: "application/pdf", content: "%PDF-1.4"}
+	object := pasteCookie(t, serve(handler, newMultipartRequest(t, map[string]string{"views": "1"}, file)))
+	id := pasteID(object.HtmlKey)
+
+	if html, _ := readObject(t, storage, object.HtmlKey); !strings.Contains(html, `href="http://paste.test/p/`+id+`/raw"`) {
+		t.Fatal("download link does not use the paste link")
+	}
+
+	// A presigned URL could be fetched again, so the only view is served directly
+	resp := serve(handler, httptest.NewRequest(http.MethodGet, "/p/"+id+"/raw", nil))
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/pdf" {
+		t.Fatalf("expected the file to be served, got %d", resp.StatusCode)
+	}
+	if resp := serve(handler, httptest.NewRequest(http.MethodGet, "/p/"+id+"/raw", nil)); resp.StatusCode != http.StatusGone {
+		t.Fatalf("expected 410 after the last view, got %d", resp.StatusCode)
+	}
+}
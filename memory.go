@@ -0,0 +1,184 @@
+package makaroni
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage is an in-memory implementation of Storage, mostly useful in tests
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string]*memoryObject
+}
+
+var _ Storage = (*MemoryStorage)(nil)
+
+// memoryObject is a single object kept by MemoryStorage
+type memoryObject struct {
+	data         []byte
+	contentType  string
+	etag         string
+	lastModified time.Time
+	metadata     map[string]string
+}
+
+// NewMemoryStorage creates an empty in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{objects: map[string]*memoryObject{}}
+}
+
+// UploadString stores string content in memory
+func (m *MemoryStorage) UploadString(ctx context.Context, key string, content string, contentType string, metadata map[string]string) error {
+	return m.UploadReader(ctx, key, strings.NewReader(content), contentType, metadata)
+}
+
+// UploadReader stores data read from reader in memory
+func (m *MemoryStorage) UploadReader(ctx context.Context, key string, reader io.Reader, contentType string, metadata map[string]string) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("upload failed for key %s: %w", key, err)
+	}
+
+	sum := md5.Sum(data)
+	object := &memoryObject{
+		data:         data,
+		contentType:  contentType,
+		etag:         `"` + hex.EncodeToString(sum[:]) + `"`,
+		lastModified: time.Now().UTC(),
+		metadata:     copyMetadata(metadata),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = object
+	return nil
+}
+
+// HeadObject returns object information and metadata
+func (m *MemoryStorage) HeadObject(ctx context.Context, key string) (*ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	object, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("head %s: %w", key, ErrObjectNotFound)
+	}
+	return object.info(key), nil
+}
+
+// GetMetadata returns the user metadata of an object
+func (m *MemoryStorage) GetMetadata(ctx context.Context, key string) (map[string]string, error) {
+	info, err := m.HeadObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return info.Metadata, nil
+}
+
+// UpdateMetadata replaces the user metadata of an object
+func (m *MemoryStorage) UpdateMetadata(ctx context.Context, key string, metadata map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	object, ok := m.objects[key]
+	if !ok {
+		return fmt.Errorf("update %s: %w", key, ErrObjectNotFound)
+	}
+	object.metadata = copyMetadata(metadata)
+	return nil
+}
+
+// GetObject returns a reader over the object content
+func (m *MemoryStorage) GetObject(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	object, ok := m.objects[key]
+	if !ok {
+		return nil, nil, fmt.Errorf("get %s: %w", key, ErrObjectNotFound)
+	}
+	return io.NopCloser(bytes.NewReader(object.data)), object.info(key), nil
+}
+
+// GetObjectRange returns a reader over a part of the object content
+func (m *MemoryStorage) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	object, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("get %s: %w", key, ErrObjectNotFound)
+	}
+
+	size := int64(len(object.data))
+	if offset > size {
+		offset = size
+	}
+	end := size
+	if length >= 0 && offset+length < size {
+		end = offset + length
+	}
+	return io.NopCloser(bytes.NewReader(object.data[offset:end])), nil
+}
+
+// DeleteObjects removes objects from memory, missing keys are ignored
+func (m *MemoryStorage) DeleteObjects(ctx context.Context, keys []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.objects, key)
+	}
+	return nil
+}
+
+// ListObjects calls fn for every stored object in lexical key order
+func (m *MemoryStorage) ListObjects(ctx context.Context, prefix string, fn ListFunc) error {
+	m.mu.RLock()
+	var infos []*ObjectInfo
+	for key, object := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			info := object.info(key)
+			info.Metadata = nil
+			infos = append(infos, info)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// info builds ObjectInfo with a private copy of the metadata
+func (o *memoryObject) info(key string) *ObjectInfo {
+	return &ObjectInfo{
+		Key:          key,
+		Size:         int64(len(o.data)),
+		ContentType:  o.contentType,
+		ETag:         o.etag,
+		LastModified: o.lastModified,
+		Metadata:     copyMetadata(o.metadata),
+	}
+}
+
+// copyMetadata returns a shallow copy of a metadata map
+func copyMetadata(metadata map[string]string) map[string]string {
+	result := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		result[k] = v
+	}
+	return result
+}
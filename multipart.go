@@ -0,0 +1,181 @@
+package makaroni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrUploadTooLarge = errors.New("upload is too large")
+	ErrLateFormField  = errors.New("paste option sent after the file")
+)
+
+// pasteFormFields are the multipart fields describing a paste, other fields are ignored
+var pasteFormFields = map[string]bool{
+	"content":   true,
+	"f":         true,
+	"syntax":    true,
+	"expire":    true,
+	"views":     true,
+	"burn":      true,
+	"slug":      true,
+	"encrypted": true,
+	"password":  true,
+}
+
+// maxSizeReader fails once more than limit bytes have been read, a negative limit disables the check
+type maxSizeReader struct {
+	reader   io.Reader
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+func (r *maxSizeReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	if r.limit >= 0 && r.read > r.limit {
+		r.exceeded = true
+		return n, ErrUploadTooLarge
+	}
+	return n, err
+}
+
+// streamMultipartPaste reads a multipart paste request part by part. Form fields are kept in memory,
+// up to MultipartMaxMemory in total, while a file part is streamed to storage as soon as it arrives.
+// When a file was uploaded the paste is already created and returned along with the request.
+func (p *PasteHandler) streamMultipartPaste(ctx context.Context, req *http.Request) (*pasteRequest, *pasteResult, error) {
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return nil, nil, &pasteError{http.StatusBadRequest, "Invalid form", err}
+	}
+
+	fieldsLimit := p.MultipartMaxMemory
+	if fieldsLimit <= 0 {
+		fieldsLimit = maxRawPasteSize
+	}
+
+	pr := &pasteRequest{}
+	values := map[string]string{}
+	var result *pasteResult
+
+	for {
+		part, err := reader.NextPart()
+		// Only a bare io.EOF marks the final boundary, a truncated body is a wrapped one
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			p.rollbackPaste(result)
+			return nil, nil, &pasteError{http.StatusBadRequest, "Invalid form", err}
+		}
+
+		name := part.FormName()
+		if name == "file" && part.FileName() != "" {
+			if result != nil {
+				p.rollbackPaste(result)
+				return nil, nil, &pasteError{http.StatusBadRequest, "Only one file can be uploaded", errors.New("second file part")}
+			}
+			if result, err = p.streamFilePart(ctx, pr, values, req, part); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if !pasteFormFields[name] {
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, fieldsLimit+1))
+		if err != nil {
+			p.rollbackPaste(result)
+			return nil, nil, &pasteError{http.StatusBadRequest, "Invalid form", err}
+		}
+		fieldsLimit -= int64(len(value))
+		if fieldsLimit < 0 {
+			p.rollbackPaste(result)
+			return nil, nil, &pasteError{http.StatusRequestEntityTooLarge, "Form is too large", ErrUploadTooLarge}
+		}
+
+		// The file has been stored with the options known at the time, later ones cannot be applied.
+		// Text content after a file is ignored, the file takes precedence.
+		if result != nil && len(value) > 0 && name != "content" && name != "f" {
+			p.rollbackPaste(result)
+			return nil, nil, &pasteError{http.StatusBadRequest, "Paste options must be sent before the file", fmt.Errorf("%w: %s", ErrLateFormField, name)}
+		}
+		if _, exists := values[name]; !exists {
+			values[name] = string(value)
+		}
+	}
+
+	if result == nil {
+		applyFormValues(pr, values, req)
+	}
+	return pr, result, nil
+}
+
+// streamFilePart creates a paste from the file part while it is being received
+func (p *PasteHandler) streamFilePart(ctx context.Context, pr *pasteRequest, values map[string]string, req *http.Request, part *multipart.Part) (*pasteResult, error) {
+	applyFormValues(pr, values, req)
+
+	limit := int64(-1)
+	if p.Config.MaxUploadSize > 0 {
+		limit = p.Config.MaxUploadSize
+	}
+	file := &maxSizeReader{reader: part, limit: limit}
+
+	pr.File = file
+	pr.FileName = part.FileName()
+	pr.FileType = part.Header.Get("Content-Type")
+	pr.FileSize = -1
+
+	log.Debug("Streaming file upload: ", pr.FileName)
+	result, err := p.createPaste(ctx, pr)
+	if file.exceeded {
+		log.Warn("Rejected file upload larger than ", p.Config.MaxUploadSize, " bytes")
+		return nil, &pasteError{http.StatusRequestEntityTooLarge, "File is too large", ErrUploadTooLarge}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result.Size = file.read
+	return result, nil
+}
+
+// rollbackPaste removes a paste that was stored before the rest of the form turned out to be invalid
+func (p *PasteHandler) rollbackPaste(result *pasteResult) {
+	if result == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+	if err := p.Storage.DeleteObjects(ctx, []string{result.RawKey, result.HtmlKey}); err != nil {
+		log.Error("Error rolling back paste: ", err)
+	}
+}
+
+// applyFormValues copies the collected form fields into the paste request.
+// The "f" field is accepted as an alias of "content" for sprunge-style clients.
+func applyFormValues(pr *pasteRequest, values map[string]string, req *http.Request) {
+	pr.Content = values["content"]
+	if pr.Content == "" {
+		pr.Content = values["f"]
+	}
+	pr.Syntax = values["syntax"]
+	if pr.Syntax == "" {
+		pr.Syntax = req.Header.Get(syntaxHeader)
+	}
+	pr.Expire = values["expire"]
+	pr.Views = values["views"]
+	pr.Burn = values["burn"]
+	pr.Slug = values["slug"]
+	pr.Encrypted = values["encrypted"]
+	pr.Password = values["password"]
+}
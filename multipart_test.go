@@ -0,0 +1,113 @@
+package makaroni
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// orderedPart is a multipart field or file written in a fixed position
+type orderedPart struct {
+	name     string
+	fileName string
+	value    string
+}
+
+// newOrderedMultipartRequest builds a browser-like POST request with the parts in the given order
+func newOrderedMultipartRequest(t *testing.T, parts ...orderedPart) *http.Request {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, part := range parts {
+		var err error
+		if part.fileName != "" {
+			var w io.Writer
+			if w, err = writer.CreateFormFile(part.name, part.fileName); err == nil {
+				_, err = w.Write([]byte(part.value))
+			}
+		} else {
+			err = writer.WriteField(part.name, part.value)
+		}
+		if err != nil {
+			t.Fatalf("write part: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close writer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Accept", "text/html")
+	return req
+}
+
+func TestStreamingUploadBrowserFieldOrder(t *testing.T) {
+	handler, storage := newTestHandler(t)
+
+	// The index form sends the options, then the file, then the empty text area
+	req := newOrderedMultipartRequest(t,
+		orderedPart{name: "syntax", value: "go"},
+		orderedPart{name: "expire", value: "1h"},
+		orderedPart{name: "file", fileName: "dump.core", value: strings.Repeat("x", 4096)},
+		orderedPart{name: "content", value: ""},
+	)
+	resp := serve(handler, req)
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected 302, got %d", resp.StatusCode)
+	}
+
+	object := pasteCookie(t, resp)
+	raw, info := readObject(t, storage, object.RawKey)
+	if len(raw) != 4096 || info.Metadata[expireMetadataKey] == "" {
+		t.Fatalf("unexpected stored file: %d bytes, metadata %v", len(raw), info.Metadata)
+	}
+}
+
+func TestStreamingUploadEmptyFileInput(t *testing.T) {
+	handler, storage := newTestHandler(t)
+
+	// Browsers send an empty file part when no file was chosen
+	req := newOrderedMultipartRequest(t,
+		orderedPart{name: "file", fileName: "", value: ""},
+		orderedPart{name: "content", value: "text"},
+	)
+	object := pasteCookie(t, serve(handler, req))
+	if raw, _ := readObject(t, storage, object.RawKey); raw != "text" {
+		t.Fatalf("unexpected raw content %q", raw)
+	}
+}
+
+func TestStreamingUploadRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		parts  []orderedPart
+		status int
+	}{
+		{"file too large", []orderedPart{{name: "file", fileName: "big.bin", value: strings.Repeat("x", 2048)}}, http.StatusRequestEntityTooLarge},
+		{"option after file", []orderedPart{{name: "file", fileName: "a.txt", value: "a"}, {name: "expire", value: "1h"}}, http.StatusBadRequest},
+		{"second file", []orderedPart{{name: "file", fileName: "a.txt", value: "a"}, {name: "file", fileName: "b.txt", value: "b"}}, http.StatusBadRequest},
+		{"fields too large", []orderedPart{{name: "content", value: strings.Repeat("x", 2048)}}, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, storage := newTestHandler(t)
+			handler.Config.MaxUploadSize = 1024
+			handler.MultipartMaxMemory = 1024
+
+			resp := serve(handler, newOrderedMultipartRequest(t, tt.parts...))
+			if resp.StatusCode != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, resp.StatusCode)
+			}
+			if len(storage.objects) != 0 {
+				t.Fatalf("expected no stored objects, got %d", len(storage.objects))
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package makaroni
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+)
+
+// multipartKeyPrefix holds the parts of emulated multipart uploads
+const multipartKeyPrefix = ".multipart/"
+
+// multipartFor returns the native multipart support of the storage, or an emulation built on plain objects
+func multipartFor(storage Storage) MultipartStorage {
+	if multipart, ok := storage.(MultipartStorage); ok {
+		return multipart
+	}
+	return &partStorage{storage: storage}
+}
+
+// partStorage emulates multipart uploads by storing every part as an internal object
+// and concatenating them into the final object on completion
+type partStorage struct {
+	storage Storage
+}
+
+// partUpload is the stored description of an emulated upload
+type partUpload struct {
+	Key         string
+	ContentType string
+	Metadata    map[string]string
+}
+
+func partUploadPrefix(uploadID string) string {
+	return multipartKeyPrefix + uploadID + "/"
+}
+
+func partKey(uploadID string, number int64) string {
+	return fmt.Sprintf("%s%05d", partUploadPrefix(uploadID), number)
+}
+
+func (s *partStorage) CreateMultipartUpload(ctx context.Context, key string, contentType string, metadata map[string]string) (string, error) {
+	uploadID, err := Base62Generator{Length: 24}.NewID()
+	if err != nil {
+		return "", err
+	}
+
+	info, err := json.Marshal(partUpload{Key: key, ContentType: contentType, Metadata: metadata})
+	if err != nil {
+		return "", err
+	}
+	if err := s.storage.UploadString(ctx, partUploadPrefix(uploadID)+"upload", string(info), contentTypeJSON, nil); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+func (s *partStorage) UploadPart(ctx context.Context, key string, uploadID string, number int64, data []byte) (string, error) {
+	if _, err := s.loadUpload(ctx, key, uploadID); err != nil {
+		return "", err
+	}
+	partKey := partKey(uploadID, number)
+	if err := s.storage.UploadReader(ctx, partKey, bytes.NewReader(data), "application/octet-stream", nil); err != nil {
+		return "", err
+	}
+	return partKey, nil
+}
+
+func (s *partStorage) CompleteMultipartUpload(ctx context.Context, key string, uploadID string, parts []CompletedPart) error {
+	upload, err := s.loadUpload(ctx, key, uploadID)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, len(parts))
+	for i, part := range parts {
+		keys[i] = partKey(uploadID, part.Number)
+	}
+	reader := &partsReader{ctx: ctx, storage: s.storage, keys: keys}
+	defer reader.Close()
+
+	if err := s.storage.UploadReader(ctx, upload.Key, reader, upload.ContentType, upload.Metadata); err != nil {
+		return err
+	}
+	return s.deleteUpload(ctx, uploadID)
+}
+
+func (s *partStorage) AbortMultipartUpload(ctx context.Context, key string, uploadID string) error {
+	return s.deleteUpload(ctx, uploadID)
+}
+
+// loadUpload reads the description of an upload and checks that it belongs to key
+func (s *partStorage) loadUpload(ctx context.Context, key string, uploadID string) (*partUpload, error) {
+	reader, _, err := s.storage.GetObject(ctx, partUploadPrefix(uploadID)+"upload")
+	if err != nil {
+		return nil, fmt.Errorf("multipart upload %s: %w", uploadID, err)
+	}
+	defer reader.Close()
+
+	upload := &partUpload{}
+	if err := json.NewDecoder(reader).Decode(upload); err != nil {
+		return nil, fmt.Errorf("multipart upload %s: %w", uploadID, err)
+	}
+	if upload.Key != key {
+		return nil, fmt.Errorf("multipart upload %s: %w", uploadID, ErrObjectNotFound)
+	}
+	return upload, nil
+}
+
+// deleteUpload removes the description and all parts of an upload
+func (s *partStorage) deleteUpload(ctx context.Context, uploadID string) error {
+	var keys []string
+	err := s.storage.ListObjects(ctx, partUploadPrefix(uploadID), func(info *ObjectInfo) error {
+		keys = append(keys, info.Key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.storage.DeleteObjects(ctx, keys)
+}
+
+// partsReader reads the part objects one after another, opening each only when it is reached
+type partsReader struct {
+	ctx     context.Context
+	storage Storage
+	keys    []string
+	current io.ReadCloser
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.keys) == 0 {
+				return 0, io.EOF
+			}
+			reader, _, err := r.storage.GetObject(r.ctx, r.keys[0])
+			if err != nil {
+				return 0, fmt.Errorf("part %s: %w", path.Base(r.keys[0]), err)
+			}
+			r.current, r.keys = reader, r.keys[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *partsReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	return r.current.Close()
+}
@@ -0,0 +1,302 @@
+package makaroni
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// passwordHashMetadataKey holds the bcrypt hash of the password protecting a paste
+	passwordHashMetadataKey = "password-hash"
+	// passwordHeader lets clients other than browsers send the password with every request
+	passwordHeader = "X-Paste-Password"
+	// unlockPath receives the password prompt, "/unlock/<id>"
+	unlockPath = "/unlock/"
+
+	passwordCookiePrefix  = "paste_access_"
+	passwordCookieMaxAge  = 24 * 60 * 60
+	maxPasswordLength     = 72 // bcrypt ignores anything longer
+	maxPasswordAttempts   = 5  // Failed attempts of one client on a paste per window
+	maxPasteAttempts      = 50 // Failed attempts of all clients together on a paste per window
+	passwordAttemptWindow = 15 * time.Minute
+)
+
+var (
+	ErrPasswordTooLong = errors.New("password is too long")
+	ErrInvalidPassword = errors.New("invalid password")
+	ErrTooManyAttempts = errors.New("too many failed password attempts")
+)
+
+// hashPassword returns the bcrypt hash of a paste password
+func hashPassword(password string) (string, error) {
+	if len(password) > maxPasswordLength {
+		return "", fmt.Errorf("%w: more than %d bytes", ErrPasswordTooLong, maxPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// isProtected reports whether a paste object requires a password
+func isProtected(metadata map[string]string) bool {
+	return metadata[passwordHashMetadataKey] != ""
+}
+
+// accessToken proves the password of a paste was given. It is derived from the stored hash,
+// which never leaves the server, so it cannot be forged and stops working if the hash changes.
+func accessToken(id string, metadata map[string]string) string {
+	mac := hmac.New(sha256.New, []byte(metadata[passwordHashMetadataKey]))
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// attemptLimiter counts failed password attempts per key in fixed windows, the zero value is ready to use.
+// The counts live in the memory of one instance, each replica behind a load balancer keeps its own.
+type attemptLimiter struct {
+	mu       sync.Mutex
+	failures map[string]*attemptWindow
+}
+
+type attemptWindow struct {
+	start time.Time
+	count int
+}
+
+// retryAfter returns how long the key is blocked after limit failures, zero when another attempt is allowed
+func (l *attemptLimiter) retryAfter(key string, limit int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	window, ok := l.failures[key]
+	if !ok || now.Sub(window.start) >= passwordAttemptWindow {
+		return 0
+	}
+	if window.count < limit {
+		return 0
+	}
+	return window.start.Add(passwordAttemptWindow).Sub(now)
+}
+
+// fail records a failed attempt
+func (l *attemptLimiter) fail(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.failures == nil {
+		l.failures = make(map[string]*attemptWindow)
+	}
+	window, ok := l.failures[key]
+	if !ok || now.Sub(window.start) >= passwordAttemptWindow {
+		// Drop finished windows now and then, so abandoned keys do not pile up
+		if len(l.failures) >= 1024 {
+			for k, w := range l.failures {
+				if now.Sub(w.start) >= passwordAttemptWindow {
+					delete(l.failures, k)
+				}
+			}
+		}
+		window = &attemptWindow{start: now}
+		l.failures[key] = window
+	}
+	window.count++
+}
+
+// clientAddress returns the address of the client sending the request. Behind a reverse proxy the
+// address is taken from the last entry of the configured header, which the trusted proxy appends itself,
+// so entries sent by the client cannot spoof it.
+func clientAddress(req *http.Request, header string) string {
+	if header != "" {
+		if values := req.Header.Values(header); len(values) > 0 {
+			entries := strings.Split(values[len(values)-1], ",")
+			if address := strings.TrimSpace(entries[len(entries)-1]); address != "" {
+				return address
+			}
+		}
+	}
+	address, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return address
+}
+
+// attemptKey identifies the client trying passwords for the paste, so a client guessing wrong does not
+// lock everyone else out of the paste. The paste ID alone counts the failures of all clients together.
+func (p *PasteHandler) attemptKey(req *http.Request, id string) string {
+	return id + " " + clientAddress(req, p.Config.TrustedProxyHeader)
+}
+
+// attemptWait returns how long password attempts for the paste are blocked for the client
+func (p *PasteHandler) attemptWait(req *http.Request, id string, now time.Time) time.Duration {
+	wait := p.passwordAttempts.retryAfter(p.attemptKey(req, id), maxPasswordAttempts, now)
+	if pasteWait := p.passwordAttempts.retryAfter(id, maxPasteAttempts, now); pasteWait > wait {
+		wait = pasteWait
+	}
+	return wait
+}
+
+// checkPassword verifies a password for the paste, counting failures against the paste and the client
+func (p *PasteHandler) checkPassword(req *http.Request, id string, metadata map[string]string, password string) error {
+	now := time.Now()
+	if p.attemptWait(req, id, now) > 0 {
+		return ErrTooManyAttempts
+	}
+	if bcrypt.CompareHashAndPassword([]byte(metadata[passwordHashMetadataKey]), []byte(password)) != nil {
+		p.passwordAttempts.fail(p.attemptKey(req, id), now)
+		p.passwordAttempts.fail(id, now)
+		log.Warn("Wrong password for paste: ", id)
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+// setRetryAfter tells the client when its next password attempt for the paste is allowed
+func (p *PasteHandler) setRetryAfter(w http.ResponseWriter, req *http.Request, id string) {
+	wait := p.attemptWait(req, id, time.Now())
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second).Seconds())+1))
+}
+
+// authorizePaste checks that the request may read a password-protected paste, from the access cookie
+// set by the prompt or the X-Paste-Password header. Browsers get the password prompt, other clients an error.
+func (p *PasteHandler) authorizePaste(w http.ResponseWriter, req *http.Request, key string, metadata map[string]string, fail errorResponder) bool {
+	_, htmlKey := pasteKeys(key, metadata)
+	id := pasteID(htmlKey)
+
+	if cookie, err := req.Cookie(passwordCookiePrefix + id); err == nil &&
+		hmac.Equal([]byte(cookie.Value), []byte(accessToken(id, metadata))) {
+		return true
+	}
+
+	password := req.Header.Get(passwordHeader)
+	if password == "" {
+		if strings.HasPrefix(req.URL.Path, apiPastesPath) || !strings.Contains(req.Header.Get("Accept"), contentTypeHTML) {
+			fail(w, http.StatusUnauthorized, "This paste is protected by a password")
+			return false
+		}
+		p.respondPasswordPrompt(w, http.StatusUnauthorized, id, req.URL.RequestURI(), "")
+		return false
+	}
+
+	switch err := p.checkPassword(req, id, metadata, password); {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrTooManyAttempts):
+		p.setRetryAfter(w, req, id)
+		fail(w, http.StatusTooManyRequests, "Too many wrong passwords, try again later")
+	default:
+		fail(w, http.StatusForbidden, "Wrong password")
+	}
+	return false
+}
+
+// handleUnlock checks the password submitted by the prompt, sets the access cookie and returns to the paste
+func (p *PasteHandler) handleUnlock(w http.ResponseWriter, req *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(req.URL.Path, unlockPath), "/")
+	if id == "" || isInternalKey(id) || strings.Contains(id, "/") {
+		p.RespondWithError(w, http.StatusNotFound, "Paste not found", p.Config)
+		return
+	}
+	if req.Method != http.MethodPost {
+		p.RespondWithError(w, http.StatusMethodNotAllowed, "Unsupported method", p.Config)
+		return
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, 4096)
+	if err := req.ParseForm(); err != nil {
+		p.RespondWithError(w, http.StatusBadRequest, "Invalid form", p.Config)
+		return
+	}
+	next := req.PostForm.Get("next")
+	if !isLocalPath(next) {
+		next = "/" + id + ".html"
+	}
+
+	metadata, err := p.Storage.GetMetadata(req.Context(), id+".html")
+	if errors.Is(err, ErrObjectNotFound) {
+		p.RespondWithError(w, http.StatusNotFound, "Paste not found", p.Config)
+		return
+	}
+	if err != nil {
+		log.Error("Error retrieving paste info: ", err)
+		p.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve paste", p.Config)
+		return
+	}
+	if !isProtected(metadata) {
+		p.redirectToURL(w, req, next)
+		return
+	}
+
+	switch err := p.checkPassword(req, id, metadata, req.PostForm.Get("password")); {
+	case errors.Is(err, ErrTooManyAttempts):
+		p.setRetryAfter(w, req, id)
+		p.RespondWithError(w, http.StatusTooManyRequests, "Too many wrong passwords, try again later", p.Config)
+		return
+	case err != nil:
+		p.respondPasswordPrompt(w, http.StatusForbidden, id, next, "Wrong password")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     passwordCookiePrefix + id,
+		Value:    accessToken(id, metadata),
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   passwordCookieMaxAge,
+	})
+	http.Redirect(w, req, next, http.StatusSeeOther)
+}
+
+// isLocalPath reports whether next is a path on this site the prompt may return to. Browsers drop tabs
+// and newlines and read backslashes as slashes, so anything that could turn into another host is refused.
+func isLocalPath(next string) bool {
+	if strings.IndexFunc(next, unicode.IsControl) >= 0 || strings.Contains(next, "\\") {
+		return false
+	}
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil || u.Opaque != "" {
+		return false
+	}
+	return strings.HasPrefix(u.Path, "/") && !strings.HasPrefix(u.Path, "//")
+}
+
+// respondPasswordPrompt sends the password prompt of a protected paste, next is where it returns to
+func (p *PasteHandler) respondPasswordPrompt(w http.ResponseWriter, statusCode int, id, next, message string) {
+	html, err := RenderPasswordPrompt(PasswordData{
+		LogoURL:    p.Config.LogoURL,
+		IndexURL:   p.Config.IndexURL,
+		FaviconURL: p.Config.FaviconURL,
+		ActionURL:  strings.TrimSuffix(p.Config.IndexURL, "/") + unlockPath + id,
+		Next:       next,
+		Message:    message,
+	})
+	if err != nil {
+		log.Error("Error rendering password prompt: ", err)
+		p.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve paste", p.Config)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(html); err != nil {
+		log.Error("Error writing password prompt: ", err)
+	}
+}
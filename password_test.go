@@ -0,0 +1,191 @@
+package makaroni
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unlockRequest submits the password prompt of a paste
+func unlockRequest(id, password, next string) *http.Request {
+	form := url.Values{"password": {password}, "next": {next}}
+	req := httptest.NewRequest(http.MethodPost, unlockPath+id, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+// browserRequest builds a GET request for a page as a browser sends it
+func browserRequest(path string, cookies ...*http.Cookie) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	return req
+}
+
+func TestPasswordProtectedPaste(t *testing.T) {
+	handler, storage := newTestHandler(t)
+
+	fields := map[string]string{"content": "stack trace", "password": "s3cret", "views": "3"}
+	object := pasteCookie(t, serve(handler, newMultipartRequest(t, fields, nil)))
+	id := pasteID(object.HtmlKey)
+
+	_, info := readObject(t, storage, object.RawKey)
+	if !isProtected(info.Metadata) || strings.Contains(info.Metadata[passwordHashMetadataKey], "s3cret") {
+		t.Fatalf("password is not stored as a hash: %v", info.Metadata)
+	}
+
+	resp := serve(handler, browserRequest("/"+object.HtmlKey))
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusUnauthorized || !strings.Contains(string(body), `action="http://paste.test/unlock/`+id+`"`) {
+		t.Fatalf("expected the password prompt, got %d", resp.StatusCode)
+	}
+	if strings.Contains(string(body), "stack trace") {
+		t.Fatal("prompt leaks the paste")
+	}
+
+	resp = serve(handler, unlockRequest(id, "wrong", "/"+object.HtmlKey))
+	if resp.StatusCode != http.StatusForbidden || len(resp.Cookies()) != 0 {
+		t.Fatalf("expected 403 for a wrong password, got %d", resp.StatusCode)
+	}
+
+	resp = serve(handler, unlockRequest(id, "s3cret", "/"+object.HtmlKey))
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/"+object.HtmlKey || len(resp.Cookies()) != 1 {
+		t.Fatalf("expected a redirect back to the paste, got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	cookie := resp.Cookies()[0]
+
+	// The prompt does not use up views
+	for _, key := range []string{object.HtmlKey, object.RawKey} {
+		if resp := serve(handler, browserRequest("/"+key, cookie)); resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected 200 with the access cookie, got %d", key, resp.StatusCode)
+		}
+	}
+
+	forged := &http.Cookie{Name: cookie.Name, Value: strings.Repeat("0", len(cookie.Value))}
+	if resp := serve(handler, browserRequest("/"+object.RawKey, forged)); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a forged cookie to be rejected, got %d", resp.StatusCode)
+	}
+
+	for _, next := range []string{"//evil.test/", "/\\evil.test/", "/\t/evil.test/", "/\n/evil.test/", "https://evil.test/", "/%2F/evil.test/", "evil.test"} {
+		if resp := serve(handler, unlockRequest(id, "s3cret", next)); resp.Header.Get("Location") != "/"+id+".html" {
+			t.Fatalf("unlock with next %q redirects to %q", next, resp.Header.Get("Location"))
+		}
+	}
+}
+
+func TestPasswordProtectedPasteAPI(t *testing.T) {
+	handler, _ := newTestHandler(t)
+
+	paste := createAPIPaste(t, handler, `{"content": "dump", "password": "s3cret"}`)
+	if !paste.Protected {
+		t.Fatalf("created paste is not reported as protected: %+v", paste)
+	}
+
+	rawPath := apiPastesPath + "/" + paste.ID + "/raw"
+	decodeAPIError(t, serve(handler, httptest.NewRequest(http.MethodGet, rawPath, nil)), http.StatusUnauthorized)
+
+	req := httptest.NewRequest(http.MethodGet, rawPath, nil)
+	req.Header.Set(passwordHeader, "s3cret")
+	resp := serve(handler, req)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "dump" {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, apiPastesPath, strings.NewReader(`{"content": "x", "password": "`+strings.Repeat("a", maxPasswordLength+1)+`"}`))
+	decodeAPIError(t, serve(handler, req), http.StatusBadRequest)
+}
+
+func TestPasswordAttemptsAreLimited(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	paste := createAPIPaste(t, handler, `{"content": "dump", "password": "s3cret"}`)
+
+	for i := 0; i < maxPasswordAttempts; i++ {
+		if resp := serve(handler, unlockRequest(paste.ID, "guess", "")); resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("attempt %d: expected 403, got %d", i, resp.StatusCode)
+		}
+	}
+
+	resp := serve(handler, unlockRequest(paste.ID, "s3cret", ""))
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected 429 after too many attempts, got %d", resp.StatusCode)
+	}
+	req := httptest.NewRequest(http.MethodGet, apiPastesPath+"/"+paste.ID+"/raw", nil)
+	req.Header.Set(passwordHeader, "s3cret")
+	decodeAPIError(t, serve(handler, req), http.StatusTooManyRequests)
+
+	// Other clients and other pastes are not affected
+	req = unlockRequest(paste.ID, "s3cret", "")
+	req.RemoteAddr = "192.0.2.2:1234"
+	if resp := serve(handler, req); resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected another client to unlock the paste, got %d", resp.StatusCode)
+	}
+	other := createAPIPaste(t, handler, `{"content": "dump", "password": "s3cret"}`)
+	if resp := serve(handler, unlockRequest(other.ID, "s3cret", "")); resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the other paste to unlock, got %d", resp.StatusCode)
+	}
+}
+
+func TestPasswordAttemptsArePerPasteCapped(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	paste := createAPIPaste(t, handler, `{"content": "dump", "password": "s3cret"}`)
+
+	now := time.Now()
+	for i := 0; i < maxPasteAttempts; i++ {
+		handler.passwordAttempts.fail(paste.ID, now)
+	}
+
+	req := unlockRequest(paste.ID, "s3cret", "")
+	req.RemoteAddr = "192.0.2.3:1234"
+	if resp := serve(handler, req); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected a fresh client to be refused once the paste is capped, got %d", resp.StatusCode)
+	}
+}
+
+func TestClientAddress(t *testing.T) {
+	tests := []struct {
+		header    string
+		forwarded []string
+		expected  string
+	}{
+		{"", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"X-Forwarded-For", nil, "192.0.2.1"},
+		{"X-Forwarded-For", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"X-Forwarded-For", []string{"203.0.113.9, 198.51.100.1"}, "198.51.100.1"},
+		{"X-Forwarded-For", []string{"203.0.113.9", "198.51.100.1"}, "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, value := range tt.forwarded {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		if address := clientAddress(req, tt.header); address != tt.expected {
+			t.Errorf("clientAddress(%q, %q) = %q, expected %q", tt.header, tt.forwarded, address, tt.expected)
+		}
+	}
+}
+
+func TestPasswordProtectedTusUploadKeepsOnlyTheHash(t *testing.T) {
+	handler, storage := newTestHandler(t)
+
+	password := base64.StdEncoding.EncodeToString([]byte("s3cret"))
+	filename := base64.StdEncoding.EncodeToString([]byte("dump.bin"))
+	path, _ := createTusUpload(t, handler, 4, "filename "+filename+",password "+password)
+
+	resp := serve(handler, newTusRequest(http.MethodHead, path, ""))
+	if metadata := resp.Header.Get("Upload-Metadata"); metadata != "filename "+filename {
+		t.Fatalf("unexpected Upload-Metadata %q", metadata)
+	}
+	for _, key := range storedKeys(t, storage, tusKeyPrefix) {
+		if state, _ := readObject(t, storage, key); strings.Contains(state, password) || strings.Contains(state, "s3cret") {
+			t.Fatalf("%s holds the plaintext password", key)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package makaroni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rollbackTimeout bounds the cleanup of a partially stored paste
+const rollbackTimeout = 30 * time.Second
+
+// pasteRequest holds everything needed to create a paste, independent of how it was submitted
+type pasteRequest struct {
+	Content string
+
+	File     io.Reader // Set for file uploads instead of Content
+	FileName string
+	FileType string
+	FileSize int64 // Reported size, -1 when unknown
+
+	Syntax string
+	Expire string
+	Views  string
+	Burn   string
+	Slug   string
+
+	Encrypted string // Content is an EncryptedPaste sealed by the client
+	Password  string // Required to view the paste, only its hash is stored
+}
+
+// empty reports whether the request carries neither text nor a file
+func (r *pasteRequest) empty() bool {
+	return r.File == nil && len(r.Content) == 0
+}
+
+// pasteResult describes a created paste
+type pasteResult struct {
+	RawKey    string
+	HtmlKey   string
+	DeleteKey string
+	URL       string
+	RawURL    string
+	Size      int64 // Size of the raw content, -1 when unknown
+	MaxViews  int
+	Expire    time.Time // Zero when the paste never expires
+	Encrypted bool
+	Protected bool // A password is required to view the paste
+}
+
+// pasteError carries the HTTP status and user-facing message of a failed paste operation
+type pasteError struct {
+	status  int
+	message string
+	err     error
+}
+
+func (e *pasteError) Error() string {
+	return fmt.Sprintf("%s: %v", e.message, e.err)
+}
+
+func (e *pasteError) Unwrap() error {
+	return e.err
+}
+
+// pasteErrorStatus returns the HTTP status and message to report for a paste error
+func pasteErrorStatus(err error) (int, string) {
+	var pe *pasteError
+	if errors.As(err, &pe) {
+		return pe.status, pe.message
+	}
+	return http.StatusInternalServerError, "Failed to create paste"
+}
+
+// isFile reports whether the request creates a downloadable file paste rather than a highlighted one
+func (r *pasteRequest) isFile() bool {
+	return r.File != nil || r.FileName != ""
+}
+
+// normalizeFile cleans up a client supplied file name and fills in a missing name or content type
+func (r *pasteRequest) normalizeFile() {
+	r.FileName = filepath.Base(r.FileName)
+	if r.FileName == "." || r.FileName == string(filepath.Separator) {
+		r.FileName = "upload"
+	}
+	if r.FileType == "" {
+		r.FileType = mime.TypeByExtension(filepath.Ext(r.FileName))
+	}
+	if r.FileType == "" {
+		r.FileType = "application/octet-stream"
+	}
+}
+
+// pastePlan is a paste whose keys, metadata and page are decided, but nothing is stored yet
+type pastePlan struct {
+	Result       *pasteResult
+	HTML         string
+	ContentType  string // Content type of the raw object
+	RawMetadata  map[string]string
+	HtmlMetadata map[string]string
+}
+
+// createPaste stores the raw content and its rendered HTML page
+func (p *PasteHandler) createPaste(ctx context.Context, pr *pasteRequest) (*pasteResult, error) {
+	plan, err := p.planPaste(ctx, pr)
+	if err != nil {
+		return nil, err
+	}
+	keyRaw, keyHtml := plan.Result.RawKey, plan.Result.HtmlKey
+
+	uploadRaw := func(ctx context.Context) error {
+		if pr.File != nil {
+			return p.Storage.UploadReader(ctx, keyRaw, pr.File, plan.ContentType, plan.RawMetadata)
+		}
+		return p.Storage.UploadString(ctx, keyRaw, pr.Content, plan.ContentType, plan.RawMetadata)
+	}
+	uploadHtml := func(ctx context.Context) error {
+		return p.Storage.UploadString(ctx, keyHtml, plan.HTML, contentTypeHTML, plan.HtmlMetadata)
+	}
+
+	if err := p.storeObjects(ctx, map[string]uploadFunc{keyRaw: uploadRaw, keyHtml: uploadHtml}); err != nil {
+		log.Error("Error storing paste: ", err)
+		return nil, &pasteError{http.StatusInternalServerError, "Failed to upload paste", err}
+	}
+
+	log.Info("Uploaded raw content with key: ", keyRaw)
+	log.Info("Uploaded HTML content with key: ", keyHtml)
+	return plan.Result, nil
+}
+
+// planPaste generates the keys of a new paste, validates its options and renders its page
+func (p *PasteHandler) planPaste(ctx context.Context, pr *pasteRequest) (*pastePlan, error) {
+	keyRaw, keyHtml, keyDelete, err := p.generateKeys(ctx, strings.TrimSpace(pr.Slug))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidSlug), errors.Is(err, ErrReservedSlug):
+			log.Warn("Rejected slug: ", err)
+			return nil, &pasteError{http.StatusBadRequest, "Invalid slug: " + err.Error(), err}
+		case errors.Is(err, ErrSlugTaken):
+			return nil, &pasteError{http.StatusConflict, "This slug is already taken", err}
+		default:
+			return nil, &pasteError{http.StatusInternalServerError, "Failed to generate keys", err}
+		}
+	}
+
+	deleteHash, err := hashDeleteKey(keyDelete)
+	if err != nil {
+		log.Error("Error hashing delete key: ", err)
+		return nil, &pasteError{http.StatusInternalServerError, "Failed to generate keys", err}
+	}
+
+	// Only the hash is stored, the delete key itself is handed to the client
+	metadata := map[string]string{
+		deleteHashMetadataKey: deleteHash,
+	}
+	result := &pasteResult{HtmlKey: keyHtml, DeleteKey: keyDelete}
+
+	ttl, err := expireTTL(pr.Expire, p.Config)
+	if err != nil {
+		log.Warn("Invalid expiration: ", err)
+		return nil, &pasteError{http.StatusBadRequest, "Invalid expiration", err}
+	}
+	if ttl > 0 {
+		result.Expire = time.Now().UTC().Add(ttl).Truncate(time.Second)
+		metadata[expireMetadataKey] = result.Expire.Format(time.RFC3339)
+	}
+
+	result.MaxViews, err = parseMaxViews(pr.Views, pr.Burn)
+	if err != nil {
+		log.Warn("Invalid view limit: ", err)
+		return nil, &pasteError{http.StatusBadRequest, "Invalid view limit", err}
+	}
+	if result.MaxViews > 0 {
+		metadata[maxViewsMetadataKey] = strconv.Itoa(result.MaxViews)
+	}
+
+	if pr.Password != "" {
+		passwordHash, err := hashPassword(pr.Password)
+		if errors.Is(err, ErrPasswordTooLong) {
+			return nil, &pasteError{http.StatusBadRequest, "Password is too long", err}
+		}
+		if err != nil {
+			log.Error("Error hashing password: ", err)
+			return nil, &pasteError{http.StatusInternalServerError, "Failed to process upload", err}
+		}
+		metadata[passwordHashMetadataKey] = passwordHash
+		result.Protected = true
+	}
+
+	contentType := contentTypeText
+	if pr.isEncrypted() {
+		if pr.isFile() {
+			return nil, &pasteError{http.StatusBadRequest, "Only text pastes can be encrypted", ErrInvalidEncryptedPaste}
+		}
+		// The server only checks the envelope, the content stays unreadable to it
+		if _, pr.Content, err = parseEncryptedPaste(pr.Content); err != nil {
+			log.Warn("Rejected encrypted paste: ", err)
+			return nil, &pasteError{http.StatusBadRequest, "Invalid encrypted paste", err}
+		}
+		metadata[encryptedMetadataKey] = strconv.Itoa(encryptedPasteVersion)
+		result.Encrypted = true
+		contentType = contentTypeJSON
+	}
+
+	if pr.isFile() {
+		metadata[filenameMetadataKey] = url.PathEscape(pr.FileName)
+		result.Size = pr.FileSize
+		contentType = pr.FileType
+	} else {
+		if pr.Syntax != "" {
+			metadata[syntaxMetadataKey] = pr.Syntax
+		}
+		result.Size = int64(len(pr.Content))
+	}
+
+	// The page is rendered before anything is written, so rendering errors leave nothing behind
+	var html string
+	_, rawURL := p.pasteURLs(keyRaw, keyHtml)
+	switch {
+	case pr.isFile():
+		html, keyRaw, err = p.renderFilePage(pr, keyRaw)
+	case result.Encrypted:
+		html, err = p.renderEncryptedPage(pr)
+	default:
+		html, err = p.renderTextPage(pr, rawURL)
+	}
+	if err != nil {
+		return nil, &pasteError{http.StatusInternalServerError, "Failed to process upload", err}
+	}
+
+	rawMetadata := copyMetadata(metadata)
+	rawMetadata[htmlKeyMetadataKey] = keyHtml
+	htmlMetadata := copyMetadata(metadata)
+	htmlMetadata[rawKeyMetadataKey] = keyRaw
+
+	result.RawKey = keyRaw
+	result.URL, result.RawURL = p.pasteURLs(keyRaw, keyHtml)
+	return &pastePlan{Result: result, HTML: html, ContentType: contentType, RawMetadata: rawMetadata, HtmlMetadata: htmlMetadata}, nil
+}
+
+// uploadFunc writes one object of a paste
+type uploadFunc func(ctx context.Context) error
+
+// storeObjects runs the uploads concurrently. If any of them fails, the others are cancelled
+// and the objects that were written are deleted again, so a paste is stored completely or not at all.
+func (p *PasteHandler) storeObjects(ctx context.Context, uploads map[string]uploadFunc) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type uploadResult struct {
+		key string
+		err error
+	}
+	results := make(chan uploadResult, len(uploads))
+	for key, upload := range uploads {
+		go func(key string, upload uploadFunc) {
+			results <- uploadResult{key: key, err: upload(ctx)}
+		}(key, upload)
+	}
+
+	var written []string
+	var firstErr error
+	for range uploads {
+		result := <-results
+		if result.err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("upload %s: %w", result.key, result.err)
+				cancel()
+			}
+			continue
+		}
+		written = append(written, result.key)
+	}
+
+	if firstErr != nil && len(written) > 0 {
+		// The request context may already be gone, the rollback must run regardless
+		rollbackCtx, rollbackCancel := context.WithTimeout(context.Background(), rollbackTimeout)
+		defer rollbackCancel()
+		if err := p.Storage.DeleteObjects(rollbackCtx, written); err != nil {
+			log.Error("Error rolling back partially stored paste: ", err)
+		} else {
+			log.Info("Rolled back partially stored paste: ", written)
+		}
+	}
+	return firstErr
+}
+
+// deleteURL returns the URL that removes the paste with a DELETE request
+func (p *PasteHandler) deleteURL(result *pasteResult) string {
+	query := url.Values{
+		"raw":  {result.RawKey},
+		"html": {result.HtmlKey},
+		"key":  {result.DeleteKey},
+	}
+	return strings.TrimSuffix(p.Config.IndexURL, "/") + "/?" + query.Encode()
+}
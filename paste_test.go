@@ -0,0 +1,73 @@
+package makaroni
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// failingStorage fails uploads of keys matching the predicate
+type failingStorage struct {
+	*MemoryStorage
+	fail func(key string) bool
+}
+
+func (s *failingStorage) UploadString(ctx context.Context, key, content, contentType string, metadata map[string]string) error {
+	if s.fail(key) {
+		return errors.New("upload failed")
+	}
+	return s.MemoryStorage.UploadString(ctx, key, content, contentType, metadata)
+}
+
+func (s *failingStorage) UploadReader(ctx context.Context, key string, reader io.Reader, contentType string, metadata map[string]string) error {
+	if s.fail(key) {
+		return errors.New("upload failed")
+	}
+	return s.MemoryStorage.UploadReader(ctx, key, reader, contentType, metadata)
+}
+
+func (s *failingStorage) UpdateMetadata(ctx context.Context, key string, metadata map[string]string) error {
+	if s.fail(key) {
+		return errors.New("update failed")
+	}
+	return s.MemoryStorage.UpdateMetadata(ctx, key, metadata)
+}
+
+func TestCreatePasteRollsBack(t *testing.T) {
+	tests := []struct {
+		name string
+		fail func(key string) bool
+		file *testFile
+	}{
+		{"html upload fails", func(key string) bool { return strings.HasSuffix(key, ".html") }, nil},
+		{"raw upload fails", func(key string) bool { return !strings.HasSuffix(key, ".html") }, nil},
+		{"file upload fails", func(key string) bool { return strings.HasSuffix(key, ".bin") },
+			&testFile{field: "file", name: "data.bin", contentType: "application/octet-stream", content: "data"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, memory := newTestHandler(t)
+			handler.Storage = &failingStorage{MemoryStorage: memory, fail: tt.fail}
+
+			fields := map[string]string{"content": "text"}
+			if tt.file != nil {
+				fields = nil
+			}
+			resp := serve(handler, newMultipartRequest(t, fields, tt.file))
+
+			if resp.StatusCode != http.StatusInternalServerError {
+				t.Fatalf("expected 500, got %d", resp.StatusCode)
+			}
+			if len(resp.Cookies()) != 0 {
+				t.Fatal("cookie set for a failed paste")
+			}
+			if len(memory.objects) != 0 {
+				t.Fatalf("expected no stored objects after rollback, got %d", len(memory.objects))
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// The paste is encrypted in the browser that created it, the key only lives in the URL fragment
+function base64ToBytes(value) {
+    value = value.replace(/-/g, '+').replace(/_/g, '/');
+    while (value.length % 4) {
+        value += '=';
+    }
+    return Uint8Array.from(atob(value), c => c.charCodeAt(0));
+}
+
+function highlight(code, text, syntax) {
+    code.textContent = text;
+    if (typeof hljs === 'undefined') {
+        return;
+    }
+
+    const language = (syntax || '').toLowerCase();
+    if (language === '' || language === 'plaintext' || language === 'plain') {
+        return;
+    }
+    const result = hljs.getLanguage(language) ?
+        hljs.highlight(language, text, true) :
+        hljs.highlightAuto(text);
+    code.innerHTML = result.value;
+    code.classList.add('hljs');
+}
+
+async function decryptPaste() {
+    const message = document.getElementById('message');
+    const data = JSON.parse(document.getElementById('pasteData').textContent);
+    const key = window.location.hash.slice(1);
+    if (!key) {
+        message.textContent = 'This paste is encrypted and the link is missing its key.';
+        return;
+    }
+    if (!window.crypto || !window.crypto.subtle) {
+        message.textContent = 'This browser cannot decrypt pastes, it must be opened over HTTPS.';
+        return;
+    }
+
+    let text;
+    try {
+        const cryptoKey = await crypto.subtle.importKey('raw', base64ToBytes(key), 'AES-GCM', false, ['decrypt']);
+        const plain = await crypto.subtle.decrypt(
+            {name: 'AES-GCM', iv: base64ToBytes(data.paste.iv)}, cryptoKey, base64ToBytes(data.paste.ct));
+        text = new TextDecoder().decode(plain);
+    } catch (error) {
+        console.error('Error decrypting paste:', error);
+        message.textContent = 'The paste could not be decrypted, check that the link is complete.';
+        return;
+    }
+
+    message.remove();
+    highlight(document.getElementById('code'), text, data.syntax);
+
+    const raw = document.getElementById('raw');
+    raw.href = URL.createObjectURL(new Blob([text], {type: 'text/plain;charset=utf-8'}));
+    raw.hidden = false;
+}
+
+decryptPaste();
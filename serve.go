@@ -0,0 +1,232 @@
+package makaroni
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rangeReader reads part of a stored object, like Storage.GetObjectRange
+type rangeReader func(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+
+// objectReadSeeker reads a stored object lazily, issuing a ranged read after every seek
+type objectReadSeeker struct {
+	ctx    context.Context
+	read   rangeReader
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+// Read reads from the current offset, opening a ranged reader if needed
+func (o *objectReadSeeker) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		body, err := o.read(o.ctx, o.key, o.offset, o.size-o.offset)
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	if errors.Is(err, io.EOF) && o.offset < o.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Seek moves the offset, the next Read starts a new ranged read
+func (o *objectReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if offset != o.offset {
+		o.Close()
+		o.offset = offset
+	}
+	return o.offset, nil
+}
+
+// Close releases the current ranged reader
+func (o *objectReadSeeker) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
+
+// pasteKeyFromPath converts a request path into a storage key.
+// Paths under the ResultURLPrefix path are resolved relative to it, others relative to the root.
+func (p *PasteHandler) pasteKeyFromPath(path string) string {
+	if prefix, err := url.Parse(p.ResultURLPrefix); err == nil && strings.HasSuffix(prefix.Path, "/") {
+		if key := strings.TrimPrefix(path, prefix.Path); key != path {
+			return key
+		}
+	}
+	return strings.TrimPrefix(path, "/")
+}
+
+// errorResponder writes an error in the format the client expects
+type errorResponder func(w http.ResponseWriter, statusCode int, message string)
+
+// respondHTMLError sends the HTML error page
+func (p *PasteHandler) respondHTMLError(w http.ResponseWriter, statusCode int, message string) {
+	p.RespondWithError(w, statusCode, message, p.Config)
+}
+
+// handleServeRequest streams a stored paste object to the client
+func (p *PasteHandler) handleServeRequest(w http.ResponseWriter, req *http.Request) {
+	key := p.pasteKeyFromPath(req.URL.Path)
+	if key == "" || isInternalKey(key) {
+		p.RespondWithError(w, http.StatusNotFound, "Paste not found", p.Config)
+		return
+	}
+
+	p.serveObject(w, req, key, p.respondHTMLError)
+}
+
+// serveObject streams the object stored under key, enforcing expiration and view limits
+func (p *PasteHandler) serveObject(w http.ResponseWriter, req *http.Request, key string, fail errorResponder) {
+	info, err := p.Storage.HeadObject(req.Context(), key)
+	if errors.Is(err, ErrObjectNotFound) {
+		if p.isBurned(req.Context(), key) {
+			log.Info("Paste has been burned: ", key)
+			fail(w, http.StatusGone, burnedMessage)
+			return
+		}
+		log.Info("Paste not found: ", key)
+		fail(w, http.StatusNotFound, "Paste not found")
+		return
+	}
+	if err != nil {
+		log.Error("Error retrieving paste info: ", err)
+		fail(w, http.StatusInternalServerError, "Failed to retrieve paste")
+		return
+	}
+
+	if isExpired(info.Metadata, time.Now()) {
+		log.Info("Paste has expired: ", key)
+		fail(w, http.StatusGone, "Paste has expired")
+		return
+	}
+
+	// Checked before the view is counted, a password prompt must not use up a view
+	if isProtected(info.Metadata) && !p.authorizePaste(w, req, key, info.Metadata, fail) {
+		return
+	}
+
+	burnAfterServing := false
+	if info.Metadata[maxViewsMetadataKey] != "" {
+		// Every counted fetch is a complete 200 response, a partial or cached answer must not use up a view
+		for _, header := range []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"} {
+			req.Header.Del(header)
+		}
+		count := req.Method == http.MethodGet && countsAsView(key, info.Metadata)
+		burned, last, err := p.registerView(req.Context(), key, info.Metadata, count)
+		if err != nil {
+			log.Error("Error registering paste view: ", err)
+			fail(w, http.StatusInternalServerError, "Failed to retrieve paste")
+			return
+		}
+		if burned {
+			p.burnPaste(req.Context(), key, info.Metadata)
+			fail(w, http.StatusGone, burnedMessage)
+			return
+		}
+		burnAfterServing = last
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	read, size, etag := p.Storage.GetObjectRange, info.Size, info.ETag
+	if encoded, ok := p.Storage.(EncodedStorage); ok {
+		stored, encoding, err := encoded.HeadEncoded(req.Context(), key)
+		if err != nil {
+			log.Error("Error retrieving paste info: ", err)
+			fail(w, http.StatusInternalServerError, "Failed to retrieve paste")
+			return
+		}
+		if encoding != "" {
+			w.Header().Add("Vary", "Accept-Encoding")
+			// Ranges are served from the decompressed content, they make no sense for a client to decode
+			if req.Header.Get("Range") == "" && acceptsEncoding(req, encoding) {
+				w.Header().Set("Content-Encoding", encoding)
+				read, size, etag = encoded.GetEncodedRange, stored.Size, stored.ETag
+			}
+		}
+	}
+
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	// Uploaded files are served from the paste origin, the browser must not render anything that could run scripts
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if name, ok := attachmentName(info); ok {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	content := &objectReadSeeker{ctx: req.Context(), read: read, key: key, size: size}
+	defer content.Close()
+
+	log.Debug("Serving paste object: ", key)
+	http.ServeContent(w, req, "", info.LastModified, content)
+
+	if burnAfterServing {
+		// The paste must be removed even if the client has already gone away
+		ctx, cancel := context.WithTimeout(context.Background(), burnTimeout)
+		defer cancel()
+		p.burnPaste(ctx, key, info.Metadata)
+	}
+}
+
+// attachmentName returns the file name for the Content-Disposition of an uploaded file that
+// must be downloaded rather than shown, its type is not one a browser displays safely
+func attachmentName(info *ObjectInfo) (string, bool) {
+	name := info.Metadata[filenameMetadataKey]
+	if name == "" || info.Metadata[htmlKeyMetadataKey] == "" {
+		return "", false
+	}
+	mediaType, _, _ := mime.ParseMediaType(info.ContentType)
+	if CanViewInBrowser(mediaType) && !isActiveContent(mediaType) {
+		return "", false
+	}
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	return name, true
+}
+
+// isActiveContent reports whether a viewable type can still carry scripts
+func isActiveContent(mediaType string) bool {
+	switch mediaType {
+	case "text/html", "application/xhtml+xml", "image/svg+xml", "text/xml", "text/javascript":
+		return true
+	}
+	return false
+}
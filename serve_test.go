@@ -0,0 +1,115 @@
+package makaroni
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestServePaste(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	object := pasteCookie(t, serve(handler, newMultipartRequest(t, map[string]string{"content": "0123456789"}, nil)))
+
+	resp := serve(handler, httptest.NewRequest(http.MethodGet, "/"+object.RawKey, nil))
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "0123456789" {
+		t.Fatalf("unexpected raw response %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Content-Type") != contentTypeText || resp.Header.Get("Content-Length") != "10" {
+		t.Fatalf("unexpected raw headers %v", resp.Header)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("ETag header not set")
+	}
+
+	resp = serve(handler, httptest.NewRequest(http.MethodGet, "/"+object.HtmlKey, nil))
+	body, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != contentTypeHTML {
+		t.Fatalf("unexpected html response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if resp.Header.Get("Content-Length") != strconv.Itoa(len(body)) {
+		t.Fatalf("content length %s does not match body size %d", resp.Header.Get("Content-Length"), len(body))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/"+object.RawKey, nil)
+	req.Header.Set("Range", "bytes=2-5")
+	resp = serve(handler, req)
+	body, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "2345" {
+		t.Fatalf("unexpected range response %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Content-Range") != "bytes 2-5/10" {
+		t.Fatalf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/"+object.RawKey, nil)
+	req.Header.Set("If-None-Match", etag)
+	if resp = serve(handler, req); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304 for matching ETag, got %d", resp.StatusCode)
+	}
+
+	resp = serve(handler, httptest.NewRequest(http.MethodHead, "/"+object.RawKey, nil))
+	body, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || len(body) != 0 || resp.Header.Get("Content-Length") != "10" {
+		t.Fatalf("unexpected HEAD response %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestServePasteUnderPrefix(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	handler.ResultURLPrefix = "http://paste.test/pasta/"
+	resp := serve(handler, newMultipartRequest(t, map[string]string{"content": "prefixed"}, nil))
+	object := pasteCookie(t, resp)
+
+	if location := resp.Header.Get("Location"); location != "http://paste.test/pasta/"+object.HtmlKey {
+		t.Fatalf("unexpected redirect location %q", location)
+	}
+
+	resp = serve(handler, httptest.NewRequest(http.MethodGet, "/pasta/"+object.RawKey, nil))
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "prefixed" {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestServeUploadedFiles(t *testing.T) {
+	handler, _ := newTestHandler(t)
+
+	for _, test := range []struct {
+		file        testFile
+		disposition string
+	}{
+		{testFile{field: "file", name: "report.pdf", contentType: "application/pdf", content: "%PDF-1.4"}, ""},
+		{testFile{field: "file", name: "logo.svg", contentType: "image/svg+xml", content: "<svg><script></script></svg>"}, `attachment; filename=logo.svg`},
+		{testFile{field: "file", name: "core dump.bin", contentType: "application/octet-stream", content: "core"}, `attachment; filename="core dump.bin"`},
+	} {
+		file := test.file
+		object := pasteCookie(t, serve(handler, newMultipartRequest(t, nil, &file)))
+		resp := serve(handler, httptest.NewRequest(http.MethodGet, "/"+object.RawKey, nil))
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Content-Type-Options") != "nosniff" {
+			t.Fatalf("%s: unexpected response %d %v", file.name, resp.StatusCode, resp.Header)
+		}
+		if disposition := resp.Header.Get("Content-Disposition"); disposition != test.disposition {
+			t.Errorf("%s: expected Content-Disposition %q, got %q", file.name, test.disposition, disposition)
+		}
+
+		// The download page itself is shown
+		if resp = serve(handler, httptest.NewRequest(http.MethodGet, "/"+object.HtmlKey, nil)); resp.Header.Get("Content-Disposition") != "" {
+			t.Errorf("%s: download page is sent as an attachment", file.name)
+		}
+	}
+}
+
+func TestServeMissingPaste(t *testing.T) {
+	handler, _ := newTestHandler(t)
+
+	resp := serve(handler, httptest.NewRequest(http.MethodGet, "/does-not-exist.html", nil))
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(string(body), "Paste not found") {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,55 @@
+package makaroni
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const maxSlugLength = 128
+
+var (
+	ErrInvalidSlug  = errors.New("invalid slug")
+	ErrReservedSlug = errors.New("reserved slug")
+	ErrSlugTaken    = errors.New("slug is already taken")
+
+	// slugPattern allows letters, digits, dashes and underscores, starting with a letter or digit.
+	// Dots are not allowed so slugs never clash with ".html" pages or file extensions.
+	slugPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+	// reservedSlugs are path names used by makaroni itself or likely to be in the future
+	reservedSlugs = map[string]bool{
+		"admin":    true,
+		"api":      true,
+		"delete":   true,
+		"favicon":  true,
+		"files":    true,
+		"index":    true,
+		"p":        true,
+		"pasta":    true,
+		"raw":      true,
+		"render":   true,
+		"robots":   true,
+		"static":   true,
+		"unlock":   true,
+		"uploads":  true,
+		"login":    true,
+		"logout":   true,
+		"settings": true,
+	}
+)
+
+// validateSlug checks that a user-requested slug is safe to use as a paste ID
+func validateSlug(slug string) error {
+	if len(slug) > maxSlugLength {
+		return fmt.Errorf("%w: longer than %d characters", ErrInvalidSlug, maxSlugLength)
+	}
+	if !slugPattern.MatchString(slug) {
+		return fmt.Errorf("%w: only letters, digits, '-' and '_' are allowed", ErrInvalidSlug)
+	}
+	if reservedSlugs[strings.ToLower(slug)] {
+		return fmt.Errorf("%w: %q", ErrReservedSlug, slug)
+	}
+	return nil
+}
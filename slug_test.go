@@ -0,0 +1,53 @@
+package makaroni
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateSlug(t *testing.T) {
+	for _, slug := range []string{"release-notes-2026-10", "runbook_db", "A1"} {
+		if err := validateSlug(slug); err != nil {
+			t.Errorf("validateSlug(%q): %v", slug, err)
+		}
+	}
+	for _, slug := range []string{"-start", "with space", "dot.html", "../etc", ".hidden", strings.Repeat("a", maxSlugLength+1)} {
+		if err := validateSlug(slug); !errors.Is(err, ErrInvalidSlug) {
+			t.Errorf("validateSlug(%q): expected ErrInvalidSlug, got %v", slug, err)
+		}
+	}
+	for _, slug := range []string{"api", "Static"} {
+		if err := validateSlug(slug); !errors.Is(err, ErrReservedSlug) {
+			t.Errorf("validateSlug(%q): expected ErrReservedSlug, got %v", slug, err)
+		}
+	}
+}
+
+func TestPostPasteWithSlug(t *testing.T) {
+	handler, storage := newTestHandler(t)
+
+	resp := serve(handler, newMultipartRequest(t, map[string]string{"content": "steps", "slug": "release-notes"}, nil))
+	if location := resp.Header.Get("Location"); location != testURLPrefix+"release-notes.html" {
+		t.Fatalf("unexpected redirect location %q", location)
+	}
+	if raw, _ := readObject(t, storage, "release-notes"); raw != "steps" {
+		t.Fatalf("unexpected raw content %q", raw)
+	}
+
+	resp = serve(handler, newMultipartRequest(t, map[string]string{"content": "other", "slug": "release-notes"}, nil))
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusConflict || !strings.Contains(string(body), "already taken") {
+		t.Fatalf("expected 409 for a taken slug, got %d", resp.StatusCode)
+	}
+	if raw, _ := readObject(t, storage, "release-notes"); raw != "steps" {
+		t.Fatal("existing paste was overwritten")
+	}
+
+	resp = serve(handler, newMultipartRequest(t, map[string]string{"content": "x", "slug": "static"}, nil))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a reserved slug, got %d", resp.StatusCode)
+	}
+}
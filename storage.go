@@ -0,0 +1,100 @@
+package makaroni
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	// ErrObjectNotFound is returned by storage backends when the requested key does not exist
+	ErrObjectNotFound = errors.New("object not found")
+)
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	Metadata     map[string]string // Not populated by ListObjects
+}
+
+// ListFunc is called for every object found by ListObjects, returning an error stops the listing
+type ListFunc func(info *ObjectInfo) error
+
+// Storage is implemented by paste storage backends
+type Storage interface {
+	// UploadString stores string content under the key
+	UploadString(ctx context.Context, key string, content string, contentType string, metadata map[string]string) error
+	// UploadReader stores data read from reader under the key
+	UploadReader(ctx context.Context, key string, reader io.Reader, contentType string, metadata map[string]string) error
+	// HeadObject returns object information including its metadata
+	HeadObject(ctx context.Context, key string) (*ObjectInfo, error)
+	// GetMetadata returns the user metadata of an object
+	GetMetadata(ctx context.Context, key string) (map[string]string, error)
+	// UpdateMetadata replaces the user metadata of an existing object
+	UpdateMetadata(ctx context.Context, key string, metadata map[string]string) error
+	// GetObject returns the object content, the caller must close the reader
+	GetObject(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// GetObjectRange returns length bytes of the object starting at offset, a negative length reads to the end
+	GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// DeleteObjects removes multiple objects, missing keys are ignored
+	DeleteObjects(ctx context.Context, keys []string) error
+	// ListObjects calls fn for every object whose key starts with prefix
+	ListObjects(ctx context.Context, prefix string, fn ListFunc) error
+}
+
+// CompletedPart identifies an uploaded part of a multipart upload
+type CompletedPart struct {
+	Number int64
+	ETag   string
+}
+
+// MultipartStorage is implemented by storages that assemble an object from parts uploaded separately.
+// Storages without it are given an emulation by multipartFor.
+type MultipartStorage interface {
+	// CreateMultipartUpload starts an upload of the object under key and returns its ID
+	CreateMultipartUpload(ctx context.Context, key string, contentType string, metadata map[string]string) (string, error)
+	// UploadPart stores a part of the upload and returns its ETag, all parts but the last must be at least 5 MiB
+	UploadPart(ctx context.Context, key string, uploadID string, number int64, data []byte) (string, error)
+	// CompleteMultipartUpload joins the parts, in the given order, into the object
+	CompleteMultipartUpload(ctx context.Context, key string, uploadID string, parts []CompletedPart) error
+	// AbortMultipartUpload discards the upload and its parts
+	AbortMultipartUpload(ctx context.Context, key string, uploadID string) error
+}
+
+// PresignedRequest is a request a client sends to storage directly, without passing through makaroni
+type PresignedRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"` // Headers to send, part of the signature
+	Fields  map[string]string `json:"fields,omitempty"`  // Form fields to send before the file of a POST
+}
+
+// PresignStorage is implemented by storages that let clients read and write objects directly
+type PresignStorage interface {
+	// PresignGet returns a URL reading the object under key
+	PresignGet(key string, expires time.Duration) (string, error)
+	// PresignPut returns a PUT request storing exactly size bytes of contentType under key
+	PresignPut(key string, contentType string, size int64, expires time.Duration) (*PresignedRequest, error)
+	// PresignPost returns a multipart form POST with the same conditions, as used by browser forms
+	PresignPost(key string, contentType string, size int64, expires time.Duration) (*PresignedRequest, error)
+}
+
+// CopyStorage is implemented by storages that copy objects without their content passing through makaroni
+type CopyStorage interface {
+	// CopyObject stores the object under srcKey as dstKey, with contentType and metadata replacing its own
+	CopyObject(ctx context.Context, srcKey string, dstKey string, contentType string, metadata map[string]string) error
+}
+
+// EncodedStorage is implemented by storages that compress objects, so clients accepting the encoding
+// can be sent them as stored
+type EncodedStorage interface {
+	// HeadEncoded returns information on the object as stored and its content encoding, empty if it is stored as is
+	HeadEncoded(ctx context.Context, key string) (*ObjectInfo, string, error)
+	// GetEncodedRange returns length bytes of the object as stored starting at offset
+	GetEncodedRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+}
@@ -0,0 +1,202 @@
+package makaroni
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// storageBackends returns all backends that can run without external services
+func storageBackends(t *testing.T) map[string]Storage {
+	t.Helper()
+
+	fileStorage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("create file storage: %v", err)
+	}
+	encrypted, err := NewEncryptedStorage(NewMemoryStorage(), []MasterKey{testMasterKey("test")})
+	if err != nil {
+		t.Fatalf("create encrypted storage: %v", err)
+	}
+	return map[string]Storage{
+		"memory":     NewMemoryStorage(),
+		"filesystem": fileStorage,
+		"encrypted":  encrypted,
+		"dedup":      NewDedupStorage(NewMemoryStorage(), []byte("secret")),
+		"compressed": NewCompressedStorage(NewMemoryStorage()),
+	}
+}
+
+func TestStorageRoundTrip(t *testing.T) {
+	for name, storage := range storageBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			metadata := map[string]string{"delete": "key"}
+
+			if err := storage.UploadString(ctx, "abc", "content", contentTypeText, metadata); err != nil {
+				t.Fatalf("upload: %v", err)
+			}
+			if err := storage.UploadString(ctx, "abc.html", "<p>content</p>", contentTypeHTML, metadata); err != nil {
+				t.Fatalf("upload html: %v", err)
+			}
+
+			info, err := storage.HeadObject(ctx, "abc")
+			if err != nil {
+				t.Fatalf("head: %v", err)
+			}
+			if info.Size != 7 || info.ContentType != contentTypeText || info.Metadata["delete"] != "key" || info.ETag == "" {
+				t.Fatalf("unexpected object info: %+v", info)
+			}
+
+			reader, _, err := storage.GetObject(ctx, "abc")
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			data, _ := io.ReadAll(reader)
+			reader.Close()
+			if string(data) != "content" {
+				t.Fatalf("unexpected content %q", data)
+			}
+
+			reader, err = storage.GetObjectRange(ctx, "abc", 2, 3)
+			if err != nil {
+				t.Fatalf("get range: %v", err)
+			}
+			data, _ = io.ReadAll(reader)
+			reader.Close()
+			if string(data) != "nte" {
+				t.Fatalf("unexpected range content %q", data)
+			}
+
+			var keys []string
+			err = storage.ListObjects(ctx, "", func(info *ObjectInfo) error {
+				keys = append(keys, info.Key)
+				return nil
+			})
+			if err != nil || len(keys) != 2 || keys[0] != "abc" || keys[1] != "abc.html" {
+				t.Fatalf("unexpected listing %v: %v", keys, err)
+			}
+
+			if err := storage.DeleteObjects(ctx, []string{"abc", "abc.html", "missing"}); err != nil {
+				t.Fatalf("delete: %v", err)
+			}
+			if _, err := storage.GetMetadata(ctx, "abc"); !errors.Is(err, ErrObjectNotFound) {
+				t.Fatalf("expected ErrObjectNotFound, got %v", err)
+			}
+			if _, _, err := storage.GetObject(ctx, "abc.html"); !errors.Is(err, ErrObjectNotFound) {
+				t.Fatalf("expected ErrObjectNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFileStorageRejectsUnsafeKeys(t *testing.T) {
+	storage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("create file storage: %v", err)
+	}
+
+	for _, key := range []string{"", "../escape", "/absolute", "a/../b", `a\b`} {
+		if err := storage.UploadString(context.Background(), key, "x", contentTypeText, nil); err == nil {
+			t.Fatalf("expected key %q to be rejected", key)
+		}
+	}
+}
+
+func TestFileStorageOverwrite(t *testing.T) {
+	root := t.TempDir()
+	storage, err := NewFileStorage(root)
+	if err != nil {
+		t.Fatalf("create file storage: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := storage.UploadString(ctx, "dir/abc", "first", contentTypeText, map[string]string{"version": "1"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	first, _, err := storage.GetObject(ctx, "dir/abc")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer first.Close()
+
+	if err := storage.UploadString(ctx, "dir/abc", "second version", "text/markdown", map[string]string{"version": "2"}); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+	info, err := storage.HeadObject(ctx, "dir/abc")
+	if err != nil || info.Size != 14 || info.ContentType != "text/markdown" || info.Metadata["version"] != "2" {
+		t.Fatalf("content and metadata were not replaced together: %+v, %v", info, err)
+	}
+	// A reader opened before keeps reading the object it opened
+	if data, _ := io.ReadAll(first); string(data) != "first" {
+		t.Fatalf("unexpected content of the replaced object %q", data)
+	}
+
+	if err := storage.UpdateMetadata(ctx, "dir/abc", map[string]string{"version": "3"}); err != nil {
+		t.Fatalf("update metadata: %v", err)
+	}
+	updated, err := storage.HeadObject(ctx, "dir/abc")
+	if err != nil || updated.Metadata["version"] != "3" || updated.ETag != info.ETag || updated.Size != info.Size {
+		t.Fatalf("unexpected object after the metadata update: %+v, %v", updated, err)
+	}
+	if data, _ := readObject(t, storage, "dir/abc"); data != "second version" {
+		t.Fatalf("metadata update changed the content to %q", data)
+	}
+
+	if _, err := storage.HeadObject(ctx, "dir"); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("expected a directory to be no object, got %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(root, fsTempDir)); len(entries) != 0 {
+		t.Fatalf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestFileStoragePartialWrite(t *testing.T) {
+	root := t.TempDir()
+	storage, err := NewFileStorage(root)
+	if err != nil {
+		t.Fatalf("create file storage: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := storage.UploadString(ctx, "abc", "original", contentTypeText, map[string]string{"version": "1"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	broken := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("connection reset")))
+	if err := storage.UploadReader(ctx, "abc", broken, "application/octet-stream", map[string]string{"version": "2"}); err == nil {
+		t.Fatal("expected the failed upload to return an error")
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := storage.UploadString(cancelled, "new", "content", contentTypeText, nil); err == nil {
+		t.Fatal("expected the cancelled upload to return an error")
+	}
+
+	info, err := storage.HeadObject(ctx, "abc")
+	if err != nil || info.ContentType != contentTypeText || info.Metadata["version"] != "1" {
+		t.Fatalf("failed upload changed the object: %+v, %v", info, err)
+	}
+	if data, _ := readObject(t, storage, "abc"); data != "original" {
+		t.Fatalf("failed upload changed the content to %q", data)
+	}
+	if _, err := storage.HeadObject(ctx, "new"); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("cancelled upload created an object: %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(root, fsTempDir)); len(entries) != 0 {
+		t.Fatalf("temporary files left behind: %v", entries)
+	}
+
+	// A file that is not a complete object is reported rather than served
+	if err := os.WriteFile(filepath.Join(root, fsObjectsDir, "stray"), []byte("no trailer"), 0o600); err != nil {
+		t.Fatalf("write stray file: %v", err)
+	}
+	if _, err := storage.HeadObject(ctx, "stray"); err == nil || errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("expected an error for a corrupt object file, got %v", err)
+	}
+}
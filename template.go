@@ -1,14 +1,14 @@
 package makaroni
 
 import (
-	"github.com/alecthomas/chroma/lexers"
-	log "github.com/sirupsen/logrus"
-	"strings"
-)
-
-import (
+	"crypto/sha256"
 	_ "embed"
+	"encoding/base64"
+	"strings"
 	"text/template"
+
+	"github.com/alecthomas/chroma/lexers"
+	log "github.com/sirupsen/logrus"
 )
 
 //go:embed resources/index.gohtml
@@ -17,12 +17,29 @@ var indexHTML []byte
 //go:embed resources/pre.gohtml
 var outputPreHTML []byte
 
+//go:embed resources/preEncrypted.gohtml
+var outputPreEncryptedHTML []byte
+
+// The scripts of the encrypted paste page are inlined, the page must not load anything it has to trust
+
+//go:embed resources/vendor/highlight.min.js
+var highlightScript string
+
+//go:embed resources/decrypt.js
+var decryptScript string
+
 //go:embed resources/fileDownload.gohtml
 var fileDownloadHTML []byte
 
 //go:embed resources/error.gohtml
 var errorHTML []byte
 
+//go:embed resources/created.gohtml
+var createdHTML []byte
+
+//go:embed resources/password.gohtml
+var passwordHTML []byte
+
 // IndexData structure for index page
 type IndexData struct {
 	LogoURL    string
@@ -48,6 +65,50 @@ type PreData struct {
 	FaviconURL  string
 	Content     string
 	DownloadURL string
+	Standalone  bool // Leaves out everything loaded from the network, for pages viewed offline
+}
+
+// EncryptedPreData structure for the page of an end-to-end encrypted paste, decrypted in the browser
+type EncryptedPreData struct {
+	LogoURL    string
+	IndexURL   string
+	FaviconURL string
+	Paste      string // JSON with the EncryptedPaste and its syntax, safe to embed in a script element
+
+	// Set by RenderOutputPreEncrypted
+	HighlightScript       string
+	DecryptScript         string
+	ContentSecurityPolicy string
+}
+
+// encryptedPagePolicy only lets the encrypted paste page run its own inline scripts, allowed by their hashes
+var encryptedPagePolicy = "default-src 'none'; script-src " + scriptHash(highlightScript) + " " + scriptHash(decryptScript) +
+	"; style-src 'unsafe-inline' https://fonts.googleapis.com; font-src https://fonts.gstatic.com; img-src * data:" +
+	"; base-uri 'none'; form-action 'none'"
+
+// scriptHash returns the CSP source allowing an inline script
+func scriptHash(script string) string {
+	sum := sha256.Sum256([]byte(script))
+	return "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+}
+
+// CreatedData structure for the paste created page
+type CreatedData struct {
+	LogoURL    string
+	IndexURL   string
+	FaviconURL string
+	URL        string
+	MaxViews   int
+}
+
+// PasswordData structure for the password prompt of a protected paste
+type PasswordData struct {
+	LogoURL    string
+	IndexURL   string
+	FaviconURL string
+	ActionURL  string
+	Next       string // Path of the paste to return to, escaped by the template
+	Message    string
 }
 
 // ErrorData structure for error page
@@ -140,6 +201,30 @@ func RenderOutputPre(data PreData) ([]byte, error) {
 	return result, err
 }
 
+// RenderOutputPreEncrypted renders the page of an end-to-end encrypted paste
+func RenderOutputPreEncrypted(data EncryptedPreData) ([]byte, error) {
+	log.Info("Rendering encrypted output page template")
+
+	data.HighlightScript = highlightScript
+	data.DecryptScript = decryptScript
+	data.ContentSecurityPolicy = encryptedPagePolicy
+
+	result, err := renderPageWithData(string(outputPreEncryptedHTML), &data)
+	if err == nil {
+		log.WithField("size", len(result)).Debug("Encrypted output pre template successfully rendered")
+	}
+	return result, err
+}
+
+// RenderStandalone highlights the content like a text paste and renders it as a self-contained page
+func RenderStandalone(content, lexer, style string) ([]byte, error) {
+	highlighted, err := highlightContent(content, lexer, style)
+	if err != nil {
+		return nil, err
+	}
+	return RenderOutputPre(PreData{Content: highlighted, Standalone: true})
+}
+
 // RenderFileDownload renders the file download page
 func RenderFileDownload(data FileDownloadData) ([]byte, error) {
 	log.Info("Rendering output pre HTML", data.FileName)
@@ -151,6 +236,28 @@ func RenderFileDownload(data FileDownloadData) ([]byte, error) {
 	return result, err
 }
 
+// RenderCreated renders the page with links to a newly created paste
+func RenderCreated(data CreatedData) ([]byte, error) {
+	log.Info("Rendering paste created page")
+
+	result, err := renderPageWithData(string(createdHTML), &data)
+	if err == nil {
+		log.WithField("size", len(result)).Debug("Paste created template successfully rendered")
+	}
+	return result, err
+}
+
+// RenderPasswordPrompt renders the password prompt of a protected paste
+func RenderPasswordPrompt(data PasswordData) ([]byte, error) {
+	log.Info("Rendering password prompt")
+
+	result, err := renderPageWithData(string(passwordHTML), &data)
+	if err == nil {
+		log.WithField("size", len(result)).Debug("Password prompt template successfully rendered")
+	}
+	return result, err
+}
+
 // CanViewInBrowser Check if file can be viewed in browser by MIME type
 func CanViewInBrowser(contentType string) bool {
 	viewableTypes := []string{
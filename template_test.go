@@ -0,0 +1,56 @@
+package makaroni
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderStandalone(t *testing.T) {
+	page, err := RenderStandalone("SELECT 1;", "sql", "monokai")
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	html := string(page)
+	if !strings.Contains(html, "SELECT</span>") {
+		t.Fatal("content was not highlighted")
+	}
+	for _, external := range []string{"https://", "<img", "Raw file"} {
+		if strings.Contains(html, external) {
+			t.Fatalf("standalone page contains %q", external)
+		}
+	}
+
+	page, err = RenderOutputPre(PreData{LogoURL: "https://paste.test/logo.png", DownloadURL: "raw", Content: "x"})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !strings.Contains(string(page), "https://paste.test/logo.png") || !strings.Contains(string(page), "Raw file") {
+		t.Fatal("paste page lost its header or raw link")
+	}
+}
+
+func TestRenderEncryptedPageRunsOnlyItsOwnScripts(t *testing.T) {
+	page, err := RenderOutputPreEncrypted(EncryptedPreData{LogoURL: "https://paste.test/logo.png", Paste: `{"syntax":"go"}`})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	html := string(page)
+	if strings.Contains(html, "<script src") || strings.Contains(html, "cdnjs") {
+		t.Fatal("encrypted page loads an external script")
+	}
+	if !strings.Contains(html, `content="`+encryptedPagePolicy+`"`) {
+		t.Fatal("encrypted page has no content security policy")
+	}
+
+	scripts := 0
+	for _, part := range strings.Split(html, "<script>")[1:] {
+		script, _, _ := strings.Cut(part, "</script>")
+		if !strings.Contains(encryptedPagePolicy, scriptHash(script)) {
+			t.Fatalf("inline script is not allowed by the policy: %.80q", script)
+		}
+		scripts++
+	}
+	if scripts != 2 {
+		t.Fatalf("expected two inline scripts, got %d", scripts)
+	}
+}
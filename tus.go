@@ -0,0 +1,607 @@
+package makaroni
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	tusUploadsPath = "/api/v1/uploads"
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,expiration,termination"
+	tusContentType = "application/offset+octet-stream"
+	tusKeyPrefix   = ".tus/"
+
+	// tusMinPartSize is the smallest part S3 accepts, except for the last one
+	tusMinPartSize = 5 << 20
+	// tusMaxPartSize bounds the memory a request uses, a part is buffered before it is uploaded
+	tusMaxPartSize = 64 << 20
+	// tusMaxParts is the S3 limit of parts in one multipart upload
+	tusMaxParts = 10000
+	// tusMaxLength is the largest upload that fits in the parts, 640 GiB
+	tusMaxLength = tusMaxPartSize * tusMaxParts
+
+	pasteURLHeader    = "X-Paste-URL"
+	pasteRawURLHeader = "X-Paste-Raw-URL"
+
+	// DefaultUploadExpire is how long an unfinished resumable upload is kept after its last request
+	DefaultUploadExpire = 24 * time.Hour
+)
+
+// tusUpload is the state of a resumable upload, stored as an internal object next to its tail.
+// Received data is collected in the tail until a whole part can be uploaded to storage.
+type tusUpload struct {
+	ID        string
+	Length    int64
+	Offset    int64 // Bytes received, the last TailSize of them are kept in the tail object
+	TailSize  int64
+	PartSize  int64
+	UploadID  string // ID of the storage multipart upload
+	Parts     []CompletedPart
+	FileType  string
+	Metadata  string // Upload-Metadata as sent on creation, without the password
+	Plan      *pastePlan
+	Expires   time.Time
+	Completed bool
+}
+
+func tusStateKey(id string) string {
+	return tusKeyPrefix + id + "/state"
+}
+
+func tusTailKey(id string) string {
+	return tusKeyPrefix + id + "/tail"
+}
+
+// handleTusRequest implements the tus 1.0 resumable upload protocol with the creation,
+// expiration and termination extensions. A finished upload becomes a file paste.
+func (p *PasteHandler) handleTusRequest(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if req.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		if p.Config.MaxUploadSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(p.Config.MaxUploadSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if req.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		p.respondError(w, true, http.StatusPreconditionFailed, "Unsupported tus version")
+		return
+	}
+
+	id := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, tusUploadsPath), "/")
+	switch {
+	case id == "" && req.Method == http.MethodPost:
+		p.createTusUpload(w, req)
+	case id == "":
+		w.Header().Set("Allow", "OPTIONS, POST")
+		p.respondError(w, true, http.StatusMethodNotAllowed, "Method not allowed")
+	case strings.Contains(id, "/"):
+		p.respondError(w, true, http.StatusNotFound, "Upload not found")
+	case req.Method == http.MethodHead:
+		p.headTusUpload(w, req, id)
+	case req.Method == http.MethodPatch:
+		p.patchTusUpload(w, req, id)
+	case req.Method == http.MethodDelete:
+		p.terminateTusUpload(w, req, id)
+	default:
+		w.Header().Set("Allow", "OPTIONS, HEAD, PATCH, DELETE")
+		p.respondError(w, true, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// createTusUpload plans the paste and starts a multipart upload of its raw object.
+// The paste URLs and the delete key are returned right away, the paste appears once the upload is finished.
+func (p *PasteHandler) createTusUpload(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	// Deferred lengths are not supported, the part size depends on the length
+	length, err := strconv.ParseInt(req.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		p.respondError(w, true, http.StatusBadRequest, "Invalid Upload-Length")
+		return
+	}
+	if length > tusMaxLength || (p.Config.MaxUploadSize > 0 && length > p.Config.MaxUploadSize) {
+		log.Warn("Rejected resumable upload of ", length, " bytes")
+		p.respondError(w, true, http.StatusRequestEntityTooLarge, "File is too large")
+		return
+	}
+
+	metadataHeader := req.Header.Get("Upload-Metadata")
+	metadata, err := parseTusMetadata(metadataHeader)
+	if err != nil {
+		log.Warn("Invalid upload metadata: ", err)
+		p.respondError(w, true, http.StatusBadRequest, "Invalid Upload-Metadata")
+		return
+	}
+
+	pr := tusPasteRequest(metadata, length)
+	plan, err := p.planPaste(ctx, pr)
+	if err != nil {
+		status, message := pasteErrorStatus(err)
+		p.respondError(w, true, status, message)
+		return
+	}
+
+	id, err := Base62Generator{Length: 24}.NewID()
+	if err != nil {
+		log.Error("Error generating upload ID: ", err)
+		p.respondError(w, true, http.StatusInternalServerError, "Failed to create upload")
+		return
+	}
+
+	// Only the hash of the delete key is kept, like for any other paste
+	deleteKey := plan.Result.DeleteKey
+	plan.Result.DeleteKey = ""
+
+	upload := &tusUpload{
+		ID:       id,
+		Length:   length,
+		PartSize: tusPartSize(length),
+		FileType: pr.FileType,
+		Metadata: redactTusMetadata(metadataHeader),
+		Plan:     plan,
+		Expires:  p.uploadExpiry(),
+	}
+
+	multipart := multipartFor(p.Storage)
+	upload.UploadID, err = multipart.CreateMultipartUpload(ctx, plan.Result.RawKey, pr.FileType, plan.RawMetadata)
+	if err != nil {
+		log.Error("Error creating multipart upload: ", err)
+		p.respondError(w, true, http.StatusInternalServerError, "Failed to create upload")
+		return
+	}
+	if err := saveTusUpload(ctx, p.Storage, upload); err != nil {
+		log.Error("Error saving upload state: ", err)
+		if err := multipart.AbortMultipartUpload(ctx, plan.Result.RawKey, upload.UploadID); err != nil {
+			log.Error("Error aborting multipart upload: ", err)
+		}
+		p.respondError(w, true, http.StatusInternalServerError, "Failed to create upload")
+		return
+	}
+	log.Infof("Created resumable upload %s of %d bytes for key: %s", id, length, plan.Result.RawKey)
+
+	// An empty file is complete as soon as it is created
+	if length == 0 {
+		if err := p.finishTusUpload(ctx, upload); err != nil {
+			status, message := pasteErrorStatus(err)
+			p.respondError(w, true, status, message)
+			return
+		}
+	}
+
+	w.Header().Set("Location", p.tusURL(id))
+	w.Header().Set(deleteKeyHeader, deleteKey)
+	setTusHeaders(w, upload)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// headTusUpload reports how much of the upload has been received
+func (p *PasteHandler) headTusUpload(w http.ResponseWriter, req *http.Request, id string) {
+	upload, ok := p.resolveTusUpload(w, req.Context(), id)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	setTusHeaders(w, upload)
+	w.WriteHeader(http.StatusOK)
+}
+
+// patchTusUpload appends the request body at the current offset and creates the paste once all data is received
+func (p *PasteHandler) patchTusUpload(w http.ResponseWriter, req *http.Request, id string) {
+	ctx := req.Context()
+
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType != tusContentType {
+		p.respondError(w, true, http.StatusUnsupportedMediaType, "Content-Type must be "+tusContentType)
+		return
+	}
+	offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		p.respondError(w, true, http.StatusBadRequest, "Invalid Upload-Offset")
+		return
+	}
+
+	lock := p.uploadLock(id)
+	if !lock.TryLock() {
+		p.respondError(w, true, http.StatusConflict, "Upload is in progress")
+		return
+	}
+	defer lock.Unlock()
+
+	upload, ok := p.resolveTusUpload(w, ctx, id)
+	if !ok {
+		return
+	}
+	if offset != upload.Offset {
+		p.respondError(w, true, http.StatusConflict, "Upload-Offset does not match")
+		return
+	}
+
+	if !upload.Completed {
+		upload.Expires = p.uploadExpiry()
+		if err := p.appendTusUpload(ctx, upload, req.Body); err != nil {
+			log.Error("Error appending to resumable upload: ", err)
+			p.respondError(w, true, http.StatusInternalServerError, "Failed to store upload")
+			return
+		}
+		if upload.Offset == upload.Length {
+			if err := p.finishTusUpload(ctx, upload); err != nil {
+				status, message := pasteErrorStatus(err)
+				p.respondError(w, true, status, message)
+				return
+			}
+			p.uploadLocks.Delete(id)
+		}
+	}
+
+	setTusHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// terminateTusUpload discards an upload. A paste created from a finished upload is kept.
+func (p *PasteHandler) terminateTusUpload(w http.ResponseWriter, req *http.Request, id string) {
+	lock := p.uploadLock(id)
+	if !lock.TryLock() {
+		p.respondError(w, true, http.StatusConflict, "Upload is in progress")
+		return
+	}
+	defer lock.Unlock()
+
+	upload, ok := p.resolveTusUpload(w, req.Context(), id)
+	if !ok {
+		return
+	}
+	if err := removeTusUpload(req.Context(), p.Storage, upload); err != nil {
+		log.Error("Error terminating resumable upload: ", err)
+		p.respondError(w, true, http.StatusInternalServerError, "Failed to terminate upload")
+		return
+	}
+	p.uploadLocks.Delete(id)
+
+	log.Info("Terminated resumable upload: ", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resolveTusUpload loads an upload, answering 404 when it does not exist or has expired
+func (p *PasteHandler) resolveTusUpload(w http.ResponseWriter, ctx context.Context, id string) (*tusUpload, bool) {
+	upload, err := loadTusUpload(ctx, p.Storage, id)
+	if err == nil && !time.Now().Before(upload.Expires) {
+		err = ErrObjectNotFound
+	}
+	if errors.Is(err, ErrObjectNotFound) {
+		p.respondError(w, true, http.StatusNotFound, "Upload not found")
+		return nil, false
+	}
+	if err != nil {
+		log.Error("Error loading resumable upload: ", err)
+		p.respondError(w, true, http.StatusInternalServerError, "Failed to load upload")
+		return nil, false
+	}
+	return upload, true
+}
+
+// appendTusUpload reads the body into the tail and uploads every full part to storage.
+// The data received is kept even when the client goes away, so the upload can resume from there.
+func (p *PasteHandler) appendTusUpload(ctx context.Context, upload *tusUpload, body io.Reader) error {
+	var buf bytes.Buffer
+	if upload.TailSize > 0 {
+		tail, _, err := p.Storage.GetObject(ctx, tusTailKey(upload.ID))
+		if err != nil {
+			return fmt.Errorf("read tail: %w", err)
+		}
+		_, err = io.CopyN(&buf, tail, upload.TailSize)
+		tail.Close()
+		if err != nil {
+			return fmt.Errorf("read tail: %w", err)
+		}
+	}
+
+	// Data beyond the announced length is ignored
+	body = io.LimitReader(body, upload.Length-upload.Offset)
+
+	var readErr error
+	for readErr == nil && upload.Offset < upload.Length {
+		var n int64
+		n, readErr = io.CopyN(&buf, body, upload.PartSize-int64(buf.Len()))
+		upload.Offset += n
+		upload.TailSize = int64(buf.Len())
+
+		// All parts but the last must be full
+		if buf.Len() == 0 || (int64(buf.Len()) < upload.PartSize && upload.Offset < upload.Length) {
+			continue
+		}
+		if err := p.uploadTusPart(ctx, upload, buf.Bytes()); err != nil {
+			return err
+		}
+		buf.Reset()
+	}
+	if readErr == io.EOF {
+		readErr = nil
+	}
+
+	// The request context is gone when the client disconnected, what was received must be saved regardless
+	saveCtx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+	if buf.Len() > 0 {
+		if err := p.Storage.UploadReader(saveCtx, tusTailKey(upload.ID), bytes.NewReader(buf.Bytes()), "application/octet-stream", nil); err != nil {
+			return fmt.Errorf("store tail: %w", err)
+		}
+	}
+	if err := saveTusUpload(saveCtx, p.Storage, upload); err != nil {
+		return err
+	}
+	if readErr != nil {
+		return fmt.Errorf("read body: %w", readErr)
+	}
+	return nil
+}
+
+// uploadTusPart uploads the data as the next part and records it in the upload state
+func (p *PasteHandler) uploadTusPart(ctx context.Context, upload *tusUpload, data []byte) error {
+	number := int64(len(upload.Parts) + 1)
+	etag, err := multipartFor(p.Storage).UploadPart(ctx, upload.Plan.Result.RawKey, upload.UploadID, number, data)
+	if err != nil {
+		return err
+	}
+
+	upload.Parts = append(upload.Parts, CompletedPart{Number: number, ETag: etag})
+	upload.TailSize = 0
+	return saveTusUpload(ctx, p.Storage, upload)
+}
+
+// finishTusUpload assembles the raw object and stores the file download page, creating the paste
+func (p *PasteHandler) finishTusUpload(ctx context.Context, upload *tusUpload) error {
+	result := upload.Plan.Result
+
+	// Keys are only checked when the upload is created, the slug may have been taken since
+	existing, err := headIfExists(ctx, p.Storage, result.HtmlKey)
+	if err != nil {
+		return &pasteError{http.StatusInternalServerError, "Failed to upload paste", err}
+	}
+	if existing != nil {
+		if err := removeTusUpload(ctx, p.Storage, upload); err != nil {
+			log.Error("Error removing resumable upload: ", err)
+		}
+		return &pasteError{http.StatusConflict, "This slug is already taken", ErrSlugTaken}
+	}
+
+	multipart := multipartFor(p.Storage)
+	if len(upload.Parts) == 0 {
+		// There are no parts to assemble an empty file from
+		if err = multipart.AbortMultipartUpload(ctx, result.RawKey, upload.UploadID); err == nil {
+			err = p.Storage.UploadString(ctx, result.RawKey, "", upload.FileType, upload.Plan.RawMetadata)
+		}
+	} else {
+		err = multipart.CompleteMultipartUpload(ctx, result.RawKey, upload.UploadID, upload.Parts)
+	}
+	if err != nil {
+		log.Error("Error completing resumable upload: ", err)
+		return &pasteError{http.StatusInternalServerError, "Failed to upload paste", err}
+	}
+
+	if err := p.Storage.UploadString(ctx, result.HtmlKey, upload.Plan.HTML, contentTypeHTML, upload.Plan.HtmlMetadata); err != nil {
+		log.Error("Error storing paste page: ", err)
+		p.rollbackPaste(result)
+		return &pasteError{http.StatusInternalServerError, "Failed to upload paste", err}
+	}
+	log.Info("Uploaded raw content with key: ", result.RawKey)
+	log.Info("Uploaded HTML content with key: ", result.HtmlKey)
+
+	// The state is kept until it expires, so clients can still look up the finished upload
+	upload.Completed = true
+	upload.Plan.HTML = ""
+	if err := p.Storage.DeleteObjects(ctx, []string{tusTailKey(upload.ID)}); err != nil {
+		log.Error("Error removing upload tail: ", err)
+	}
+	if err := saveTusUpload(ctx, p.Storage, upload); err != nil {
+		log.Error("Error saving finished upload state: ", err)
+	}
+	return nil
+}
+
+// uploadLock returns the mutex serializing the requests for one upload
+func (p *PasteHandler) uploadLock(id string) *sync.Mutex {
+	lock, _ := p.uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// ForgetUpload drops what the handler keeps in memory for an upload, once the upload is removed from storage
+func (p *PasteHandler) ForgetUpload(id string) {
+	p.uploadLocks.Delete(id)
+}
+
+// uploadExpiry returns when an upload that is active now expires
+func (p *PasteHandler) uploadExpiry() time.Time {
+	ttl := p.Config.UploadExpire
+	if ttl <= 0 {
+		ttl = DefaultUploadExpire
+	}
+	return time.Now().UTC().Add(ttl).Truncate(time.Second)
+}
+
+// tusURL returns the URL of an upload
+func (p *PasteHandler) tusURL(id string) string {
+	return strings.TrimSuffix(p.Config.IndexURL, "/") + tusUploadsPath + "/" + id
+}
+
+// setTusHeaders sets the offset and expiry of the upload and the URLs of its paste
+func setTusHeaders(w http.ResponseWriter, upload *tusUpload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.Expires.Format(http.TimeFormat))
+	w.Header().Set(pasteURLHeader, upload.Plan.Result.URL)
+	w.Header().Set(pasteRawURLHeader, upload.Plan.Result.RawURL)
+}
+
+// tusPartSize returns the part size for an upload, large uploads need larger parts to stay within the part limit.
+// Uploads are at most tusMaxLength long, so the size never exceeds tusMaxPartSize.
+func tusPartSize(length int64) int64 {
+	size := (length + tusMaxParts - 1) / tusMaxParts
+	switch {
+	case size < tusMinPartSize:
+		return tusMinPartSize
+	case size > tusMaxPartSize:
+		return tusMaxPartSize
+	}
+	return size
+}
+
+// tusPasteRequest builds the paste request from the upload metadata. Besides the file name and type,
+// sent as "filename" and "filetype" by tus-js-client, the paste options of the upload form are accepted.
+func tusPasteRequest(metadata map[string]string, length int64) *pasteRequest {
+	pr := &pasteRequest{
+		FileName: metadata["filename"],
+		FileType: metadata["filetype"],
+		FileSize: length,
+		Expire:   metadata["expire"],
+		Views:    metadata["views"],
+		Burn:     metadata["burn"],
+		Slug:     metadata["slug"],
+		Password: metadata["password"],
+	}
+	if pr.FileName == "" {
+		pr.FileName = metadata["name"]
+	}
+	if pr.FileType == "" {
+		pr.FileType = metadata["type"]
+	}
+	pr.normalizeFile()
+	return pr
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma-separated keys, each followed by a base64 value
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+
+		value := ""
+		if len(fields) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid metadata value of %q: %w", fields[0], err)
+			}
+			value = string(decoded)
+		}
+		metadata[fields[0]] = value
+	}
+	return metadata, nil
+}
+
+// redactTusMetadata removes the password from an Upload-Metadata header, only its hash may be stored
+func redactTusMetadata(header string) string {
+	var pairs []string
+	for _, pair := range strings.Split(header, ",") {
+		if fields := strings.Fields(pair); len(fields) == 0 || fields[0] == "password" {
+			continue
+		}
+		pairs = append(pairs, strings.TrimSpace(pair))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// loadTusUpload reads the state of an upload
+func loadTusUpload(ctx context.Context, storage Storage, id string) (*tusUpload, error) {
+	reader, _, err := storage.GetObject(ctx, tusStateKey(id))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	upload := &tusUpload{}
+	if err := json.NewDecoder(reader).Decode(upload); err != nil {
+		return nil, fmt.Errorf("decode upload %s: %w", id, err)
+	}
+	if upload.Plan == nil || upload.Plan.Result == nil {
+		return nil, fmt.Errorf("upload %s has no paste", id)
+	}
+	return upload, nil
+}
+
+// saveTusUpload writes the state of an upload
+func saveTusUpload(ctx context.Context, storage Storage, upload *tusUpload) error {
+	state, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	if err := storage.UploadString(ctx, tusStateKey(upload.ID), string(state), contentTypeJSON, nil); err != nil {
+		return fmt.Errorf("save upload %s: %w", upload.ID, err)
+	}
+	return nil
+}
+
+// removeTusUpload aborts an unfinished upload and deletes its state
+func removeTusUpload(ctx context.Context, storage Storage, upload *tusUpload) error {
+	if !upload.Completed {
+		if err := multipartFor(storage).AbortMultipartUpload(ctx, upload.Plan.Result.RawKey, upload.UploadID); err != nil {
+			return err
+		}
+	}
+	return storage.DeleteObjects(ctx, []string{tusStateKey(upload.ID), tusTailKey(upload.ID)})
+}
+
+// sweepUploads removes the resumable uploads that expired, returning the IDs of those removed
+func sweepUploads(ctx context.Context, storage Storage, now time.Time) ([]string, error) {
+	var expired []*tusUpload
+	err := storage.ListObjects(ctx, tusKeyPrefix, func(info *ObjectInfo) error {
+		if !strings.HasSuffix(info.Key, "/state") {
+			return nil
+		}
+
+		id := strings.TrimSuffix(strings.TrimPrefix(info.Key, tusKeyPrefix), "/state")
+		upload, err := loadTusUpload(ctx, storage, id)
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !now.Before(upload.Expires) {
+			expired = append(expired, upload)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make([]string, 0, len(expired))
+	for _, upload := range expired {
+		if !upload.Completed {
+			log.Info("Removing abandoned resumable upload: ", upload.ID)
+		}
+		if err := removeTusUpload(ctx, storage, upload); err != nil {
+			return removed, err
+		}
+		removed = append(removed, upload.ID)
+	}
+	return removed, nil
+}
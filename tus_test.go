@@ -0,0 +1,207 @@
+package makaroni
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTusRequest builds a tus request with the protocol version header set
+func newTusRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	return req
+}
+
+// createTusUpload starts an upload and returns its path and the creation response
+func createTusUpload(t *testing.T, handler http.Handler, length int, metadata string) (string, *http.Response) {
+	t.Helper()
+
+	req := newTusRequest(http.MethodPost, tusUploadsPath, "")
+	req.Header.Set("Upload-Length", strconv.Itoa(length))
+	req.Header.Set("Upload-Metadata", metadata)
+	resp := serve(handler, req)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	return strings.TrimPrefix(resp.Header.Get("Location"), "http://paste.test"), resp
+}
+
+// patchTusUpload appends a chunk at the offset
+func patchTusUpload(handler http.Handler, path string, offset int, chunk string) *http.Response {
+	req := newTusRequest(http.MethodPatch, path, chunk)
+	req.Header.Set("Content-Type", tusContentType)
+	req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+	return serve(handler, req)
+}
+
+// storedKeys lists the keys with the prefix
+func storedKeys(t *testing.T, storage Storage, prefix string) []string {
+	t.Helper()
+
+	var keys []string
+	if err := storage.ListObjects(context.Background(), prefix, func(info *ObjectInfo) error {
+		keys = append(keys, info.Key)
+		return nil
+	}); err != nil {
+		t.Fatalf("list objects: %v", err)
+	}
+	return keys
+}
+
+func TestTusUploadInChunks(t *testing.T) {
+	handler, storage := newTestHandler(t)
+
+	content := strings.Repeat("0123456789", tusMinPartSize/10+100)
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("backup.tar")) + ",expire " + base64.StdEncoding.EncodeToString([]byte("1d"))
+	path, created := createTusUpload(t, handler, len(content), metadata)
+	deleteKey := created.Header.Get(deleteKeyHeader)
+	if deleteKey == "" || created.Header.Get(pasteURLHeader) == "" {
+		t.Fatal("creation response lacks the paste URL or delete key")
+	}
+
+	// The first chunk stays in the tail, the second fills a part, the third finishes the upload
+	chunks := []string{content[:1000], content[1000 : tusMinPartSize+50], content[tusMinPartSize+50:]}
+	offset := 0
+	for i, chunk := range chunks {
+		resp := patchTusUpload(handler, path, offset, chunk)
+		offset += len(chunk)
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("chunk %d: expected 204, got %d", i, resp.StatusCode)
+		}
+		if got := resp.Header.Get("Upload-Offset"); got != strconv.Itoa(offset) {
+			t.Fatalf("chunk %d: expected offset %d, got %s", i, offset, got)
+		}
+
+		head := serve(handler, newTusRequest(http.MethodHead, path, ""))
+		if head.StatusCode != http.StatusOK || head.Header.Get("Upload-Offset") != strconv.Itoa(offset) {
+			t.Fatalf("chunk %d: HEAD returned %d with offset %s", i, head.StatusCode, head.Header.Get("Upload-Offset"))
+		}
+	}
+	if _, ok := handler.uploadLocks.Load(strings.TrimPrefix(path, tusUploadsPath+"/")); ok {
+		t.Fatal("the lock of the finished upload was kept")
+	}
+
+	paste, err := FindPaste(context.Background(), storage, strings.TrimPrefix(created.Header.Get(pasteURLHeader), testURLPrefix))
+	if err != nil {
+		t.Fatalf("find paste: %v", err)
+	}
+	if !strings.HasSuffix(paste.RawKey, ".tar") || paste.Raw.Metadata[expireMetadataKey] == "" {
+		t.Fatalf("unexpected raw object %s with metadata %v", paste.RawKey, paste.Raw.Metadata)
+	}
+	if raw, _ := readObject(t, storage, paste.RawKey); raw != content {
+		t.Fatalf("raw content differs, got %d bytes instead of %d", len(raw), len(content))
+	}
+	html, _ := readObject(t, storage, paste.HtmlKey)
+	if !strings.Contains(html, "backup.tar") {
+		t.Fatal("file download page does not describe the file")
+	}
+	if err := handler.deletePaste(context.Background(), paste.RawKey, paste.HtmlKey, deleteKey); err != nil {
+		t.Fatalf("delete key from the creation response does not work: %v", err)
+	}
+
+	if keys := storedKeys(t, storage, multipartKeyPrefix); len(keys) != 0 {
+		t.Fatalf("parts were left behind: %v", keys)
+	}
+	if keys := storedKeys(t, storage, tusKeyPrefix); len(keys) != 1 {
+		t.Fatalf("expected only the upload state to remain, got %v", keys)
+	}
+}
+
+func TestTusUploadErrors(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	handler.Config.MaxUploadSize = 100
+	path, _ := createTusUpload(t, handler, 10, "")
+
+	noVersion := httptest.NewRequest(http.MethodHead, path, nil)
+	if resp := serve(handler, noVersion); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 without Tus-Resumable, got %d", resp.StatusCode)
+	}
+
+	tooLarge := newTusRequest(http.MethodPost, tusUploadsPath, "")
+	tooLarge.Header.Set("Upload-Length", "101")
+	if resp := serve(handler, tooLarge); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for a large upload, got %d", resp.StatusCode)
+	}
+
+	handler.Config.MaxUploadSize = 0
+	tooLarge.Header.Set("Upload-Length", strconv.FormatInt(tusMaxLength+1, 10))
+	if resp := serve(handler, tooLarge); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 beyond the part limit, got %d", resp.StatusCode)
+	}
+	if size := tusPartSize(tusMaxLength); size != tusMaxPartSize {
+		t.Fatalf("expected the largest upload to use %d byte parts, got %d", tusMaxPartSize, size)
+	}
+	handler.Config.MaxUploadSize = 100
+
+	if resp := patchTusUpload(handler, path, 5, "hello"); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409 for a wrong offset, got %d", resp.StatusCode)
+	}
+
+	wrongType := newTusRequest(http.MethodPatch, path, "hello")
+	wrongType.Header.Set("Upload-Offset", "0")
+	if resp := serve(handler, wrongType); resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415 for a wrong content type, got %d", resp.StatusCode)
+	}
+
+	if resp := patchTusUpload(handler, tusUploadsPath+"/missing", 0, "hello"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown upload, got %d", resp.StatusCode)
+	}
+
+	options := serve(handler, httptest.NewRequest(http.MethodOptions, tusUploadsPath, nil))
+	if options.StatusCode != http.StatusNoContent || options.Header.Get("Tus-Max-Size") != "100" {
+		t.Fatalf("unexpected OPTIONS response %d, max size %q", options.StatusCode, options.Header.Get("Tus-Max-Size"))
+	}
+}
+
+func TestTusUploadTerminateAndExpire(t *testing.T) {
+	handler, storage := newTestHandler(t)
+	ctx := context.Background()
+
+	terminated, _ := createTusUpload(t, handler, 10, "")
+	patchTusUpload(handler, terminated, 0, "hello")
+	if resp := serve(handler, newTusRequest(http.MethodDelete, terminated, "")); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204 on termination, got %d", resp.StatusCode)
+	}
+	if resp := serve(handler, newTusRequest(http.MethodHead, terminated, "")); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 after termination, got %d", resp.StatusCode)
+	}
+
+	abandoned, _ := createTusUpload(t, handler, 10, "")
+	patchTusUpload(handler, abandoned, 0, "hello")
+	id := strings.TrimPrefix(abandoned, tusUploadsPath+"/")
+	upload, err := loadTusUpload(ctx, storage, id)
+	if err != nil {
+		t.Fatalf("load upload: %v", err)
+	}
+	upload.Expires = time.Now().Add(-time.Minute)
+	if err := saveTusUpload(ctx, storage, upload); err != nil {
+		t.Fatalf("save upload: %v", err)
+	}
+
+	if resp := serve(handler, newTusRequest(http.MethodHead, abandoned, "")); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for an expired upload, got %d", resp.StatusCode)
+	}
+	sweeper := &Sweeper{Storage: storage, Interval: time.Hour, UploadRemoved: handler.ForgetUpload}
+	if deleted, err := sweeper.Sweep(ctx); err != nil || deleted != 1 {
+		t.Fatalf("expected 1 swept upload, got %d: %v", deleted, err)
+	}
+	if _, ok := handler.uploadLocks.Load(id); ok {
+		t.Fatal("the lock of the swept upload was kept")
+	}
+	if _, err := loadTusUpload(ctx, storage, id); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("expired upload state was not removed: %v", err)
+	}
+
+	for _, prefix := range []string{tusKeyPrefix, multipartKeyPrefix} {
+		if keys := storedKeys(t, storage, prefix); len(keys) != 0 {
+			t.Fatalf("objects were left behind: %v", keys)
+		}
+	}
+}
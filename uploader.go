@@ -1,14 +1,24 @@
 package makaroni
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"io"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -29,7 +39,7 @@ type UploaderConfig struct {
 	Timeout             time.Duration // Timeout for operations
 }
 
-// Uploader provides methods for uploading data to S3
+// Uploader is the S3 implementation of Storage
 type Uploader struct {
 	uploader *s3manager.Uploader
 	s3Client *s3.S3
@@ -37,8 +47,12 @@ type Uploader struct {
 	config   UploaderConfig
 }
 
-// UploadFunc defines the function type for uploading content
-type UploadFunc func(key string, content string, contentType string) error
+var (
+	_ Storage          = (*Uploader)(nil)
+	_ MultipartStorage = (*Uploader)(nil)
+	_ PresignStorage   = (*Uploader)(nil)
+	_ CopyStorage      = (*Uploader)(nil)
+)
 
 // NewUploader creates a new uploader instance
 func NewUploader(config UploaderConfig) (*Uploader, error) {
@@ -88,12 +102,12 @@ func NewUploader(config UploaderConfig) (*Uploader, error) {
 }
 
 // UploadString uploads string content to S3
-func (u *Uploader) UploadString(ctx context.Context, key string, content string, contentType string, metadata map[string]*string) error {
+func (u *Uploader) UploadString(ctx context.Context, key string, content string, contentType string, metadata map[string]string) error {
 	return u.UploadReader(ctx, key, strings.NewReader(content), contentType, metadata)
 }
 
 // UploadReader uploads data from io.Reader to S3
-func (u *Uploader) UploadReader(ctx context.Context, key string, reader io.Reader, contentType string, metadata map[string]*string) error {
+func (u *Uploader) UploadReader(ctx context.Context, key string, reader io.Reader, contentType string, metadata map[string]string) error {
 	log.Debugf("Starting upload for key: %s", key)
 
 	// Create a context with timeout if context is not set
@@ -111,7 +125,7 @@ func (u *Uploader) UploadReader(ctx context.Context, key string, reader io.Reade
 	}
 
 	if metadata != nil {
-		input.Metadata = metadata
+		input.Metadata = aws.StringMap(metadata)
 	}
 
 	output, err := u.uploader.UploadWithContext(ctx, input)
@@ -123,8 +137,8 @@ func (u *Uploader) UploadReader(ctx context.Context, key string, reader io.Reade
 	return nil
 }
 
-// GetMetadata retrieves metadata for an object
-func (u *Uploader) GetMetadata(ctx context.Context, key string) (map[string]*string, error) {
+// HeadObject retrieves object information and metadata
+func (u *Uploader) HeadObject(ctx context.Context, key string) (*ObjectInfo, error) {
 	input := &s3.HeadObjectInput{
 		Bucket: aws.String(u.bucket),
 		Key:    aws.String(key),
@@ -132,11 +146,156 @@ func (u *Uploader) GetMetadata(ctx context.Context, key string) (map[string]*str
 
 	result, err := u.s3Client.HeadObjectWithContext(ctx, input)
 	if err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("head %s: %w", key, ErrObjectNotFound)
+		}
 		log.Errorf("Error retrieving metadata for key: %s, error: %v", key, err)
 		return nil, err
 	}
 
-	return result.Metadata, nil
+	return &ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(result.ContentLength),
+		ContentType:  aws.StringValue(result.ContentType),
+		ETag:         aws.StringValue(result.ETag),
+		LastModified: aws.TimeValue(result.LastModified),
+		Metadata:     aws.StringValueMap(result.Metadata),
+	}, nil
+}
+
+// GetMetadata retrieves metadata for an object
+func (u *Uploader) GetMetadata(ctx context.Context, key string) (map[string]string, error) {
+	info, err := u.HeadObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return info.Metadata, nil
+}
+
+// UpdateMetadata replaces object metadata by copying the object onto itself
+func (u *Uploader) UpdateMetadata(ctx context.Context, key string, metadata map[string]string) error {
+	info, err := u.HeadObject(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if err := u.copyObject(ctx, key, key, info.ContentType, metadata); err != nil {
+		if isNotFound(err) {
+			return fmt.Errorf("update %s: %w", key, ErrObjectNotFound)
+		}
+		log.Errorf("Error updating metadata for key: %s, error: %v", key, err)
+		return fmt.Errorf("failed to update metadata for key %s: %w", key, err)
+	}
+	return nil
+}
+
+// CopyObject copies an object within the bucket, replacing its content type and metadata
+func (u *Uploader) CopyObject(ctx context.Context, srcKey string, dstKey string, contentType string, metadata map[string]string) error {
+	if err := u.copyObject(ctx, srcKey, dstKey, contentType, metadata); err != nil {
+		if isNotFound(err) {
+			return fmt.Errorf("copy %s: %w", srcKey, ErrObjectNotFound)
+		}
+		log.Errorf("Error copying key: %s to %s, error: %v", srcKey, dstKey, err)
+		return fmt.Errorf("failed to copy key %s: %w", srcKey, err)
+	}
+	return nil
+}
+
+// copyObject issues a server-side copy, objects of up to 5 GiB can be copied in one request
+func (u *Uploader) copyObject(ctx context.Context, srcKey string, dstKey string, contentType string, metadata map[string]string) error {
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(u.bucket),
+		Key:               aws.String(dstKey),
+		CopySource:        aws.String(u.bucket + "/" + strings.ReplaceAll(url.PathEscape(srcKey), "%2F", "/")),
+		ContentType:       aws.String(contentType),
+		Metadata:          aws.StringMap(metadata),
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+	}
+	_, err := u.s3Client.CopyObjectWithContext(ctx, input)
+	return err
+}
+
+// GetObject retrieves object content from S3
+func (u *Uploader) GetObject(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(u.bucket),
+		Key:    aws.String(key),
+	}
+
+	result, err := u.s3Client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil, fmt.Errorf("get %s: %w", key, ErrObjectNotFound)
+		}
+		log.Errorf("Error retrieving object for key: %s, error: %v", key, err)
+		return nil, nil, err
+	}
+
+	return result.Body, &ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(result.ContentLength),
+		ContentType:  aws.StringValue(result.ContentType),
+		ETag:         aws.StringValue(result.ETag),
+		LastModified: aws.TimeValue(result.LastModified),
+		Metadata:     aws.StringValueMap(result.Metadata),
+	}, nil
+}
+
+// GetObjectRange retrieves a byte range of an object from S3
+func (u *Uploader) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		byteRange += fmt.Sprintf("%d", offset+length-1)
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(u.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(byteRange),
+	}
+
+	result, err := u.s3Client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("get %s: %w", key, ErrObjectNotFound)
+		}
+		log.Errorf("Error retrieving range %s for key: %s, error: %v", byteRange, key, err)
+		return nil, err
+	}
+	return result.Body, nil
+}
+
+// ListObjects iterates over all objects in the bucket with the given prefix
+func (u *Uploader) ListObjects(ctx context.Context, prefix string, fn ListFunc) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(u.bucket),
+	}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+
+	var fnErr error
+	err := u.s3Client.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			info := &ObjectInfo{
+				Key:          aws.StringValue(object.Key),
+				Size:         aws.Int64Value(object.Size),
+				ETag:         aws.StringValue(object.ETag),
+				LastModified: aws.TimeValue(object.LastModified),
+			}
+			if fnErr = fn(info); fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		log.Errorf("Error listing objects with prefix: %s, error: %v", prefix, err)
+		return fmt.Errorf("error listing objects: %w", err)
+	}
+	return nil
 }
 
 // DeleteObjects removes multiple objects from storage in a single request
@@ -188,3 +347,184 @@ func (u *Uploader) DeleteObjects(ctx context.Context, keys []string) error {
 	log.Debugf("Successfully deleted %d objects", len(keys))
 	return nil
 }
+
+// CreateMultipartUpload starts an S3 multipart upload
+func (u *Uploader) CreateMultipartUpload(ctx context.Context, key string, contentType string, metadata map[string]string) (string, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(u.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	}
+	if metadata != nil {
+		input.Metadata = aws.StringMap(metadata)
+	}
+
+	output, err := u.s3Client.CreateMultipartUploadWithContext(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload for key %s: %w", key, err)
+	}
+	log.Debugf("Created multipart upload %s for key: %s", aws.StringValue(output.UploadId), key)
+	return aws.StringValue(output.UploadId), nil
+}
+
+// UploadPart uploads one part of an S3 multipart upload
+func (u *Uploader) UploadPart(ctx context.Context, key string, uploadID string, number int64, data []byte) (string, error) {
+	output, err := u.s3Client.UploadPartWithContext(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(u.bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(number),
+		Body:       bytes.NewReader(data),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d for key %s: %w", number, key, err)
+	}
+	return aws.StringValue(output.ETag), nil
+}
+
+// CompleteMultipartUpload assembles the object from the uploaded parts
+func (u *Uploader) CompleteMultipartUpload(ctx context.Context, key string, uploadID string, parts []CompletedPart) error {
+	completed := make([]*s3.CompletedPart, len(parts))
+	for i, part := range parts {
+		completed[i] = &s3.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int64(part.Number),
+		}
+	}
+
+	_, err := u.s3Client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(u.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload for key %s: %w", key, err)
+	}
+	log.Debugf("Completed multipart upload %s for key: %s", uploadID, key)
+	return nil
+}
+
+// AbortMultipartUpload discards an S3 multipart upload, an unknown upload is not an error
+func (u *Uploader) AbortMultipartUpload(ctx context.Context, key string, uploadID string) error {
+	_, err := u.s3Client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(u.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchUpload {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to abort multipart upload for key %s: %w", key, err)
+	}
+	return nil
+}
+
+// PresignGet presigns a GET request for the object
+func (u *Uploader) PresignGet(key string, expires time.Duration) (string, error) {
+	req, _ := u.s3Client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(u.bucket),
+		Key:    aws.String(key),
+	})
+	signedURL, err := req.Presign(expires)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign download for key %s: %w", key, err)
+	}
+	return signedURL, nil
+}
+
+// PresignPut presigns a PUT request, the content length and type are part of the signature
+func (u *Uploader) PresignPut(key string, contentType string, size int64, expires time.Duration) (*PresignedRequest, error) {
+	req, _ := u.s3Client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:        aws.String(u.bucket),
+		Key:           aws.String(key),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	})
+	signedURL, signedHeaders, err := req.PresignRequest(expires)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign upload for key %s: %w", key, err)
+	}
+
+	presigned := &PresignedRequest{Method: http.MethodPut, URL: signedURL, Headers: map[string]string{}}
+	for name, values := range signedHeaders {
+		// Browsers refuse to set Content-Length explicitly, they send the real length which must match
+		if strings.EqualFold(name, "Content-Length") || len(values) == 0 {
+			continue
+		}
+		presigned.Headers[http.CanonicalHeaderKey(name)] = values[0]
+	}
+	return presigned, nil
+}
+
+// PresignPost builds a form upload with a signed POST policy restricting the key, size and content type
+func (u *Uploader) PresignPost(key string, contentType string, size int64, expires time.Duration) (*PresignedRequest, error) {
+	// Building any bucket request yields the bucket URL in the configured addressing style
+	req, _ := u.s3Client.HeadBucketRequest(&s3.HeadBucketInput{Bucket: aws.String(u.bucket)})
+	if err := req.Build(); err != nil {
+		return nil, fmt.Errorf("failed to build bucket URL: %w", err)
+	}
+	bucketURL := *req.HTTPRequest.URL
+	bucketURL.RawQuery = ""
+
+	region := u.config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+
+	fields := map[string]string{
+		"key":              key,
+		"Content-Type":     contentType,
+		"x-amz-algorithm":  "AWS4-HMAC-SHA256",
+		"x-amz-credential": u.config.KeyID + "/" + date + "/" + region + "/s3/aws4_request",
+		"x-amz-date":       now.Format("20060102T150405Z"),
+	}
+	conditions := []interface{}{
+		map[string]string{"bucket": u.bucket},
+		[]interface{}{"content-length-range", size, size},
+	}
+	for name, value := range fields {
+		conditions = append(conditions, map[string]string{name: value})
+	}
+	policy, err := json.Marshal(map[string]interface{}{
+		"expiration": now.Add(expires).Format("2006-01-02T15:04:05.000Z"),
+		"conditions": conditions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	fields["policy"] = base64.StdEncoding.EncodeToString(policy)
+
+	// Signature Version 4 signing key, derived from the secret for the date, region and service
+	signingKey := hmacSHA256([]byte("AWS4"+u.config.Secret), date)
+	for _, scope := range []string{region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, scope)
+	}
+	fields["x-amz-signature"] = hex.EncodeToString(hmacSHA256(signingKey, fields["policy"]))
+
+	return &PresignedRequest{Method: http.MethodPost, URL: bucketURL.String(), Fields: fields}, nil
+}
+
+// hmacSHA256 computes the HMAC-SHA256 of data
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// isNotFound reports whether an AWS error means the object does not exist
+func isNotFound(err error) bool {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+	switch awsErr.Code() {
+	case "NotFound", s3.ErrCodeNoSuchKey:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package makaroni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	maxViewsMetadataKey = "max-views"
+	viewsMetadataKey    = "views"
+	rawKeyMetadataKey   = "raw"
+	htmlKeyMetadataKey  = "html"
+	filenameMetadataKey = "filename"
+	syntaxMetadataKey   = "syntax"
+
+	// burnedKeyPrefix marks tombstones of burned pastes, kept so they can be told apart from missing ones
+	burnedKeyPrefix = ".burned/"
+	burnedKeepTime  = 7 * 24 * time.Hour
+	burnTimeout     = 30 * time.Second
+	burnedMessage   = "This paste has been burned"
+)
+
+var (
+	ErrInvalidMaxViews = errors.New("invalid view limit")
+)
+
+// parseMaxViews reads the view limit from the "views" field, "burn" is a shortcut for a single view
+func parseMaxViews(views, burn string) (int, error) {
+	if burn != "" && burn != "0" && burn != "false" {
+		return 1, nil
+	}
+	if views == "" {
+		return 0, nil
+	}
+	maxViews, err := strconv.Atoi(views)
+	if err != nil || maxViews < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMaxViews, views)
+	}
+	return maxViews, nil
+}
+
+// isInternalKey reports whether the key belongs to makaroni bookkeeping rather than a paste
+func isInternalKey(key string) bool {
+	return strings.HasPrefix(key, ".")
+}
+
+// pasteKeys returns the raw and HTML keys of the paste an object belongs to
+func pasteKeys(key string, metadata map[string]string) (string, string) {
+	if strings.HasSuffix(key, ".html") {
+		rawKey := metadata[rawKeyMetadataKey]
+		if rawKey == "" {
+			rawKey = strings.TrimSuffix(key, ".html")
+		}
+		return rawKey, key
+	}
+
+	htmlKey := metadata[htmlKeyMetadataKey]
+	if htmlKey == "" {
+		htmlKey = key + ".html"
+	}
+	return key, htmlKey
+}
+
+// countsAsView reports whether serving the object uses up one of the paste views.
+// The landing page of a file paste only links to the file, so it is not counted.
+func countsAsView(key string, metadata map[string]string) bool {
+	return !(strings.HasSuffix(key, ".html") && metadata[filenameMetadataKey] != "")
+}
+
+// registerView checks the view counter of a view-limited paste and increments it when count is set.
+// It returns burned when the paste is already used up, and last when this view is the final one.
+// The read-modify-write is only serialized within this process, instances sharing a bucket can each
+// count the same view, so view-limited pastes need a single instance to be exact.
+func (p *PasteHandler) registerView(ctx context.Context, key string, metadata map[string]string, count bool) (burned bool, last bool, err error) {
+	_, htmlKey := pasteKeys(key, metadata)
+
+	p.viewsMu.Lock()
+	defer p.viewsMu.Unlock()
+
+	counter, err := p.Storage.GetMetadata(ctx, htmlKey)
+	if errors.Is(err, ErrObjectNotFound) {
+		return true, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	maxViews, _ := strconv.Atoi(counter[maxViewsMetadataKey])
+	views, _ := strconv.Atoi(counter[viewsMetadataKey])
+	if maxViews > 0 && views >= maxViews {
+		return true, false, nil
+	}
+	if !count {
+		return false, false, nil
+	}
+
+	// The counter is stored before serving, so a failed burn never allows an extra view
+	views++
+	counter[viewsMetadataKey] = strconv.Itoa(views)
+	if err := p.Storage.UpdateMetadata(ctx, htmlKey, counter); err != nil {
+		return false, false, err
+	}
+	log.Debugf("Registered view %d of %d for paste: %s", views, maxViews, htmlKey)
+	return false, maxViews > 0 && views >= maxViews, nil
+}
+
+// burnPaste deletes both objects of a paste and leaves tombstones behind
+func (p *PasteHandler) burnPaste(ctx context.Context, key string, metadata map[string]string) {
+	rawKey, htmlKey := pasteKeys(key, metadata)
+	log.Info("Burning paste: ", htmlKey)
+
+	if err := p.Storage.DeleteObjects(ctx, []string{rawKey, htmlKey}); err != nil {
+		log.Error("Error deleting burned paste: ", err)
+		return
+	}
+
+	tombstone := map[string]string{
+		expireMetadataKey: time.Now().UTC().Add(burnedKeepTime).Format(time.RFC3339),
+	}
+	for _, k := range []string{rawKey, htmlKey} {
+		if err := p.Storage.UploadString(ctx, burnedKeyPrefix+k, "", contentTypeText, tombstone); err != nil {
+			log.Warn("Error storing burned paste tombstone: ", err)
+		}
+	}
+}
+
+// isBurned reports whether a missing key belonged to a burned paste
+func (p *PasteHandler) isBurned(ctx context.Context, key string) bool {
+	_, err := p.Storage.HeadObject(ctx, burnedKeyPrefix+key)
+	return err == nil
+}
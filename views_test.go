@@ -0,0 +1,100 @@
+package makaroni
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBurnAfterReading(t *testing.T) {
+	handler, storage := newTestHandler(t)
+
+	resp := serve(handler, newMultipartRequest(t, map[string]string{"content": "token", "burn": "on"}, nil))
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "viewed once") {
+		t.Fatalf("expected the created page instead of a redirect, got %d", resp.StatusCode)
+	}
+	object := pasteCookie(t, resp)
+
+	resp = serve(handler, httptest.NewRequest(http.MethodGet, "/"+object.RawKey, nil))
+	body, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "token" {
+		t.Fatalf("first view failed: %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Cache-Control") != "no-store" {
+		t.Fatal("view-limited paste is cacheable")
+	}
+
+	for _, key := range []string{object.RawKey, object.HtmlKey} {
+		if _, err := storage.HeadObject(context.Background(), key); !errors.Is(err, ErrObjectNotFound) {
+			t.Fatalf("object %s not deleted after the last view", key)
+		}
+
+		resp = serve(handler, httptest.NewRequest(http.MethodGet, "/"+key, nil))
+		body, _ = io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusGone || !strings.Contains(string(body), "burned") {
+			t.Fatalf("expected burned page for %s, got %d", key, resp.StatusCode)
+		}
+	}
+}
+
+func TestMaxViewsFileUpload(t *testing.T) {
+	handler, _ := newTestHandler(t)
+
+	file := &testFile{field: "file", name: "dump.bin", contentType: "application/octet-stream", content: "core"}
+	object := pasteCookie(t, serve(handler, newMultipartRequest(t, map[string]string{"views": "2"}, file)))
+
+	// The download page of a file does not use up views
+	for i := 0; i < 3; i++ {
+		if resp := serve(handler, httptest.NewRequest(http.MethodGet, "/"+object.HtmlKey, nil)); resp.StatusCode != http.StatusOK {
+			t.Fatalf("download page view %d failed: %d", i, resp.StatusCode)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if resp := serve(handler, httptest.NewRequest(http.MethodGet, "/"+object.RawKey, nil)); resp.StatusCode != http.StatusOK {
+			t.Fatalf("file view %d failed: %d", i, resp.StatusCode)
+		}
+	}
+	if resp := serve(handler, httptest.NewRequest(http.MethodGet, "/"+object.RawKey, nil)); resp.StatusCode != http.StatusGone {
+		t.Fatalf("expected the file to be burned, got %d", resp.StatusCode)
+	}
+	if resp := serve(handler, httptest.NewRequest(http.MethodGet, "/"+object.HtmlKey, nil)); resp.StatusCode != http.StatusGone {
+		t.Fatalf("expected the download page to be burned, got %d", resp.StatusCode)
+	}
+}
+
+func TestViewLimitedPasteIgnoresRangeRequests(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	object := pasteCookie(t, serve(handler, newMultipartRequest(t, map[string]string{"content": "0123456789", "views": "2"}, nil)))
+
+	req := httptest.NewRequest(http.MethodGet, "/"+object.RawKey, nil)
+	req.Header.Set("Range", "bytes=0-0")
+	resp := serve(handler, req)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "0123456789" {
+		t.Fatalf("expected the full content for a range request, got %d %q", resp.StatusCode, body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/"+object.RawKey, nil)
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	if resp = serve(handler, req); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the full content for a conditional request, got %d", resp.StatusCode)
+	}
+
+	if resp = serve(handler, httptest.NewRequest(http.MethodGet, "/"+object.RawKey, nil)); resp.StatusCode != http.StatusGone {
+		t.Fatalf("expected the paste to be burned after two views, got %d", resp.StatusCode)
+	}
+}
+
+func TestInvalidMaxViews(t *testing.T) {
+	handler, _ := newTestHandler(t)
+
+	resp := serve(handler, newMultipartRequest(t, map[string]string{"content": "x", "views": "-3"}, nil))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+}